@@ -12,6 +12,7 @@ import (
   "io/ioutil"
 
   "github.com/InfinityTools/go-ietools"
+  "golang.org/x/text/encoding"
   "golang.org/x/text/encoding/charmap"
 )
 
@@ -31,6 +32,7 @@ var (
   CRE_V10_KNOWN_SPELLS                  = []int{0x2a0, 4, 0x2a4, 4, 0, 0, 0xc}
   CRE_V10_SPELL_MEM_INFO                = []int{0x2a8, 4, 0x2ac, 4, 0, 0, 0x10}
   CRE_V10_EFFECTS                       = []int{0x2c4, 4, 0x2c8, 4, 0, 0, 0x108}
+  CRE_V10_EFFECTS_V1                    = []int{0x2c4, 4, 0x2c8, 4, 0, 0, 0x30}
   CRE_V10_ITEMS                         = []int{0x2bc, 4, 0x2c0, 4, 0, 0, 0x14}
 
   ITM_V10_HEADERS                       = []int{0x64, 4, 0x68, 2, 0, 0, 0x38}
@@ -235,9 +237,10 @@ func (b *Buffer) GetString(offset, size int, null bool) string {
 // GetStringEx returns a string of given size (in bytes) from the specified offset.
 //
 // If "null" is true, then string stops at the first null-character.
-// Text encoding is specified by cmap. Specify a nil charmap to skip the ANSI decoding operation and read
+// Text encoding is specified by enc, which can be a character map or any other encoding, such as the multi-byte
+// encodings returned by ietools.EncodingByLanguage. Specify a nil encoding to skip the ANSI decoding operation and read
 // raw utf-8 data. Operation is skipped if error state is set.
-func (b *Buffer) GetStringEx(offset, size int, null bool, cmap *charmap.Charmap) string {
+func (b *Buffer) GetStringEx(offset, size int, null bool, enc encoding.Encoding) string {
   if b.err != nil { return "" }
   if size <= 0 { return "" }
   if offset < 0 || offset + size > len(b.buf) { b.err = ietools.ErrOffsetOutOfRange; return "" }
@@ -252,8 +255,8 @@ func (b *Buffer) GetStringEx(offset, size int, null bool, cmap *charmap.Charmap)
     }
   }
   var s string
-  if cmap != nil {
-    s, b.err = ietools.AnsiToUtf8(buf, cmap)
+  if enc = ietools.NormalizeEncoding(enc); enc != nil {
+    s, b.err = ietools.AnsiToUtf8(buf, enc)
   } else {
     s = string(buf)
   }
@@ -346,16 +349,17 @@ func (b *Buffer) PutString(offset, size int, value string) {
 // PutStringEx writes the given string at the specified offset.
 //
 // Only the specified number of charaters will be written. Remaining space in the buffer will be filled with 0.
-// Text encoding is specified by cmap. Specify a nil charmap to skip the ANSI encoding operation and write
-// raw utf-8 data data. Operation is skipped if error state is set.
-func (b *Buffer) PutStringEx(offset, size int, value string, cmap *charmap.Charmap) {
+// Text encoding is specified by enc, which can be a character map or any other encoding, such as the multi-byte
+// encodings returned by ietools.EncodingByLanguage. Specify a nil encoding to skip the ANSI encoding operation and
+// write raw utf-8 data data. Operation is skipped if error state is set.
+func (b *Buffer) PutStringEx(offset, size int, value string, enc encoding.Encoding) {
   if b.err != nil { return }
   if size <= 0 { return }
   if offset < 0 || offset + size > len(b.buf) { b.err = ietools.ErrOffsetOutOfRange; return }
 
   var buf []byte
-  if cmap != nil {
-    buf, b.err = ietools.Utf8ToAnsi(value, cmap)
+  if enc = ietools.NormalizeEncoding(enc); enc != nil {
+    buf, b.err = ietools.Utf8ToAnsi(value, enc)
     if b.err != nil { return }
   } else {
     buf = []byte(value)
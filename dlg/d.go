@@ -0,0 +1,1177 @@
+package dlg
+
+import (
+  "errors"
+  "fmt"
+  "sort"
+  "strconv"
+  "strings"
+
+  "github.com/InfinityTools/go-ietools/tlk"
+)
+
+// Available D token types
+const (
+  tokEOF    = iota
+  tokWord   // keywords, labels, file names and numbers
+  tokString // ~text~, "text", %text% or ~~~~~text~~~~~
+  tokSound  // [SOUND]
+  tokRef    // #123, @123 or !123
+  tokOp     // +, ++, = or ==
+)
+
+// A single token of D source code.
+type dToken struct {
+  kind  int
+  text  string
+  line  int
+}
+
+// TraEntry defines the text of a TRA reference (@123), as provided by WeiDU's TRA files.
+type TraEntry struct {
+  Strref  int32   // strref of entries defined as #123, STRREF_NONE if the entry defines text
+  Text    string  // text of the entry, female text is not supported
+  Sound   string  // optional sound resref
+}
+
+// TraTable maps TRA reference numbers to their entries.
+type TraTable map[int]TraEntry
+
+// Context of a dialog that is created or modified by D source code.
+type dContext struct {
+  dlg     *Dialog
+  labels  map[string]int  // maps state labels to state indices
+  base    int             // number of states that existed before the D source was applied
+}
+
+// A pending reference from a transition to a labeled state.
+type dRef struct {
+  trans *Transition
+  file  string
+  label string
+  line  int
+}
+
+// A pending COPY_TRANS or COPY_TRANS_LATE operation. marker is a placeholder transition in the state's transition
+// list.
+type dCopy struct {
+  marker  *Transition
+  owner   *Dialog       // nil if the operation has been applied
+  file    string
+  label   string
+  line    int
+  late    bool          // COPY_TRANS_LATE: copies transitions after all EXTEND actions have been applied
+  source  []*Transition // COPY_TRANS: transitions of the source state before EXTEND actions have been applied
+  result  []*Transition // the copied transitions, available after the operation has been applied
+}
+
+// A pending EXTEND_TOP or EXTEND_BOTTOM operation.
+type dExtend struct {
+  file      string
+  labels    []string
+  position  int   // -1 to append
+  trans     []*Transition
+  line      int
+}
+
+// A section of CHAIN or INTERJECT text spoken by a single dialog. Conditional sections are skipped if their trigger
+// is false.
+type dChainSection struct {
+  file    string
+  trigger string
+  texts   []int32
+  ctx     *dContext
+  first   int     // index of the first state of the section
+  last    *State  // last state of the section
+}
+
+// A state created by D source code, used to evaluate weights.
+type dState struct {
+  state     *State
+  explicit  bool
+  weight    int
+}
+
+// Used internally. WeiDU D actions that are recognized but not supported by the compiler.
+var dUnsupported = []string{
+  "INTERJECT_COPY_TRANS2", "INTERJECT_COPY_TRANS3", "INTERJECT_COPY_TRANS4", "I_C_T2", "I_C_T3", "I_C_T4",
+  "REPLACE", "SET_WEIGHT", "REPLACE_SAY", "REPLACE_STATE_TRIGGER", "ADD_STATE_TRIGGER", "ADD_TRANS_TRIGGER",
+  "ADD_TRANS_ACTION", "REPLACE_TRANS_TRIGGER", "REPLACE_TRANS_ACTION", "ALTER_TRANS", "REPLACE_TRIGGER_TEXT",
+  "REPLACE_TRIGGER_TEXT_REGEXP", "REPLACE_ACTION_TEXT", "REPLACE_ACTION_TEXT_REGEXP", "REPLACE_ACTION_TEXT_PROCESS",
+  "REPLACE_ACTION_TEXT_PROCESS_REGEXP", "R_A_T_P_R", "EXTEND_TOP_REGEXP", "EXTEND_BOTTOM_REGEXP",
+}
+
+// Used internally. Parser state for compiling D source code.
+type dParser struct {
+  toks      []dToken
+  pos       int
+  table     *tlk.Tlk
+  lookup    func(name string) *Dialog
+  tra       func(index int) (TraEntry, bool)
+  contexts  map[string]*dContext
+  early     map[*State]bool   // states of BEGIN and APPEND_EARLY actions
+  order     []*Dialog
+  refs      []dRef
+  copies    []dCopy
+  extends   []dExtend
+}
+
+
+// DecompileD returns the dialog as WeiDU D source code.
+//
+// table is used to resolve strrefs and may be nil. Set inlineText to emit text as string literals (with sound
+// resref if available). Otherwise strrefs are emitted as #strref, followed by the text in a comment if table is
+// available. States are labeled by their index.
+//
+// Note: Inline text is resolved to the first matching string table entry when compiled, which may differ from the
+// original strref if the string table contains duplicate entries.
+// Operation is skipped if error state is set.
+func (d *Dialog) DecompileD(table *tlk.Tlk, inlineText bool) string {
+  if d.err != nil { return "" }
+
+  var sb strings.Builder
+  sb.WriteString(fmt.Sprintf("// DLG file name: %s\n", d.name))
+  sb.WriteString("BEGIN " + dQuote(d.name))
+  if d.flags != 0 { sb.WriteString(fmt.Sprintf(" %d", d.flags)) }
+  sb.WriteString("\n")
+
+  // weights are only needed if state triggers are not evaluated in state order
+  weights := make(map[int]int)
+  entries := d.EntryStates()
+  if !sort.IntsAreSorted(entries) {
+    for w, idx := range entries { weights[idx] = w }
+  }
+
+  for i, s := range d.states {
+    sb.WriteString("\nIF ")
+    if w, ok := weights[i]; ok { sb.WriteString(fmt.Sprintf("WEIGHT #%d ", w)) }
+    sb.WriteString(fmt.Sprintf("%s THEN BEGIN %d", dQuote(s.Trigger), i))
+    preds := d.Predecessors(i)
+    if len(preds) > 0 {
+      sb.WriteString(" // from:")
+      for _, p := range preds { sb.WriteString(fmt.Sprintf(" %d.%d", p.State, p.Transition)) }
+    }
+    sb.WriteString("\n  SAY " + dText(s.Text, table, inlineText) + "\n")
+
+    for _, t := range s.Transitions {
+      sb.WriteString("  IF " + dQuote(t.Trigger) + " THEN")
+      derived := 0
+      if (t.Flags & TRANS_HAS_TEXT) != 0 {
+        derived |= TRANS_HAS_TEXT
+        sb.WriteString(" REPLY " + dText(t.Text, table, inlineText))
+      }
+      if len(t.Action) > 0 {
+        sb.WriteString(" DO " + dQuote(t.Action))
+      }
+      if (t.Flags & TRANS_HAS_JOURNAL) != 0 {
+        derived |= TRANS_HAS_JOURNAL
+        switch {
+          case (t.Flags & TRANS_SOLVED_QUEST) != 0:
+            derived |= TRANS_SOLVED_QUEST
+            sb.WriteString(" SOLVED_JOURNAL ")
+          case (t.Flags & TRANS_UNSOLVED_QUEST) != 0:
+            derived |= TRANS_UNSOLVED_QUEST
+            sb.WriteString(" UNSOLVED_JOURNAL ")
+          default:
+            sb.WriteString(" JOURNAL ")
+        }
+        sb.WriteString(dText(t.Journal, table, inlineText))
+      }
+      derived |= TRANS_HAS_TRIGGER | TRANS_HAS_ACTION | TRANS_TERMINATES
+      if extra := t.Flags & ^derived; extra != 0 {
+        sb.WriteString(fmt.Sprintf(" FLAGS %d", extra))
+      }
+      switch {
+        case (t.Flags & TRANS_TERMINATES) != 0:
+          sb.WriteString(" EXIT\n")
+        case d.isInternal(t):
+          sb.WriteString(fmt.Sprintf(" GOTO %d\n", t.NextState))
+        default:
+          sb.WriteString(fmt.Sprintf(" EXTERN %s %d\n", dQuote(t.NextDialog), t.NextState))
+      }
+    }
+    sb.WriteString("END\n")
+  }
+
+  return sb.String()
+}
+
+// CompileD compiles WeiDU D source code and returns all dialogs that have been created or modified, in order of
+// their first appearance. TRA references (@123) are not supported. See CompileDEx for details.
+func CompileD(src string, table *tlk.Tlk, lookup func(name string) *Dialog) ([]*Dialog, error) {
+  return CompileDEx(src, table, lookup, nil)
+}
+
+// CompileDEx compiles WeiDU D source code and returns all dialogs that have been created or modified, in order of
+// their first appearance.
+//
+// Supported actions are BEGIN, APPEND, APPEND_EARLY, EXTEND_TOP, EXTEND_BOTTOM, CHAIN, INTERJECT and
+// INTERJECT_COPY_TRANS (I_C_T), including conditional speaker sections. States of APPEND_EARLY actions are added
+// directly after the states of BEGIN actions. COPY_TRANS copies the transitions of the source state as defined before
+// any EXTEND_TOP, EXTEND_BOTTOM or INTERJECT action of the D source is applied. COPY_TRANS_LATE copies them afterwards.
+// Other actions, such as REPLACE, ALTER_TRANS or INTERJECT_COPY_TRANS2, result in an error.
+//
+// Inline text is resolved by table, reusing existing entries of matching text and sound if possible and adding new
+// entries otherwise. Specify a nil table to allow only #strref text references.
+//
+// lookup is called to retrieve existing dialogs that are modified by the D source but not created by it. Specify a
+// nil lookup if only new dialogs are defined. tra is called to resolve TRA references (@123), such as the Get function
+// of a TraTable. Specify a nil tra to reject TRA references.
+func CompileDEx(src string, table *tlk.Tlk, lookup func(name string) *Dialog,
+                tra func(index int) (TraEntry, bool)) ([]*Dialog, error) {
+  toks, err := lexD(src)
+  if err != nil { return nil, err }
+
+  p := dParser{ toks: toks, table: table, lookup: lookup, tra: tra, contexts: make(map[string]*dContext),
+                early: make(map[*State]bool) }
+  if err = p.parse(); err != nil { return nil, err }
+  return p.order, nil
+}
+
+// ParseTra parses the content of a WeiDU TRA file and returns the defined entries. Entries consist of a reference
+// number, followed by "=" and either inline text with optional sound resref or a #strref. Female text is ignored.
+func ParseTra(src string) (TraTable, error) {
+  toks, err := lexD(src)
+  if err != nil { return nil, err }
+
+  p := dParser{ toks: toks }
+  tra := make(TraTable)
+  for p.peek().kind != tokEOF {
+    tok := p.peek()
+    if tok.kind != tokRef || tok.text[0] != '@' { return nil, p.errorf("TRA reference expected") }
+    p.pos++
+    index, _ := strconv.Atoi(tok.text[1:])
+    if !p.isOp("=") { return nil, p.errorf("= expected") }
+    p.pos++
+
+    e := TraEntry{ Strref: STRREF_NONE }
+    if ref := p.peek(); ref.kind == tokRef && ref.text[0] == '#' {
+      p.pos++
+      value, _ := strconv.Atoi(ref.text[1:])
+      e.Strref = int32(value)
+    } else if e.Text, e.Sound, err = p.parseInlineText(); err != nil {
+      return nil, err
+    }
+    tra[index] = e
+  }
+  return tra, nil
+}
+
+// Get returns the entry of the specified TRA reference number. Returns false if the entry is not defined.
+func (t TraTable) Get(index int) (TraEntry, bool) {
+  e, ok := t[index]
+  return e, ok
+}
+
+
+// Used internally. Returns the given text as D string literal with a suitable delimiter.
+func dQuote(s string) string {
+  switch {
+    case !strings.Contains(s, "~"):
+      return "~" + s + "~"
+    case !strings.Contains(s, "\""):
+      return "\"" + s + "\""
+    case !strings.Contains(s, "%"):
+      return "%" + s + "%"
+    default:
+      return "~~~~~" + s + "~~~~~"
+  }
+}
+
+// Used internally. Returns the D representation of the given strref.
+func dText(strref int32, t *tlk.Tlk, inline bool) string {
+  if t == nil || strref < 0 || int(strref) >= t.Count() {
+    return fmt.Sprintf("#%d", strref)
+  }
+  text := dQuote(t.GetString(int(strref)))
+  if snd := t.GetSound(int(strref)); len(snd) > 0 { text += " [" + snd + "]" }
+  if inline { return text }
+  return fmt.Sprintf("#%d /* %s */", strref, strings.Replace(text, "*/", "* /", -1))
+}
+
+// Used internally. Splits D source code into tokens.
+func lexD(src string) ([]dToken, error) {
+  toks := make([]dToken, 0, len(src) / 4)
+  line := 1
+  for i := 0; i < len(src); {
+    c := src[i]
+    switch {
+      case c == '\n':
+        line++
+        i++
+      case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+        i++
+      case strings.HasPrefix(src[i:], "//"):
+        for i < len(src) && src[i] != '\n' { i++ }
+      case strings.HasPrefix(src[i:], "/*"):
+        end := strings.Index(src[i+2:], "*/")
+        if end < 0 { return nil, fmt.Errorf("D line %d: unterminated comment", line) }
+        line += strings.Count(src[i:i+2+end], "\n")
+        i += end + 4
+      case strings.HasPrefix(src[i:], "~~~~~"):
+        end := strings.Index(src[i+5:], "~~~~~")
+        if end < 0 { return nil, fmt.Errorf("D line %d: unterminated string", line) }
+        toks = append(toks, dToken{ tokString, src[i+5:i+5+end], line })
+        line += strings.Count(src[i+5:i+5+end], "\n")
+        i += end + 10
+      case c == '~' || c == '"' || c == '%':
+        end := strings.IndexByte(src[i+1:], c)
+        if end < 0 { return nil, fmt.Errorf("D line %d: unterminated string", line) }
+        toks = append(toks, dToken{ tokString, src[i+1:i+1+end], line })
+        line += strings.Count(src[i+1:i+1+end], "\n")
+        i += end + 2
+      case c == '[':
+        end := strings.IndexByte(src[i+1:], ']')
+        if end < 0 { return nil, fmt.Errorf("D line %d: unterminated sound reference", line) }
+        toks = append(toks, dToken{ tokSound, strings.TrimSpace(src[i+1:i+1+end]), line })
+        i += end + 2
+      case c == '+' || c == '=':
+        if i + 1 < len(src) && src[i+1] == c {
+          toks = append(toks, dToken{ tokOp, src[i:i+2], line })
+          i += 2
+        } else {
+          toks = append(toks, dToken{ tokOp, src[i:i+1], line })
+          i++
+        }
+      default:
+        start := i
+        for i < len(src) && !strings.ContainsRune(" \t\r\n\f\v~\"%[]", rune(src[i])) &&
+            !strings.HasPrefix(src[i:], "//") && !strings.HasPrefix(src[i:], "/*") {
+          i++
+        }
+        word := src[start:i]
+        if (c == '#' || c == '@' || c == '!') && len(word) > 1 {
+          if _, err := strconv.Atoi(word[1:]); err == nil {
+            toks = append(toks, dToken{ tokRef, word, line })
+            continue
+          }
+        }
+        toks = append(toks, dToken{ tokWord, word, line })
+    }
+  }
+  toks = append(toks, dToken{ tokEOF, "", line })
+  return toks, nil
+}
+
+
+// Used internally. Returns the current token without consuming it.
+func (p *dParser) peek() dToken {
+  return p.toks[p.pos]
+}
+
+// Used internally. Returns the current token and advances to the next token.
+func (p *dParser) next() dToken {
+  tok := p.toks[p.pos]
+  if tok.kind != tokEOF { p.pos++ }
+  return tok
+}
+
+// Used internally. Returns whether the current token is the specified keyword (case-insensitive).
+func (p *dParser) isKeyword(keyword string) bool {
+  tok := p.peek()
+  return tok.kind == tokWord && strings.EqualFold(tok.text, keyword)
+}
+
+// Used internally. Returns whether the current token is the specified operator.
+func (p *dParser) isOp(op string) bool {
+  tok := p.peek()
+  return tok.kind == tokOp && tok.text == op
+}
+
+// Used internally. Consumes the current token if it is the specified keyword and returns whether it has been consumed.
+func (p *dParser) acceptKeyword(keyword string) bool {
+  if p.isKeyword(keyword) { p.pos++; return true }
+  return false
+}
+
+// Used internally. Consumes the specified keyword or returns an error.
+func (p *dParser) expectKeyword(keyword string) error {
+  if !p.acceptKeyword(keyword) { return p.errorf("%s expected", keyword) }
+  return nil
+}
+
+// Used internally. Returns an error message with line information of the current token.
+func (p *dParser) errorf(format string, args ...interface{}) error {
+  tok := p.peek()
+  found := tok.text
+  if tok.kind == tokEOF { found = "end of file" }
+  return fmt.Errorf("D line %d: %s (found %q)", tok.line, fmt.Sprintf(format, args...), found)
+}
+
+// Used internally. Parses a string literal.
+func (p *dParser) parseString() (string, error) {
+  if p.peek().kind != tokString { return "", p.errorf("string expected") }
+  return p.next().text, nil
+}
+
+// Used internally. Parses a dialog file name, either as string literal or as plain word.
+func (p *dParser) parseFile() (string, error) {
+  tok := p.peek()
+  if tok.kind != tokString && tok.kind != tokWord { return "", p.errorf("dialog file name expected") }
+  p.pos++
+  return strings.ToUpper(strings.TrimSpace(tok.text)), nil
+}
+
+// Used internally. Parses a state label.
+func (p *dParser) parseLabel() (string, error) {
+  tok := p.peek()
+  if tok.kind != tokString && tok.kind != tokWord && tok.kind != tokRef { return "", p.errorf("state label expected") }
+  p.pos++
+  return tok.text, nil
+}
+
+// Used internally. Parses a numeric value of the form #123 or 123.
+func (p *dParser) parseNumber() (int, error) {
+  tok := p.peek()
+  text := tok.text
+  if tok.kind == tokRef && text[0] == '#' { text = text[1:] }
+  if tok.kind != tokRef && tok.kind != tokWord { return 0, p.errorf("number expected") }
+  value, err := strconv.Atoi(text)
+  if err != nil { return 0, p.errorf("number expected") }
+  p.pos++
+  return value, nil
+}
+
+// Used internally. Parses a text reference and returns the resulting strref.
+func (p *dParser) parseText() (int32, error) {
+  tok := p.peek()
+  switch {
+    case tok.kind == tokRef && tok.text[0] == '#':
+      p.pos++
+      value, _ := strconv.Atoi(tok.text[1:])
+      return int32(value), nil
+    case tok.kind == tokRef && tok.text[0] == '@':
+      if p.tra == nil { return 0, p.errorf("TRA references are not supported") }
+      index, _ := strconv.Atoi(tok.text[1:])
+      e, ok := p.tra(index)
+      if !ok { return 0, p.errorf("TRA reference not found") }
+      p.pos++
+      if e.Strref >= 0 { return e.Strref, nil }
+      if p.table == nil { return 0, fmt.Errorf("D line %d: string table required for TRA text", tok.line) }
+      strref := p.table.ResolveString(e.Text, e.Sound)
+      return int32(strref), p.table.Error()
+    case tok.kind == tokRef && tok.text[0] == '!':
+      p.pos++
+      value, _ := strconv.Atoi(tok.text[1:])
+      text, sound, err := p.parseInlineText()
+      if err != nil { return 0, err }
+      if p.table == nil { return 0, p.errorf("string table required for forced strrefs") }
+      if value < 0 || value >= p.table.Count() { return 0, fmt.Errorf("D line %d: strref out of range: %d", tok.line, value) }
+      e := p.table.GetEntry(value)
+      e.Text, e.Sound = text, sound
+      e.Flags &= ^(tlk.FLAG_TEXT | tlk.FLAG_SOUND)
+      if len(text) > 0 { e.Flags |= tlk.FLAG_TEXT }
+      if len(sound) > 0 { e.Flags |= tlk.FLAG_SOUND }
+      p.table.PutEntry(value, e)
+      return int32(value), p.table.Error()
+    case tok.kind == tokString:
+      text, sound, err := p.parseInlineText()
+      if err != nil { return 0, err }
+      if p.table == nil { return 0, fmt.Errorf("D line %d: string table required for inline text", tok.line) }
+      strref := p.table.ResolveString(text, sound)
+      return int32(strref), p.table.Error()
+    default:
+      return 0, p.errorf("text expected")
+  }
+}
+
+// Used internally. Parses an inline text with optional female text and sound resref. The female text is ignored.
+func (p *dParser) parseInlineText() (text, sound string, err error) {
+  text, err = p.parseString()
+  if err != nil { return }
+  if p.peek().kind == tokSound { sound = p.next().text }
+  if p.peek().kind == tokString {
+    p.pos++
+    if p.peek().kind == tokSound { p.pos++ }
+  }
+  return
+}
+
+
+// Used internally. Parses the token stream and assembles the resulting dialogs.
+func (p *dParser) parse() error {
+  for p.peek().kind != tokEOF {
+    var err error
+    switch {
+      case p.acceptKeyword("BEGIN"):
+        err = p.parseBegin()
+      case p.acceptKeyword("APPEND"):
+        err = p.parseAppend(false)
+      case p.acceptKeyword("APPEND_EARLY"):
+        err = p.parseAppend(true)
+      case p.acceptKeyword("EXTEND_TOP"):
+        err = p.parseExtend(true)
+      case p.acceptKeyword("EXTEND_BOTTOM"):
+        err = p.parseExtend(false)
+      case p.acceptKeyword("CHAIN"):
+        err = p.parseChain()
+      case p.acceptKeyword("INTERJECT"):
+        err = p.parseInterject(false)
+      case p.acceptKeyword("INTERJECT_COPY_TRANS") || p.acceptKeyword("I_C_T"):
+        err = p.parseInterject(true)
+      default:
+        err = p.errorf("D action expected")
+        for _, action := range dUnsupported {
+          if p.isKeyword(action) { err = fmt.Errorf("D line %d: %s is not supported", p.peek().line, action) }
+        }
+    }
+    if err != nil { return err }
+  }
+  p.reorderEarly()
+
+  // COPY_TRANS refers to the source transitions before they are extended
+  for i := range p.copies {
+    c := &p.copies[i]
+    if c.late { continue }
+    idx, err := p.resolveLabel(c.file, c.label)
+    if err != nil { return fmt.Errorf("D line %d: %s", c.line, err.Error()) }
+    src, _ := p.context(c.file, false)
+    c.source = append(make([]*Transition, 0, len(src.dlg.states[idx].Transitions)), src.dlg.states[idx].Transitions...)
+  }
+
+  for _, e := range p.extends {
+    if err := p.applyExtend(e); err != nil { return err }
+  }
+  for _, r := range p.refs {
+    idx, err := p.resolveLabel(r.file, r.label)
+    if err != nil { return fmt.Errorf("D line %d: %s", r.line, err.Error()) }
+    r.trans.NextState = idx
+  }
+  for _, late := range []bool{ false, true } {
+    for i := range p.copies {
+      if p.copies[i].late != late { continue }
+      if err := p.applyCopy(&p.copies[i], 0); err != nil { return err }
+    }
+  }
+  return nil
+}
+
+// Used internally. Returns the context of the specified dialog. A new dialog is created if create is set.
+func (p *dParser) context(file string, create bool) (*dContext, error) {
+  if ctx, ok := p.contexts[file]; ok && !create { return ctx, nil }
+
+  var dlg *Dialog
+  if create {
+    dlg = Create(file)
+  } else if p.lookup != nil {
+    dlg = p.lookup(file)
+  }
+  if dlg == nil { return nil, fmt.Errorf("dialog not found: %s", file) }
+  if dlg.Error() != nil { return nil, dlg.Error() }
+
+  ctx := &dContext{ dlg: dlg, labels: make(map[string]int), base: dlg.StateCount() }
+  if old, ok := p.contexts[file]; ok {
+    for i, d := range p.order {
+      if d == old.dlg { p.order[i] = dlg }
+    }
+  } else {
+    p.order = append(p.order, dlg)
+  }
+  p.contexts[file] = ctx
+  return ctx, nil
+}
+
+// Used internally. Returns the state index referenced by the given dialog file and state label.
+func (p *dParser) resolveLabel(file, label string) (int, error) {
+  ctx, err := p.context(file, false)
+  if err == nil {
+    if idx, ok := ctx.labels[label]; ok { return idx, nil }
+  }
+  idx, nerr := strconv.Atoi(strings.TrimPrefix(label, "#"))
+  if nerr != nil {
+    return 0, fmt.Errorf("unknown state label %q in dialog %s", label, file)
+  }
+  if err == nil && (idx < 0 || idx >= ctx.dlg.StateCount()) {
+    return 0, fmt.Errorf("state %d does not exist in dialog %s", idx, file)
+  }
+  return idx, nil
+}
+
+// Used internally. Parses a BEGIN action.
+func (p *dParser) parseBegin() error {
+  file, err := p.parseFile()
+  if err != nil { return err }
+  ctx, err := p.context(file, true)
+  if err != nil { return err }
+  if tok := p.peek(); tok.kind == tokWord {
+    if flags, err := strconv.Atoi(tok.text); err == nil {
+      p.pos++
+      ctx.dlg.SetFlags(flags)
+    }
+  }
+
+  list := make([]dState, 0)
+  for p.acceptKeyword("IF") {
+    states, err := p.parseState(ctx)
+    if err != nil { return err }
+    list = append(list, states...)
+  }
+  for _, s := range ctx.dlg.states { p.early[s] = true }
+
+  // explicitly weighted states are evaluated first
+  sort.SliceStable(list, func(i, j int) bool {
+    if list[i].explicit != list[j].explicit { return list[i].explicit }
+    return list[i].weight < list[j].weight
+  })
+  for i, s := range list { s.state.Weight = i + 1 }
+  return nil
+}
+
+// Used internally. Parses an APPEND or APPEND_EARLY action. States of APPEND_EARLY are moved behind the states of
+// BEGIN actions after parsing.
+func (p *dParser) parseAppend(early bool) error {
+  p.acceptKeyword("IF_FILE_EXISTS")
+  file, err := p.parseFile()
+  if err != nil { return err }
+  ctx, err := p.context(file, false)
+  if err != nil { return fmt.Errorf("D line %d: %s", p.peek().line, err.Error()) }
+
+  first := ctx.dlg.StateCount()
+  for p.acceptKeyword("IF") {
+    if _, err := p.parseState(ctx); err != nil { return err }
+  }
+  if early {
+    for _, s := range ctx.dlg.states[first:] { p.early[s] = true }
+  }
+  return p.expectKeyword("END")
+}
+
+// Used internally. Moves the states of BEGIN and APPEND_EARLY actions in front of all other states added by the D
+// source and updates state labels and resolved state references accordingly. State weights are not changed.
+func (p *dParser) reorderEarly() {
+  pending := make(map[*Transition]bool)
+  for _, r := range p.refs { pending[r.trans] = true }
+  for _, c := range p.copies { pending[c.marker] = true }
+
+  for _, ctx := range p.contexts {
+    states := ctx.dlg.states[ctx.base:]
+    list := make([]*State, 0, len(states))
+    for _, s := range states {
+      if p.early[s] { list = append(list, s) }
+    }
+    for _, s := range states {
+      if !p.early[s] { list = append(list, s) }
+    }
+
+    remap := make(map[int]int)
+    for i, s := range list {
+      if s != states[i] {
+        for j := range states {
+          if states[j] == s { remap[ctx.base + j] = ctx.base + i; break }
+        }
+      }
+    }
+    if len(remap) == 0 { continue }
+    copy(states, list)
+
+    update := func(trans []*Transition) {
+      for _, t := range trans {
+        if pending[t] || !ctx.dlg.isInternal(t) { continue }
+        if idx, ok := remap[t.NextState]; ok { t.NextState = idx }
+      }
+    }
+    for _, d := range p.order {
+      for _, s := range d.states { update(s.Transitions) }
+    }
+    for _, e := range p.extends { update(e.trans) }
+    for label, idx := range ctx.labels {
+      if n, ok := remap[idx]; ok { ctx.labels[label] = n }
+    }
+  }
+}
+
+// Used internally. Parses an EXTEND_TOP or EXTEND_BOTTOM action. Transitions are added after all states are known.
+func (p *dParser) parseExtend(top bool) error {
+  line := p.peek().line
+  file, err := p.parseFile()
+  if err != nil { return err }
+
+  e := dExtend{ file: file, position: -1, line: line }
+  if top { e.position = 0 }
+  for {
+    tok := p.peek()
+    if tok.kind == tokRef && tok.text[0] == '#' {
+      if e.position, err = p.parseNumber(); err != nil { return err }
+      break
+    }
+    if p.isKeyword("IF") || p.isKeyword("COPY_TRANS") || p.isKeyword("COPY_TRANS_LATE") || p.isKeyword("END") ||
+       tok.kind == tokOp || tok.kind == tokEOF { break }
+    label, err := p.parseLabel()
+    if err != nil { return err }
+    e.labels = append(e.labels, label)
+  }
+  if len(e.labels) == 0 { return p.errorf("state label expected") }
+
+  e.trans, err = p.parseTransitions(nil, file)
+  if err != nil { return err }
+  p.extends = append(p.extends, e)
+  return p.expectKeyword("END")
+}
+
+// Used internally. Adds the transitions of the given EXTEND action to the referenced states.
+func (p *dParser) applyExtend(e dExtend) error {
+  ctx, err := p.context(e.file, false)
+  if err != nil { return fmt.Errorf("D line %d: %s", e.line, err.Error()) }
+
+  for i, label := range e.labels {
+    idx, err := p.resolveLabel(e.file, label)
+    if err != nil { return fmt.Errorf("D line %d: %s", e.line, err.Error()) }
+
+    trans := e.trans
+    if i == 0 {
+      p.retarget(trans, ctx.dlg)
+    } else {
+      // each state receives its own copy of the transitions
+      trans = copyTransitions(e.trans)
+      for j, t := range trans {
+        p.duplicateRefs(e.trans[j], t, ctx.dlg)
+      }
+    }
+    pos := e.position
+    if pos < 0 || pos > len(ctx.dlg.states[idx].Transitions) { pos = len(ctx.dlg.states[idx].Transitions) }
+    ctx.dlg.ExtendTop(idx, pos, trans...)
+    if ctx.dlg.Error() != nil { return ctx.dlg.Error() }
+  }
+  return nil
+}
+
+// Used internally. Registers pending references and COPY_TRANS operations of src for the transition copy dst.
+func (p *dParser) duplicateRefs(src, dst *Transition, owner *Dialog) {
+  for _, r := range p.refs {
+    if r.trans == src {
+      r.trans = dst
+      p.refs = append(p.refs, r)
+      break
+    }
+  }
+  for _, c := range p.copies {
+    if c.marker == src {
+      c.marker, c.owner = dst, owner
+      p.copies = append(p.copies, c)
+      break
+    }
+  }
+}
+
+// Used internally. Parses a single state definition after the IF keyword and adds the resulting states to the dialog.
+// Multiple SAY texts result in a sequence of linked states.
+func (p *dParser) parseState(ctx *dContext) ([]dState, error) {
+  ds := dState{}
+  if p.acceptKeyword("WEIGHT") {
+    w, err := p.parseNumber()
+    if err != nil { return nil, err }
+    ds.explicit, ds.weight = true, w
+  }
+  trigger, err := p.parseString()
+  if err != nil { return nil, err }
+  p.acceptKeyword("THEN")
+  p.acceptKeyword("BEGIN")
+  label, err := p.parseLabel()
+  if err != nil { return nil, err }
+  if err = p.expectKeyword("SAY"); err != nil { return nil, err }
+
+  texts, err := p.parseTexts()
+  if err != nil { return nil, err }
+  trans, err := p.parseTransitions(ctx.dlg, ctx.dlg.name)
+  if err != nil { return nil, err }
+  if err = p.expectKeyword("END"); err != nil { return nil, err }
+
+  s := &State{ Text: texts[0], Trigger: trigger }
+  if ds.explicit { s.Weight = ds.weight + 1 }
+  ctx.labels[label] = p.addStates(ctx, s, texts[1:], trans)
+  ds.state = s
+  if ctx.dlg.Error() != nil { return nil, ctx.dlg.Error() }
+  return []dState{ ds }, nil
+}
+
+// Used internally. Parses one or more texts separated by "=".
+func (p *dParser) parseTexts() ([]int32, error) {
+  texts := make([]int32, 0, 1)
+  for {
+    strref, err := p.parseText()
+    if err != nil { return nil, err }
+    texts = append(texts, strref)
+    if !p.isOp("=") { break }
+    p.pos++
+  }
+  return texts, nil
+}
+
+// Used internally. Adds the first state and additional states for each text of more. The last state receives the
+// given transitions. Returns the index of the first state.
+func (p *dParser) addStates(ctx *dContext, first *State, more []int32, trans []*Transition) int {
+  idx := ctx.dlg.AddState(first)
+  cur := first
+  for _, text := range more {
+    next := &State{ Text: text }
+    nextIdx := ctx.dlg.StateCount()
+    cur.Transitions = []*Transition{ &Transition{ Text: STRREF_NONE, Journal: STRREF_NONE, NextDialog: ctx.dlg.name, NextState: nextIdx } }
+    ctx.dlg.AddState(next)
+    cur = next
+  }
+  cur.Transitions = trans
+  p.retarget(trans, ctx.dlg)
+  return idx
+}
+
+// Used internally. Assigns the owning dialog to pending COPY_TRANS operations of the given transitions.
+func (p *dParser) retarget(trans []*Transition, owner *Dialog) {
+  for _, t := range trans {
+    for i := range p.copies {
+      if p.copies[i].marker == t { p.copies[i].owner = owner }
+    }
+  }
+}
+
+// Used internally. Parses a list of transitions until the END keyword. home defines the target dialog of GOTO.
+func (p *dParser) parseTransitions(owner *Dialog, home string) ([]*Transition, error) {
+  list := make([]*Transition, 0)
+  for {
+    t := &Transition{ Text: STRREF_NONE, Journal: STRREF_NONE }
+    var err error
+    switch {
+      case p.acceptKeyword("IF"):
+        if t.Trigger, err = p.parseString(); err != nil { return nil, err }
+        p.acceptKeyword("THEN")
+      case p.isOp("+"):
+        p.pos++
+        if t.Trigger, err = p.parseString(); err != nil { return nil, err }
+        if !p.isOp("+") { return nil, p.errorf("+ expected") }
+        p.pos++
+        if t.Text, err = p.parseText(); err != nil { return nil, err }
+        t.Flags |= TRANS_HAS_TEXT
+      case p.isOp("++"):
+        p.pos++
+        if t.Text, err = p.parseText(); err != nil { return nil, err }
+        t.Flags |= TRANS_HAS_TEXT
+      case p.isKeyword("COPY_TRANS") || p.isKeyword("COPY_TRANS_LATE"):
+        if err = p.parseCopy(t, owner); err != nil { return nil, err }
+        list = append(list, t)
+        continue
+      default:
+        return list, nil
+    }
+
+    if err = p.parseTransitionFeatures(t); err != nil { return nil, err }
+    if err = p.parseTransitionTarget(t, home); err != nil { return nil, err }
+    list = append(list, t)
+  }
+}
+
+// Used internally. Parses optional REPLY, DO, JOURNAL and FLAGS elements of a transition.
+func (p *dParser) parseTransitionFeatures(t *Transition) error {
+  var err error
+  for {
+    switch {
+      case p.acceptKeyword("REPLY"):
+        if t.Text, err = p.parseText(); err != nil { return err }
+        t.Flags |= TRANS_HAS_TEXT
+      case p.acceptKeyword("DO"):
+        if t.Action, err = p.parseString(); err != nil { return err }
+      case p.acceptKeyword("JOURNAL"):
+        if t.Journal, err = p.parseText(); err != nil { return err }
+        t.Flags |= TRANS_HAS_JOURNAL
+      case p.acceptKeyword("SOLVED_JOURNAL"):
+        if t.Journal, err = p.parseText(); err != nil { return err }
+        t.Flags |= TRANS_HAS_JOURNAL | TRANS_SOLVED_QUEST
+      case p.acceptKeyword("UNSOLVED_JOURNAL"):
+        if t.Journal, err = p.parseText(); err != nil { return err }
+        t.Flags |= TRANS_HAS_JOURNAL | TRANS_UNSOLVED_QUEST
+      case p.acceptKeyword("FLAGS"):
+        flags, err := p.parseNumber()
+        if err != nil { return err }
+        t.Flags |= flags
+      default:
+        return nil
+    }
+  }
+}
+
+// Used internally. Parses the target of a transition. home defines the target dialog of GOTO.
+func (p *dParser) parseTransitionTarget(t *Transition, home string) error {
+  line := p.peek().line
+  var file, label string
+  var err error
+  switch {
+    case p.acceptKeyword("EXIT"):
+      t.Flags |= TRANS_TERMINATES
+      return nil
+    case p.acceptKeyword("GOTO"):
+      file = home
+      label, err = p.parseLabel()
+    case p.isOp("+"):
+      p.pos++
+      file = home
+      label, err = p.parseLabel()
+    case p.acceptKeyword("EXTERN"):
+      p.acceptKeyword("IF_FILE_EXISTS")
+      if file, err = p.parseFile(); err != nil { return err }
+      label, err = p.parseLabel()
+    default:
+      return p.errorf("transition target expected")
+  }
+  if err != nil { return err }
+  t.NextDialog = file
+  p.refs = append(p.refs, dRef{ trans: t, file: file, label: label, line: line })
+  return nil
+}
+
+// Used internally. Parses a CHAIN action.
+func (p *dParser) parseChain() error {
+  first := &State{}
+  if p.acceptKeyword("IF") {
+    if p.acceptKeyword("WEIGHT") {
+      w, err := p.parseNumber()
+      if err != nil { return err }
+      first.Weight = w + 1
+    }
+    trigger, err := p.parseString()
+    if err != nil { return err }
+    first.Trigger = trigger
+    if err = p.expectKeyword("THEN"); err != nil { return err }
+  }
+  file, err := p.parseFile()
+  if err != nil { return err }
+  label, err := p.parseLabel()
+  if err != nil { return err }
+
+  sections, err := p.parseChainSections(file, false)
+  if err != nil { return err }
+  if err = p.buildChain(sections, first); err != nil { return err }
+  sections[0].ctx.labels[label] = sections[0].first
+
+  epilogue, err := p.parseChainEpilogue(sections[len(sections) - 1].ctx.dlg.name)
+  if err != nil { return err }
+  p.linkChain(sections, epilogue)
+  return nil
+}
+
+// Used internally. Parses an INTERJECT or INTERJECT_COPY_TRANS action. The chain is entered from the interjected state
+// if the global variable is not set yet, and sets the variable.
+func (p *dParser) parseInterject(copyTrans bool) error {
+  line := p.peek().line
+  file, err := p.parseFile()
+  if err != nil { return err }
+  label, err := p.parseLabel()
+  if err != nil { return err }
+  tok := p.peek()
+  if tok.kind != tokWord && tok.kind != tokString { return p.errorf("global variable expected") }
+  p.pos++
+
+  sections, err := p.parseChainSections(file, true)
+  if err != nil { return err }
+  if err = p.buildChain(sections, nil); err != nil { return err }
+
+  var epilogue []*Transition
+  if copyTrans {
+    // continues with the original transitions of the interjected state
+    if err = p.expectKeyword("END"); err != nil { return err }
+    t := &Transition{ Text: STRREF_NONE, Journal: STRREF_NONE }
+    p.copies = append(p.copies, dCopy{ marker: t, file: file, label: label, line: line })
+    epilogue = []*Transition{ t }
+  } else if epilogue, err = p.parseChainEpilogue(sections[len(sections) - 1].ctx.dlg.name); err != nil {
+    return err
+  }
+  p.linkChain(sections, epilogue)
+
+  entries, _ := chainLinks(sections, -1)
+  for _, t := range entries {
+    trigger := fmt.Sprintf("Global(\"%s\",\"GLOBAL\",0)", tok.text)
+    if len(t.Trigger) > 0 { trigger += "\n" + t.Trigger }
+    t.Trigger = trigger
+    t.Action = fmt.Sprintf("SetGlobal(\"%s\",\"GLOBAL\",1)", tok.text)
+  }
+  p.extends = append(p.extends, dExtend{ file: file, labels: []string{ label }, position: 0, trans: entries, line: line })
+  return nil
+}
+
+// Used internally. Parses the text sections of a CHAIN or INTERJECT action. file defines the speaker of the first
+// section. The first section of INTERJECT may be conditional and may specify the speaker explicitly.
+func (p *dParser) parseChainSections(file string, interject bool) ([]dChainSection, error) {
+  list := make([]dChainSection, 0, 1)
+  explicit := interject && p.isOp("==")
+  for {
+    var err error
+    if explicit || len(list) > 0 {
+      if !p.isOp("==") { return list, nil }
+      p.pos++
+      p.acceptKeyword("IF_FILE_EXISTS")
+      if file, err = p.parseFile(); err != nil { return nil, err }
+    }
+
+    s := dChainSection{ file: file }
+    if p.acceptKeyword("IF") {
+      if len(list) == 0 && !interject { return nil, p.errorf("first CHAIN section cannot be conditional") }
+      if s.trigger, err = p.parseString(); err != nil { return nil, err }
+      p.acceptKeyword("THEN")
+    }
+    if s.texts, err = p.parseTexts(); err != nil { return nil, err }
+    list = append(list, s)
+  }
+}
+
+// Used internally. Adds the states of all chain sections to their dialogs and links the states within each section.
+// first is used as the first state of the chain if specified.
+func (p *dParser) buildChain(sections []dChainSection, first *State) error {
+  for i := range sections {
+    sec := &sections[i]
+    ctx, err := p.context(sec.file, false)
+    if err != nil { return fmt.Errorf("D line %d: %s", p.peek().line, err.Error()) }
+    sec.ctx = ctx
+    for j, text := range sec.texts {
+      s := &State{}
+      if i == 0 && j == 0 && first != nil { s = first }
+      s.Text = text
+      idx := ctx.dlg.AddState(s)
+      if j == 0 {
+        sec.first = idx
+      } else {
+        sec.last.Transitions = []*Transition{ &Transition{ Text: STRREF_NONE, Journal: STRREF_NONE, NextDialog: ctx.dlg.name, NextState: idx } }
+      }
+      sec.last = s
+    }
+    if ctx.dlg.Error() != nil { return ctx.dlg.Error() }
+  }
+  return nil
+}
+
+// Used internally. Assigns the transitions of the last state of each chain section. Sections continue with the
+// following sections until the first unconditional one. The epilogue transitions are added if all following
+// sections are conditional.
+func (p *dParser) linkChain(sections []dChainSection, epilogue []*Transition) {
+  placed := false
+  for i := range sections {
+    sec := &sections[i]
+    trans, fallback := chainLinks(sections, i)
+    if fallback {
+      if placed {
+        // each state receives its own copy of the transitions
+        list := copyTransitions(epilogue)
+        for j, t := range list {
+          p.duplicateRefs(epilogue[j], t, sec.ctx.dlg)
+        }
+        trans = append(trans, list...)
+      } else {
+        p.retarget(epilogue, sec.ctx.dlg)
+        trans = append(trans, epilogue...)
+        placed = true
+      }
+    }
+    sec.last.Transitions = trans
+  }
+}
+
+// Used internally. Returns transitions from the chain section at index from to the following sections, up to and
+// including the first unconditional section. Returns true if all following sections are conditional.
+func chainLinks(sections []dChainSection, from int) ([]*Transition, bool) {
+  list := make([]*Transition, 0)
+  for _, sec := range sections[from+1:] {
+    list = append(list, &Transition{ Text: STRREF_NONE, Journal: STRREF_NONE, Trigger: sec.trigger,
+                                     NextDialog: sec.ctx.dlg.name, NextState: sec.first })
+    if len(sec.trigger) == 0 { return list, false }
+  }
+  return list, true
+}
+
+// Used internally. Parses a CHAIN epilogue and returns the resulting transitions. home defines the target dialog of
+// GOTO.
+func (p *dParser) parseChainEpilogue(home string) ([]*Transition, error) {
+  t := &Transition{ Text: STRREF_NONE, Journal: STRREF_NONE }
+  switch {
+    case p.acceptKeyword("EXIT"):
+      t.Flags |= TRANS_TERMINATES
+    case p.isKeyword("COPY_TRANS") || p.isKeyword("COPY_TRANS_LATE"):
+      if err := p.parseCopy(t, nil); err != nil { return nil, err }
+    case p.acceptKeyword("EXTERN"):
+      if err := p.parseChainTarget(t); err != nil { return nil, err }
+    case p.acceptKeyword("END"):
+      if p.isKeyword("IF") || p.isKeyword("COPY_TRANS") || p.isKeyword("COPY_TRANS_LATE") || p.isOp("+") || p.isOp("++") {
+        trans, err := p.parseTransitions(nil, home)
+        if err != nil { return nil, err }
+        return trans, p.expectKeyword("END")
+      }
+      if err := p.parseChainTarget(t); err != nil { return nil, err }
+    default:
+      return nil, p.errorf("CHAIN epilogue expected")
+  }
+  return []*Transition{ t }, nil
+}
+
+// Used internally. Parses a COPY_TRANS or COPY_TRANS_LATE action and registers marker as its placeholder transition.
+func (p *dParser) parseCopy(marker *Transition, owner *Dialog) error {
+  late := strings.EqualFold(p.next().text, "COPY_TRANS_LATE")
+  p.acceptKeyword("SAFE")
+  line := p.peek().line
+  file, err := p.parseFile()
+  if err != nil { return err }
+  label, err := p.parseLabel()
+  if err != nil { return err }
+  p.copies = append(p.copies, dCopy{ marker: marker, owner: owner, file: file, label: label, line: line, late: late })
+  return nil
+}
+
+// Used internally. Parses the dialog file and state label of a CHAIN epilogue.
+func (p *dParser) parseChainTarget(t *Transition) error {
+  line := p.peek().line
+  file, err := p.parseFile()
+  if err != nil { return err }
+  label, err := p.parseLabel()
+  if err != nil { return err }
+  t.NextDialog = file
+  p.refs = append(p.refs, dRef{ trans: t, file: file, label: label, line: line })
+  return nil
+}
+
+// Used internally. Replaces the placeholder transition of a COPY_TRANS operation by copies of the transitions of the
+// referenced state.
+func (p *dParser) applyCopy(c *dCopy, depth int) error {
+  if c.owner == nil { return nil }   // already applied
+  if depth > len(p.copies) { return fmt.Errorf("D line %d: recursive COPY_TRANS", c.line) }
+
+  src, err := p.context(c.file, false)
+  if err != nil { return fmt.Errorf("D line %d: %s", c.line, err.Error()) }
+  list := c.source
+  if c.late {
+    idx, err := p.resolveLabel(c.file, c.label)
+    if err != nil { return fmt.Errorf("D line %d: %s", c.line, err.Error()) }
+    list = src.dlg.states[idx].Transitions
+  }
+
+  trans := make([]*Transition, 0, len(list))
+  for _, t := range list {
+    // source state may contain COPY_TRANS operations itself
+    if other := p.findCopy(t); other != nil {
+      if other == c { return fmt.Errorf("D line %d: recursive COPY_TRANS", c.line) }
+      if err := p.applyCopy(other, depth + 1); err != nil { return err }
+      trans = append(trans, copyTransitions(other.result)...)
+      continue
+    }
+    tc := *t
+    // GOTO targets of the source state refer to the source dialog
+    if (tc.Flags & TRANS_TERMINATES) == 0 && len(tc.NextDialog) == 0 { tc.NextDialog = src.dlg.name }
+    trans = append(trans, &tc)
+  }
+
+  for _, s := range c.owner.states {
+    for i, t := range s.Transitions {
+      if t == c.marker {
+        list := make([]*Transition, 0, len(s.Transitions) + len(trans))
+        list = append(list, s.Transitions[:i]...)
+        list = append(list, trans...)
+        list = append(list, s.Transitions[i+1:]...)
+        s.Transitions = list
+        c.owner, c.result = nil, trans
+        return nil
+      }
+    }
+  }
+  return errors.New("COPY_TRANS target not found")
+}
+
+// Used internally. Returns the COPY_TRANS operation of the given placeholder transition, nil if t is no placeholder.
+func (p *dParser) findCopy(t *Transition) *dCopy {
+  for i := range p.copies {
+    if p.copies[i].marker == t { return &p.copies[i] }
+  }
+  return nil
+}
@@ -0,0 +1,599 @@
+/*
+Package dlg provides a graph-based model for DLG V1.0 dialog resources. It is built on top of the buffers package.
+
+States, transitions, triggers and actions are exposed as linked Go objects. Index tables for states, transitions,
+triggers and actions are rebuilt from scratch when the dialog is saved.
+*/
+package dlg
+
+import (
+  "errors"
+  "fmt"
+  "io"
+  "sort"
+  "strings"
+
+  "github.com/InfinityTools/go-ietools"
+  "github.com/InfinityTools/go-ietools/buffers"
+  "golang.org/x/text/encoding"
+  "golang.org/x/text/encoding/charmap"
+)
+
+const (
+  // Available transition flags
+  TRANS_HAS_TEXT        = ietools.BIT0  // Transition provides player text
+  TRANS_HAS_TRIGGER     = ietools.BIT1  // Transition provides a trigger (set automatically on save)
+  TRANS_HAS_ACTION      = ietools.BIT2  // Transition provides an action (set automatically on save)
+  TRANS_TERMINATES      = ietools.BIT3  // Transition terminates the dialog
+  TRANS_HAS_JOURNAL     = ietools.BIT4  // Transition provides a journal entry
+  TRANS_INTERRUPT       = ietools.BIT5  // Interrupt
+  TRANS_UNSOLVED_QUEST  = ietools.BIT6  // Journal entry is added to the unsolved quests section
+  TRANS_JOURNAL_NOTE    = ietools.BIT7  // Journal entry is added to the notes section
+  TRANS_SOLVED_QUEST    = ietools.BIT8  // Journal entry is added to the solved quests section
+  TRANS_IMMEDIATE       = ietools.BIT9  // Actions are executed immediately (EE only)
+  TRANS_CLEAR_ACTIONS   = ietools.BIT10 // Clears the action queue (EE only)
+
+  // Strref value indicating that no text is assigned
+  STRREF_NONE           = -1
+
+  headerSize            = 0x30
+  headerSizeFlags       = 0x34
+  stateSize             = 0x10
+  transitionSize        = 0x20
+  entrySize             = 0x08
+)
+
+// Dialog contains the necessary information to query or alter DLG V1.0 dialog data.
+type Dialog struct {
+  name      string        // dialog resref, used to identify transitions pointing to states of the same dialog
+  flags     int           // header flags, only available if hasFlags is set
+  hasFlags  bool          // whether header contains the flags field (BG2 and later)
+  states    []*State      // list of states
+  cmap      encoding.Encoding // the character map or encoding to be used for triggers and actions
+  dirty     bool          // true if content has been modified
+  err       error
+}
+
+// State represents a single actor response of the dialog.
+type State struct {
+  Text        int32           // strref of the actor response
+  Trigger     string          // state trigger, empty if not available
+  Weight      int             // defines the evaluation order of state triggers; lower values are evaluated first
+  Transitions []*Transition   // list of player replies
+}
+
+// Transition represents a single player reply or dialog link.
+type Transition struct {
+  Flags       int       // transition flags (see TRANS_xxx constants)
+  Text        int32     // strref of the player reply, only used if TRANS_HAS_TEXT is set
+  Journal     int32     // strref of the journal entry, only used if TRANS_HAS_JOURNAL is set
+  Trigger     string    // transition trigger, empty if not available
+  Action      string    // transition action, empty if not available
+  NextDialog  string    // resref of the target dialog, ignored if TRANS_TERMINATES is set
+  NextState   int       // index of the target state, ignored if TRANS_TERMINATES is set
+}
+
+// TransitionRef identifies a single transition within a dialog.
+type TransitionRef struct {
+  State       int       // state index
+  Transition  int       // transition index within the state
+}
+
+
+// Create returns an empty Dialog object with the specified resref.
+func Create(name string) *Dialog {
+  d := Dialog{ name: strings.ToUpper(strings.TrimSpace(name)), hasFlags: true, cmap: charmap.Windows1252 }
+  d.states = make([]*State, 0)
+  return &d
+}
+
+// Load uses the given Reader to load DLG V1.0 data from the underlying buffer. The function returns a pointer to the
+// Dialog object. name specifies the dialog resref and is used to resolve transitions into the same dialog.
+//
+// This function assumes that triggers and actions are encoded in ANSI Windows-1252.
+// Use function Error to check if the Load function returned successfully.
+func Load(r io.Reader, name string) *Dialog {
+  return LoadEx(r, name, charmap.Windows1252)
+}
+
+// LoadEx uses the given Reader to load DLG V1.0 data from the underlying buffer, using the specified character map or
+// encoding to decode triggers and actions. Specify a nil encoding to skip the decoding operation.
+//
+// Use function Error to check if the Load function returned successfully.
+func LoadEx(r io.Reader, name string, cmap encoding.Encoding) *Dialog {
+  d := Create(name)
+  d.cmap = ietools.NormalizeEncoding(cmap)
+  buf := buffers.Load(r)
+  if buf.Error() != nil { d.err = buf.Error(); return d }
+  d.importDialog(buf)
+  return d
+}
+
+// Save writes the current dialog content to the specified Writer, encoding triggers and actions as specified by the
+// Load function. All index tables are rebuilt.
+//
+// Does nothing if the Dialog is in an invalid state (see Error function).
+func (d *Dialog) Save(w io.Writer) {
+  d.SaveEx(w, d.cmap)
+}
+
+// SaveEx writes the current dialog content to the specified Writer, using the specified character map or encoding to
+// encode triggers and actions. All index tables are rebuilt.
+//
+// Specify a nil encoding to skip the encoding operation. Does nothing if the Dialog is in an invalid state (see Error
+// function).
+func (d *Dialog) SaveEx(w io.Writer, cmap encoding.Encoding) {
+  if d.err != nil { return }
+
+  buf := d.exportDialog(ietools.NormalizeEncoding(cmap))
+  if buf.Error() != nil { d.err = buf.Error(); return }
+  buf.Save(w)
+  if buf.Error() != nil { d.err = buf.Error(); return }
+  d.dirty = false
+}
+
+
+// Error returns the error state of the most recent operation on Dialog. Use ClearError function to clear the current
+// error state.
+func (d *Dialog) Error() error {
+  return d.err
+}
+
+// ClearError clears the error state from the last Dialog operation. Must be called for subsequent operations to work
+// correctly.
+func (d *Dialog) ClearError() {
+  d.err = nil
+}
+
+// IsModified returns whether the current dialog content has been modified by a previous operation.
+// The return value is only provided for informal purposes. None of the Dialog functions rely on it.
+//
+// Note: Modifications made directly to State or Transition objects are not tracked.
+func (d *Dialog) IsModified() bool {
+  return d.dirty
+}
+
+// ClearModified explicitly marks the Dialog object as unmodified.
+func (d *Dialog) ClearModified() {
+  d.dirty = false
+}
+
+
+// GetName returns the dialog resref.
+func (d *Dialog) GetName() string {
+  return d.name
+}
+
+// SetName sets the dialog resref. Transitions pointing to the old resref are updated accordingly.
+// Operation is skipped if error state is set.
+func (d *Dialog) SetName(name string) {
+  if d.err != nil { return }
+  name = strings.ToUpper(strings.TrimSpace(name))
+  if name == d.name { return }
+
+  for _, s := range d.states {
+    for _, t := range s.Transitions {
+      if len(d.name) > 0 && strings.EqualFold(t.NextDialog, d.name) { t.NextDialog = name }
+    }
+  }
+  d.name = name
+  d.dirty = true
+}
+
+// GetFlags returns the header flags. Returns 0 if the dialog does not provide header flags.
+// Operation is skipped if error state is set.
+func (d *Dialog) GetFlags() int {
+  if d.err != nil { return 0 }
+  return d.flags
+}
+
+// SetFlags sets the header flags. The dialog is saved with header flags afterwards.
+// Operation is skipped if error state is set.
+func (d *Dialog) SetFlags(flags int) {
+  if d.err != nil { return }
+  if d.flags != flags || !d.hasFlags { d.dirty = true }
+  d.flags = flags
+  d.hasFlags = true
+}
+
+
+// StateCount returns the number of states in the dialog.
+// Operation is skipped if error state is set.
+func (d *Dialog) StateCount() int {
+  if d.err != nil { return 0 }
+  return len(d.states)
+}
+
+// GetState returns the state at the specified index. Changes to the returned state are applied directly.
+//
+// Sets error state if index is out of range. Operation is skipped if error state is set.
+func (d *Dialog) GetState(index int) *State {
+  if d.err != nil { return nil }
+  if index < 0 || index >= len(d.states) { d.err = ietools.ErrIllegalArguments; return nil }
+  return d.states[index]
+}
+
+// AddState appends the given state to the dialog and returns the new state index.
+//
+// If the state provides a trigger and no weight, it is evaluated after all existing states.
+// Operation is skipped if error state is set.
+func (d *Dialog) AddState(state *State) int {
+  if d.err != nil { return -1 }
+  if state == nil { d.err = ietools.ErrIllegalArguments; return -1 }
+
+  if state.Weight <= 0 { state.Weight = d.maxWeight() + 1 }
+  if state.Transitions == nil { state.Transitions = make([]*Transition, 0) }
+  d.states = append(d.states, state)
+  d.dirty = true
+  return len(d.states) - 1
+}
+
+// ExtendTop inserts the given transitions at the specified position of the state's transition list, similar to
+// WeiDU's EXTEND_TOP. A position of 0 inserts before all existing transitions.
+//
+// Sets error state if state or position is out of range. Operation is skipped if error state is set.
+func (d *Dialog) ExtendTop(state, position int, trans ...*Transition) {
+  if d.err != nil { return }
+  if state < 0 || state >= len(d.states) { d.err = ietools.ErrIllegalArguments; return }
+  s := d.states[state]
+  if position < 0 || position > len(s.Transitions) { d.err = ietools.ErrIllegalArguments; return }
+  if len(trans) == 0 { return }
+
+  list := make([]*Transition, 0, len(s.Transitions) + len(trans))
+  list = append(list, s.Transitions[:position]...)
+  list = append(list, trans...)
+  list = append(list, s.Transitions[position:]...)
+  s.Transitions = list
+  d.dirty = true
+}
+
+// ExtendBottom appends the given transitions to the state's transition list, similar to WeiDU's EXTEND_BOTTOM.
+//
+// Sets error state if state is out of range. Operation is skipped if error state is set.
+func (d *Dialog) ExtendBottom(state int, trans ...*Transition) {
+  if d.err != nil { return }
+  if state < 0 || state >= len(d.states) { d.err = ietools.ErrIllegalArguments; return }
+  d.ExtendTop(state, len(d.states[state].Transitions), trans...)
+}
+
+// DeleteTransition removes the transition at the specified index from the state's transition list.
+//
+// Sets error state if state or transition index is out of range. Operation is skipped if error state is set.
+func (d *Dialog) DeleteTransition(state, index int) {
+  if d.err != nil { return }
+  if state < 0 || state >= len(d.states) { d.err = ietools.ErrIllegalArguments; return }
+  s := d.states[state]
+  if index < 0 || index >= len(s.Transitions) { d.err = ietools.ErrIllegalArguments; return }
+
+  s.Transitions = append(s.Transitions[:index], s.Transitions[index+1:]...)
+  d.dirty = true
+}
+
+// Interject appends the given chain of states to the dialog and branches into it from the specified state, similar to
+// WeiDU's INTERJECT and INTERJECT_COPY_TRANS. Returns the index of the first chain state.
+//
+// A new transition with the given trigger that leads to the first chain state is inserted before all other transitions
+// of the specified state. Chain states without transitions are linked to the next chain state. If copyTrans is set,
+// the last chain state receives a copy of the original transitions of the specified state. Otherwise a last chain
+// state without transitions terminates the dialog.
+//
+// Sets error state if state is out of range, chain is empty or the dialog has no name.
+// Operation is skipped if error state is set.
+func (d *Dialog) Interject(state int, trigger string, chain []*State, copyTrans bool) int {
+  if d.err != nil { return -1 }
+  if state < 0 || state >= len(d.states) || len(chain) == 0 { d.err = ietools.ErrIllegalArguments; return -1 }
+  if len(d.name) == 0 { d.err = errors.New("Dialog name required"); return -1 }
+
+  orig := d.states[state].Transitions
+  first := len(d.states)
+  for i, s := range chain {
+    if s == nil { d.err = ietools.ErrIllegalArguments; return -1 }
+    if len(s.Transitions) == 0 {
+      if i + 1 < len(chain) {
+        s.Transitions = []*Transition{ &Transition{ Text: STRREF_NONE, Journal: STRREF_NONE, NextDialog: d.name, NextState: first + i + 1 } }
+      } else if copyTrans {
+        s.Transitions = copyTransitions(orig)
+      } else {
+        s.Transitions = []*Transition{ &Transition{ Flags: TRANS_TERMINATES, Text: STRREF_NONE, Journal: STRREF_NONE } }
+      }
+    }
+    d.AddState(s)
+  }
+  t := &Transition{ Text: STRREF_NONE, Journal: STRREF_NONE, Trigger: trigger, NextDialog: d.name, NextState: first }
+  d.ExtendTop(state, 0, t)
+  return first
+}
+
+
+// Successors returns the indices of all states of the same dialog that can be reached directly from the specified
+// state. Each state index is listed only once.
+//
+// Sets error state if state is out of range. Operation is skipped if error state is set.
+func (d *Dialog) Successors(state int) []int {
+  if d.err != nil { return nil }
+  if state < 0 || state >= len(d.states) { d.err = ietools.ErrIllegalArguments; return nil }
+
+  retVal := make([]int, 0)
+  seen := make(map[int]bool)
+  for _, t := range d.states[state].Transitions {
+    if d.isInternal(t) && !seen[t.NextState] {
+      seen[t.NextState] = true
+      retVal = append(retVal, t.NextState)
+    }
+  }
+  return retVal
+}
+
+// Predecessors returns all transitions of the dialog that lead to the specified state.
+//
+// Sets error state if state is out of range. Operation is skipped if error state is set.
+func (d *Dialog) Predecessors(state int) []TransitionRef {
+  if d.err != nil { return nil }
+  if state < 0 || state >= len(d.states) { d.err = ietools.ErrIllegalArguments; return nil }
+
+  retVal := make([]TransitionRef, 0)
+  for si, s := range d.states {
+    for ti, t := range s.Transitions {
+      if d.isInternal(t) && t.NextState == state {
+        retVal = append(retVal, TransitionRef{ si, ti })
+      }
+    }
+  }
+  return retVal
+}
+
+// EntryStates returns the indices of all states providing a state trigger, in order of evaluation.
+// Operation is skipped if error state is set.
+func (d *Dialog) EntryStates() []int {
+  if d.err != nil { return nil }
+
+  retVal := make([]int, 0)
+  for i, s := range d.states {
+    if len(s.Trigger) > 0 { retVal = append(retVal, i) }
+  }
+  sort.SliceStable(retVal, func(i, j int) bool { return d.states[retVal[i]].Weight < d.states[retVal[j]].Weight })
+  return retVal
+}
+
+
+// Used internally. Returns whether the transition points to a state of the current dialog.
+func (d *Dialog) isInternal(t *Transition) bool {
+  return (t.Flags & TRANS_TERMINATES) == 0 && len(d.name) > 0 && strings.EqualFold(t.NextDialog, d.name)
+}
+
+// Used internally. Returns the highest weight of all states.
+func (d *Dialog) maxWeight() int {
+  retVal := 0
+  for _, s := range d.states {
+    if s.Weight > retVal { retVal = s.Weight }
+  }
+  return retVal
+}
+
+// Used internally. Returns a deep copy of the given transition list.
+func copyTransitions(list []*Transition) []*Transition {
+  retVal := make([]*Transition, len(list))
+  for i, t := range list {
+    tc := *t
+    retVal[i] = &tc
+  }
+  return retVal
+}
+
+
+// Used internally. Parses DLG data from the specified buffer.
+func (d *Dialog) importDialog(buf *buffers.Buffer) {
+  if buf.BufferLength() < headerSize { d.err = errors.New("DLG input buffer too small"); return }
+  sig, ver := buf.GetString(0x00, 4, false), buf.GetString(0x04, 4, false)
+  if sig != "DLG " { d.err = fmt.Errorf("Invalid DLG signature: %q", sig); return }
+  if ver != "V1.0" { d.err = fmt.Errorf("Unsupported DLG version: %q", ver); return }
+
+  numStates, ofsStates := int(buf.GetUint32(0x08)), int(buf.GetUint32(0x0c))
+  numTrans, ofsTrans := int(buf.GetUint32(0x10)), int(buf.GetUint32(0x14))
+  ofsStateTrig, numStateTrig := int(buf.GetUint32(0x18)), int(buf.GetUint32(0x1c))
+  ofsTransTrig, numTransTrig := int(buf.GetUint32(0x20)), int(buf.GetUint32(0x24))
+  ofsActions, numActions := int(buf.GetUint32(0x28)), int(buf.GetUint32(0x2c))
+
+  // header flags are available if no table starts within the flags field
+  d.hasFlags = buf.BufferLength() >= headerSizeFlags
+  for _, ofs := range []int{ ofsStates, ofsTrans, ofsStateTrig, ofsTransTrig, ofsActions } {
+    if ofs > 0 && ofs < headerSizeFlags { d.hasFlags = false }
+  }
+  if d.hasFlags { d.flags = int(buf.GetUint32(0x30)) }
+
+  // table sizes must be checked before allocating memory
+  switch {
+    case !tableInRange(buf, ofsStates, numStates, stateSize):
+      d.err = errors.New("DLG state count out of range"); return
+    case !tableInRange(buf, ofsTrans, numTrans, transitionSize):
+      d.err = errors.New("DLG transition count out of range"); return
+    case !tableInRange(buf, ofsStateTrig, numStateTrig, entrySize):
+      d.err = errors.New("DLG state trigger count out of range"); return
+    case !tableInRange(buf, ofsTransTrig, numTransTrig, entrySize):
+      d.err = errors.New("DLG transition trigger count out of range"); return
+    case !tableInRange(buf, ofsActions, numActions, entrySize):
+      d.err = errors.New("DLG action count out of range"); return
+  }
+
+  stateTriggers := importStrings(buf, ofsStateTrig, numStateTrig, d.cmap)
+  transTriggers := importStrings(buf, ofsTransTrig, numTransTrig, d.cmap)
+  actions := importStrings(buf, ofsActions, numActions, d.cmap)
+  if buf.Error() != nil { d.err = buf.Error(); return }
+
+  transitions := make([]Transition, numTrans)
+  for i := 0; i < numTrans && buf.Error() == nil; i++ {
+    base := ofsTrans + i * transitionSize
+    t := &transitions[i]
+    t.Flags = int(buf.GetUint32(base))
+    t.Text = buf.GetInt32(base + 0x04)
+    t.Journal = buf.GetInt32(base + 0x08)
+    if (t.Flags & TRANS_HAS_TRIGGER) != 0 {
+      idx := int(buf.GetUint32(base + 0x0c))
+      if idx < 0 || idx >= len(transTriggers) { d.err = fmt.Errorf("Transition %d: trigger index out of range: %d", i, idx); return }
+      t.Trigger = transTriggers[idx]
+    }
+    if (t.Flags & TRANS_HAS_ACTION) != 0 {
+      idx := int(buf.GetUint32(base + 0x10))
+      if idx < 0 || idx >= len(actions) { d.err = fmt.Errorf("Transition %d: action index out of range: %d", i, idx); return }
+      t.Action = actions[idx]
+    }
+    t.NextDialog = strings.ToUpper(buf.GetString(base + 0x14, 8, true))
+    t.NextState = int(buf.GetInt32(base + 0x1c))
+  }
+  if buf.Error() != nil { d.err = buf.Error(); return }
+
+  d.states = make([]*State, 0, numStates)
+  for i := 0; i < numStates && buf.Error() == nil; i++ {
+    base := ofsStates + i * stateSize
+    s := State{}
+    s.Text = buf.GetInt32(base)
+    first, count := int(buf.GetInt32(base + 0x04)), int(buf.GetInt32(base + 0x08))
+    trig := int(buf.GetInt32(base + 0x0c))
+    if trig >= 0 && trig < len(stateTriggers) {
+      s.Trigger = stateTriggers[trig]
+      s.Weight = trig + 1
+    }
+    if first < 0 || count < 0 || first + count > len(transitions) {
+      d.err = fmt.Errorf("State %d: transitions out of range", i)
+      return
+    }
+    s.Transitions = make([]*Transition, count)
+    for j := 0; j < count; j++ {
+      tc := transitions[first + j]
+      s.Transitions[j] = &tc
+    }
+    d.states = append(d.states, &s)
+  }
+
+  // states without trigger are evaluated after states with triggers
+  maxWeight := d.maxWeight()
+  for _, s := range d.states {
+    if s.Weight == 0 { maxWeight++; s.Weight = maxWeight }
+  }
+
+  if buf.Error() != nil { d.err = buf.Error() }
+}
+
+// Used internally. Returns whether a table of cnt entries of the given size at offset ofs is located within the buffer.
+func tableInRange(buf *buffers.Buffer, ofs, cnt, size int) bool {
+  if cnt == 0 { return true }
+  // avoids integer overflow
+  return ofs >= 0 && cnt > 0 && ofs <= buf.BufferLength() && cnt <= (buf.BufferLength() - ofs) / size
+}
+
+// Used internally. Parses a list of trigger or action entries and returns the referenced strings, decoded by cmap.
+func importStrings(buf *buffers.Buffer, ofs, cnt int, cmap encoding.Encoding) []string {
+  list := make([]string, 0, cnt)
+  for i := 0; i < cnt && buf.Error() == nil; i++ {
+    base := ofs + i * entrySize
+    list = append(list, buf.GetStringEx(int(buf.GetUint32(base)), int(buf.GetUint32(base + 4)), false, cmap))
+  }
+  return list
+}
+
+// Used internally. Assembles the current dialog content into a new buffer, encoding strings by cmap. All index tables
+// are rebuilt.
+func (d *Dialog) exportDialog(cmap encoding.Encoding) *buffers.Buffer {
+  // state triggers are stored in order of evaluation
+  stateTrigIdx := make(map[*State]int)
+  stateTriggers := make([]string, 0)
+  for _, idx := range d.EntryStates() {
+    stateTrigIdx[d.states[idx]] = len(stateTriggers)
+    stateTriggers = append(stateTriggers, d.states[idx].Trigger)
+  }
+
+  transTriggers := make([]string, 0)
+  actions := make([]string, 0)
+  numTrans := 0
+  for _, s := range d.states {
+    numTrans += len(s.Transitions)
+  }
+
+  hdrSize := headerSize
+  if d.hasFlags { hdrSize = headerSizeFlags }
+  ofsStates := hdrSize
+  ofsTrans := ofsStates + len(d.states) * stateSize
+
+  buf := buffers.Create()
+  buf.InsertBytes(0, ofsTrans + numTrans * transitionSize)
+  buf.PutString(0x00, 4, "DLG ")
+  buf.PutString(0x04, 4, "V1.0")
+  if d.hasFlags { buf.PutUint32(0x30, uint32(d.flags)) }
+
+  transIdx := 0
+  for i, s := range d.states {
+    base := ofsStates + i * stateSize
+    buf.PutInt32(base, s.Text)
+    buf.PutInt32(base + 0x04, int32(transIdx))
+    buf.PutInt32(base + 0x08, int32(len(s.Transitions)))
+    if idx, ok := stateTrigIdx[s]; ok {
+      buf.PutInt32(base + 0x0c, int32(idx))
+    } else {
+      buf.PutInt32(base + 0x0c, -1)
+    }
+
+    for _, t := range s.Transitions {
+      tbase := ofsTrans + transIdx * transitionSize
+      flags := t.Flags & ^(TRANS_HAS_TRIGGER | TRANS_HAS_ACTION)
+      if len(t.Trigger) > 0 {
+        flags |= TRANS_HAS_TRIGGER
+        buf.PutUint32(tbase + 0x0c, uint32(len(transTriggers)))
+        transTriggers = append(transTriggers, t.Trigger)
+      }
+      if len(t.Action) > 0 {
+        flags |= TRANS_HAS_ACTION
+        buf.PutUint32(tbase + 0x10, uint32(len(actions)))
+        actions = append(actions, t.Action)
+      }
+      buf.PutUint32(tbase, uint32(flags))
+      buf.PutInt32(tbase + 0x04, t.Text)
+      buf.PutInt32(tbase + 0x08, t.Journal)
+      if (flags & TRANS_TERMINATES) == 0 {
+        buf.PutString(tbase + 0x14, 8, t.NextDialog)
+        buf.PutInt32(tbase + 0x1c, int32(t.NextState))
+      }
+      transIdx++
+    }
+  }
+
+  ofsStateTrig := buf.BufferLength()
+  ofsTransTrig := ofsStateTrig + len(stateTriggers) * entrySize
+  ofsActions := ofsTransTrig + len(transTriggers) * entrySize
+  buf.InsertBytes(ofsStateTrig, (len(stateTriggers) + len(transTriggers) + len(actions)) * entrySize)
+  exportStrings(buf, ofsStateTrig, stateTriggers, cmap)
+  exportStrings(buf, ofsTransTrig, transTriggers, cmap)
+  exportStrings(buf, ofsActions, actions, cmap)
+
+  buf.PutUint32(0x08, uint32(len(d.states)))
+  buf.PutUint32(0x0c, uint32(ofsStates))
+  buf.PutUint32(0x10, uint32(numTrans))
+  buf.PutUint32(0x14, uint32(ofsTrans))
+  buf.PutUint32(0x18, uint32(ofsStateTrig))
+  buf.PutUint32(0x1c, uint32(len(stateTriggers)))
+  buf.PutUint32(0x20, uint32(ofsTransTrig))
+  buf.PutUint32(0x24, uint32(len(transTriggers)))
+  buf.PutUint32(0x28, uint32(ofsActions))
+  buf.PutUint32(0x2c, uint32(len(actions)))
+
+  return buf
+}
+
+// Used internally. Appends the given strings to the buffer and writes the associated entries at the specified offset.
+// Strings are encoded by cmap. Specify a nil encoding to skip conversion.
+func exportStrings(buf *buffers.Buffer, ofs int, list []string, cmap encoding.Encoding) {
+  for i, s := range list {
+    var data []byte
+    var err error = nil
+    if cmap != nil {
+      data, err = ietools.Utf8ToAnsi(s, cmap)
+    }
+    if cmap == nil || err != nil {
+      data = []byte(s)
+    }
+    pos := buf.BufferLength()
+    buf.InsertBytes(pos, len(data))
+    buf.PutBuffer(pos, data)
+    buf.PutUint32(ofs + i * entrySize, uint32(pos))
+    buf.PutUint32(ofs + i * entrySize + 4, uint32(len(data)))
+  }
+}
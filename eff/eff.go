@@ -0,0 +1,350 @@
+/*
+Package eff provides a unified model for effect structures in both the V1 layout (as used by ITM, SPL and V1 CRE
+resources) and the V2 layout (as used by standalone EFF V2.0 resources and V2 CRE resources).
+*/
+package eff
+
+import (
+  "errors"
+  "fmt"
+  "io"
+
+  "github.com/InfinityTools/go-ietools/buffers"
+)
+
+const (
+  // Available effect structure versions
+  VERSION_1   = 1
+  VERSION_2   = 2
+
+  SIZE_V1     = 0x30    // Size of a V1 effect structure
+  SIZE_V2     = 0x108   // Size of a V2 effect structure as embedded in CRE resources
+  SIZE_FILE   = 0x110   // Size of a standalone EFF V2.0 resource
+
+  sigV2       = "EFF "
+  verV2       = "V2.0"
+)
+
+// Potential errors in addition to ietools errors.
+var (
+  ErrLossyConversion = errors.New("Effect cannot be represented in V1 layout without loss of data")
+)
+
+// Effect provides access to all fields of an effect structure regardless of the layout it was stored in.
+//
+// Fields marked as V2 are only available in the V2 layout. They are initialized to zero when a V1 structure is decoded.
+type Effect struct {
+  Opcode            int
+  Target            int
+  Power             int
+  Parameter1        int32
+  Parameter2        int32
+  TimingMode        int
+  TimingUnknown     int       // V2
+  Duration          int32
+  Probability1      int
+  Probability2      int
+  Resource          string
+  DiceThrown        int32     // or maximum level
+  DiceSides         int32     // or minimum level
+  SaveType          int32
+  SaveBonus         int32
+  Special           int32
+  PrimaryType       int32     // V2
+  Unknown           int32     // V2
+  MinLevel          int32     // V2
+  MaxLevel          int32     // V2
+  DispelResistance  int
+  Parameter3        int32     // V2
+  Parameter4        int32     // V2
+  Parameter5        int32     // V2
+  TimeApplied       int32     // V2
+  Resource2         string    // V2
+  Resource3         string    // V2
+  CasterX           int32     // V2
+  CasterY           int32     // V2
+  TargetX           int32     // V2
+  TargetY           int32     // V2
+  ParentType        int32     // V2
+  ParentResource    string    // V2
+  ParentFlags       int32     // V2
+  Projectile        int32     // V2
+  ParentSlot        int32     // V2
+  VariableName      string    // V2
+  CasterLevel       int32     // V2
+  FirstApply        int32     // V2
+  SecondaryType     int32     // V2
+  Reserved          [60]byte  // V2
+}
+
+
+// Create returns a new Effect with all fields set to their default values.
+func Create() *Effect {
+  e := Effect{ Probability1: 100 }
+  return &e
+}
+
+// Load reads a standalone EFF V2.0 resource from the specified Reader.
+func Load(r io.Reader) (*Effect, error) {
+  buf := buffers.Load(r)
+  if buf.Error() != nil { return nil, buf.Error() }
+  if buf.BufferLength() < SIZE_FILE { return nil, errors.New("EFF input buffer too small") }
+  sig, ver := buf.GetString(0x00, 4, false), buf.GetString(0x04, 4, false)
+  if sig != sigV2 { return nil, fmt.Errorf("Invalid EFF signature: %q", sig) }
+  if ver != verV2 { return nil, fmt.Errorf("Unsupported EFF version: %q", ver) }
+  return DecodeV2(buf.Bytes()[8:SIZE_FILE])
+}
+
+// Save writes the effect as standalone EFF V2.0 resource to the specified Writer.
+func (e *Effect) Save(w io.Writer) error {
+  buf := buffers.Wrap(make([]byte, 8, SIZE_FILE))
+  buf.PutString(0x00, 4, sigV2)
+  buf.PutString(0x04, 4, verV2)
+  _, err := w.Write(append(buf.Bytes(), e.EncodeV2()...))
+  return err
+}
+
+// Decode returns an Effect from the given data. The layout is determined by the data size: SIZE_V1 bytes are decoded
+// as V1 effect, SIZE_V2 bytes as V2 effect and SIZE_FILE bytes as standalone EFF resource.
+func Decode(data []byte) (*Effect, error) {
+  switch len(data) {
+    case SIZE_V1:
+      return DecodeV1(data)
+    case SIZE_V2:
+      return DecodeV2(data)
+    case SIZE_FILE:
+      return DecodeV2(data[8:])
+    default:
+      return nil, fmt.Errorf("Unsupported effect structure size: %d", len(data))
+  }
+}
+
+// DecodeV1 returns an Effect from the given V1 effect structure. data must contain at least SIZE_V1 bytes.
+func DecodeV1(data []byte) (*Effect, error) {
+  if len(data) < SIZE_V1 { return nil, errors.New("V1 effect structure too small") }
+
+  buf := buffers.Wrap(data)
+  e := Effect{}
+  e.Opcode = int(buf.GetUint16(0x00))
+  e.Target = int(buf.GetUint8(0x02))
+  e.Power = int(buf.GetUint8(0x03))
+  e.Parameter1 = buf.GetInt32(0x04)
+  e.Parameter2 = buf.GetInt32(0x08)
+  e.TimingMode = int(buf.GetUint8(0x0c))
+  e.DispelResistance = int(buf.GetUint8(0x0d))
+  e.Duration = buf.GetInt32(0x0e)
+  e.Probability1 = int(buf.GetUint8(0x12))
+  e.Probability2 = int(buf.GetUint8(0x13))
+  e.Resource = buf.GetString(0x14, 8, true)
+  e.DiceThrown = buf.GetInt32(0x1c)
+  e.DiceSides = buf.GetInt32(0x20)
+  e.SaveType = buf.GetInt32(0x24)
+  e.SaveBonus = buf.GetInt32(0x28)
+  e.Special = buf.GetInt32(0x2c)
+  if buf.Error() != nil { return nil, buf.Error() }
+  return &e, nil
+}
+
+// DecodeV2 returns an Effect from the given V2 effect structure as embedded in CRE resources.
+// data must contain at least SIZE_V2 bytes.
+func DecodeV2(data []byte) (*Effect, error) {
+  if len(data) < SIZE_V2 { return nil, errors.New("V2 effect structure too small") }
+
+  buf := buffers.Wrap(data)
+  e := Effect{}
+  e.Opcode = int(buf.GetUint32(0x08))
+  e.Target = int(buf.GetUint32(0x0c))
+  e.Power = int(buf.GetUint32(0x10))
+  e.Parameter1 = buf.GetInt32(0x14)
+  e.Parameter2 = buf.GetInt32(0x18)
+  e.TimingMode = int(buf.GetUint16(0x1c))
+  e.TimingUnknown = int(buf.GetUint16(0x1e))
+  e.Duration = buf.GetInt32(0x20)
+  e.Probability1 = int(buf.GetUint16(0x24))
+  e.Probability2 = int(buf.GetUint16(0x26))
+  e.Resource = buf.GetString(0x28, 8, true)
+  e.DiceThrown = buf.GetInt32(0x30)
+  e.DiceSides = buf.GetInt32(0x34)
+  e.SaveType = buf.GetInt32(0x38)
+  e.SaveBonus = buf.GetInt32(0x3c)
+  e.Special = buf.GetInt32(0x40)
+  e.PrimaryType = buf.GetInt32(0x44)
+  e.Unknown = buf.GetInt32(0x48)
+  e.MinLevel = buf.GetInt32(0x4c)
+  e.MaxLevel = buf.GetInt32(0x50)
+  e.DispelResistance = int(buf.GetUint32(0x54))
+  e.Parameter3 = buf.GetInt32(0x58)
+  e.Parameter4 = buf.GetInt32(0x5c)
+  e.Parameter5 = buf.GetInt32(0x60)
+  e.TimeApplied = buf.GetInt32(0x64)
+  e.Resource2 = buf.GetString(0x68, 8, true)
+  e.Resource3 = buf.GetString(0x70, 8, true)
+  e.CasterX = buf.GetInt32(0x78)
+  e.CasterY = buf.GetInt32(0x7c)
+  e.TargetX = buf.GetInt32(0x80)
+  e.TargetY = buf.GetInt32(0x84)
+  e.ParentType = buf.GetInt32(0x88)
+  e.ParentResource = buf.GetString(0x8c, 8, true)
+  e.ParentFlags = buf.GetInt32(0x94)
+  e.Projectile = buf.GetInt32(0x98)
+  e.ParentSlot = buf.GetInt32(0x9c)
+  e.VariableName = buf.GetString(0xa0, 32, true)
+  e.CasterLevel = buf.GetInt32(0xc0)
+  e.FirstApply = buf.GetInt32(0xc4)
+  e.SecondaryType = buf.GetInt32(0xc8)
+  copy(e.Reserved[:], buf.GetBuffer(0xcc, len(e.Reserved)))
+  if buf.Error() != nil { return nil, buf.Error() }
+  return &e, nil
+}
+
+// EncodeV1 returns the effect as V1 effect structure of SIZE_V1 bytes.
+//
+// Returns ErrLossyConversion if the effect contains data that cannot be represented by the V1 layout
+// (see IsV1Compatible). The returned data is valid nevertheless, with unsupported data truncated or omitted.
+func (e *Effect) EncodeV1() ([]byte, error) {
+  buf := buffers.Wrap(make([]byte, SIZE_V1))
+  buf.PutUint16(0x00, uint16(e.Opcode))
+  buf.PutUint8(0x02, uint8(e.Target))
+  buf.PutUint8(0x03, uint8(e.Power))
+  buf.PutInt32(0x04, e.Parameter1)
+  buf.PutInt32(0x08, e.Parameter2)
+  buf.PutUint8(0x0c, uint8(e.TimingMode))
+  buf.PutUint8(0x0d, uint8(e.DispelResistance))
+  buf.PutInt32(0x0e, e.Duration)
+  buf.PutUint8(0x12, uint8(e.Probability1))
+  buf.PutUint8(0x13, uint8(e.Probability2))
+  buf.PutString(0x14, 8, e.Resource)
+  buf.PutInt32(0x1c, e.DiceThrown)
+  buf.PutInt32(0x20, e.DiceSides)
+  buf.PutInt32(0x24, e.SaveType)
+  buf.PutInt32(0x28, e.SaveBonus)
+  buf.PutInt32(0x2c, e.Special)
+  if buf.Error() != nil { return nil, buf.Error() }
+
+  var err error
+  if !e.IsV1Compatible() { err = ErrLossyConversion }
+  return buf.Bytes(), err
+}
+
+// EncodeV2 returns the effect as V2 effect structure of SIZE_V2 bytes, as embedded in CRE resources.
+func (e *Effect) EncodeV2() []byte {
+  buf := buffers.Wrap(make([]byte, SIZE_V2))
+  buf.PutString(0x00, 4, sigV2)
+  buf.PutString(0x04, 4, verV2)
+  buf.PutUint32(0x08, uint32(e.Opcode))
+  buf.PutUint32(0x0c, uint32(e.Target))
+  buf.PutUint32(0x10, uint32(e.Power))
+  buf.PutInt32(0x14, e.Parameter1)
+  buf.PutInt32(0x18, e.Parameter2)
+  buf.PutUint16(0x1c, uint16(e.TimingMode))
+  buf.PutUint16(0x1e, uint16(e.TimingUnknown))
+  buf.PutInt32(0x20, e.Duration)
+  buf.PutUint16(0x24, uint16(e.Probability1))
+  buf.PutUint16(0x26, uint16(e.Probability2))
+  buf.PutString(0x28, 8, e.Resource)
+  buf.PutInt32(0x30, e.DiceThrown)
+  buf.PutInt32(0x34, e.DiceSides)
+  buf.PutInt32(0x38, e.SaveType)
+  buf.PutInt32(0x3c, e.SaveBonus)
+  buf.PutInt32(0x40, e.Special)
+  buf.PutInt32(0x44, e.PrimaryType)
+  buf.PutInt32(0x48, e.Unknown)
+  buf.PutInt32(0x4c, e.MinLevel)
+  buf.PutInt32(0x50, e.MaxLevel)
+  buf.PutUint32(0x54, uint32(e.DispelResistance))
+  buf.PutInt32(0x58, e.Parameter3)
+  buf.PutInt32(0x5c, e.Parameter4)
+  buf.PutInt32(0x60, e.Parameter5)
+  buf.PutInt32(0x64, e.TimeApplied)
+  buf.PutString(0x68, 8, e.Resource2)
+  buf.PutString(0x70, 8, e.Resource3)
+  buf.PutInt32(0x78, e.CasterX)
+  buf.PutInt32(0x7c, e.CasterY)
+  buf.PutInt32(0x80, e.TargetX)
+  buf.PutInt32(0x84, e.TargetY)
+  buf.PutInt32(0x88, e.ParentType)
+  buf.PutString(0x8c, 8, e.ParentResource)
+  buf.PutInt32(0x94, e.ParentFlags)
+  buf.PutInt32(0x98, e.Projectile)
+  buf.PutInt32(0x9c, e.ParentSlot)
+  buf.PutString(0xa0, 32, e.VariableName)
+  buf.PutInt32(0xc0, e.CasterLevel)
+  buf.PutInt32(0xc4, e.FirstApply)
+  buf.PutInt32(0xc8, e.SecondaryType)
+  buf.PutBuffer(0xcc, e.Reserved[:])
+  return buf.Bytes()
+}
+
+// Encode returns the effect in the layout of the specified version (see VERSION_xxx constants).
+// Returns the same values as EncodeV1 or EncodeV2 respectively.
+func (e *Effect) Encode(version int) ([]byte, error) {
+  switch version {
+    case VERSION_1:
+      return e.EncodeV1()
+    case VERSION_2:
+      return e.EncodeV2(), nil
+    default:
+      return nil, fmt.Errorf("Unsupported effect version: %d", version)
+  }
+}
+
+// IsV1Compatible returns whether the effect can be encoded in V1 layout without loss of data.
+func (e *Effect) IsV1Compatible() bool {
+  if e.Opcode < 0 || e.Opcode > 0xffff { return false }
+  if !fitsByte(e.Target) || !fitsByte(e.Power) || !fitsByte(e.TimingMode) || !fitsByte(e.DispelResistance) ||
+     !fitsByte(e.Probability1) || !fitsByte(e.Probability2) {
+    return false
+  }
+  if e.TimingUnknown != 0 || e.PrimaryType != 0 || e.Unknown != 0 || e.MinLevel != 0 || e.MaxLevel != 0 ||
+     e.Parameter3 != 0 || e.Parameter4 != 0 || e.Parameter5 != 0 || e.TimeApplied != 0 ||
+     len(e.Resource2) > 0 || len(e.Resource3) > 0 ||
+     e.CasterX != 0 || e.CasterY != 0 || e.TargetX != 0 || e.TargetY != 0 ||
+     e.ParentType != 0 || len(e.ParentResource) > 0 || e.ParentFlags != 0 || e.Projectile != 0 || e.ParentSlot != 0 ||
+     len(e.VariableName) > 0 || e.CasterLevel != 0 || e.FirstApply != 0 || e.SecondaryType != 0 {
+    return false
+  }
+  for _, b := range e.Reserved {
+    if b != 0 { return false }
+  }
+  return true
+}
+
+
+// GetEffect decodes the effect structure of the specified version (see VERSION_xxx constants) at the given buffer
+// offset. Offsets can be retrieved by the buffers.Buffer functions GetOffsetArray and GetOffsetArray2.
+func GetEffect(buf *buffers.Buffer, offset, version int) (*Effect, error) {
+  if buf == nil { return nil, errors.New("No input buffer specified") }
+  size := SIZE_V1
+  if version == VERSION_2 { size = SIZE_V2 }
+  data := buf.GetBuffer(offset, size)
+  if buf.Error() != nil { return nil, buf.Error() }
+  if version == VERSION_2 { return DecodeV2(data) }
+  return DecodeV1(data)
+}
+
+// PutEffect encodes the effect in the layout of the specified version (see VERSION_xxx constants) and writes it to
+// the given buffer offset. The buffer must provide enough space for the effect structure.
+//
+// Returns ErrLossyConversion if a V1 structure has been written with loss of data.
+func PutEffect(buf *buffers.Buffer, offset, version int, e *Effect) error {
+  if buf == nil || e == nil { return errors.New("No input buffer or effect specified") }
+  data, err := e.Encode(version)
+  if data == nil { return err }
+  buf.PutBuffer(offset, data)
+  if buf.Error() != nil { return buf.Error() }
+  return err
+}
+
+// CreEffectVersion returns the version of the effect structures (see VERSION_xxx constants) used by the given
+// CRE V1.0 resource.
+func CreEffectVersion(buf *buffers.Buffer) int {
+  if buf == nil { return VERSION_1 }
+  if buf.GetUint8(0x33) != 0 { return VERSION_2 }
+  return VERSION_1
+}
+
+
+// Used internally. Returns whether value fits into an unsigned byte.
+func fitsByte(value int) bool {
+  return value >= 0 && value <= 0xff
+}
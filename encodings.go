@@ -0,0 +1,81 @@
+package ietools
+
+import (
+  "reflect"
+  "strings"
+
+  "golang.org/x/text/encoding"
+  "golang.org/x/text/encoding/charmap"
+  "golang.org/x/text/encoding/japanese"
+  "golang.org/x/text/encoding/korean"
+  "golang.org/x/text/encoding/simplifiedchinese"
+  "golang.org/x/text/encoding/traditionalchinese"
+)
+
+var (
+  // Maps language codes to the text encodings used by the localized releases of the classic games.
+  // Keys are either lowercased language codes ("ja") or language and region codes ("zh_tw").
+  languageEncodings = map[string]encoding.Encoding {
+    "cs":     charmap.Windows1250,
+    "de":     charmap.Windows1252,
+    "en":     charmap.Windows1252,
+    "es":     charmap.Windows1252,
+    "fr":     charmap.Windows1252,
+    "hu":     charmap.Windows1250,
+    "it":     charmap.Windows1252,
+    "ja":     japanese.ShiftJIS,            // CP932
+    "ko":     korean.EUCKR,                 // CP949
+    "pl":     charmap.Windows1250,
+    "pt":     charmap.Windows1252,
+    "ru":     charmap.Windows1251,
+    "tr":     charmap.Windows1254,
+    "uk":     charmap.Windows1251,
+    "zh":     simplifiedchinese.GBK,
+    "zh_cn":  simplifiedchinese.GBK,
+    "zh_tw":  traditionalchinese.Big5,
+  }
+)
+
+
+// EncodingByLanguage returns the text encoding used by the classic games for the specified language. lang can be a
+// language code (e.g. "ja") or a combination of language and region codes (e.g. "zh_TW" or "zh-TW"), as used by the
+// language folders of the Enhanced Edition games.
+//
+// Returns nil if no encoding is registered for the language.
+func EncodingByLanguage(lang string) encoding.Encoding {
+  lang = strings.Replace(strings.ToLower(strings.TrimSpace(lang)), "-", "_", -1)
+  if enc, ok := languageEncodings[lang]; ok { return enc }
+  if idx := strings.Index(lang, "_"); idx > 0 {
+    if enc, ok := languageEncodings[lang[:idx]]; ok { return enc }
+  }
+  return nil
+}
+
+// RegisterEncoding associates the text encoding enc with the specified language code or combination of language and
+// region codes. Specify a nil encoding to remove an association.
+func RegisterEncoding(lang string, enc encoding.Encoding) {
+  lang = strings.Replace(strings.ToLower(strings.TrimSpace(lang)), "-", "_", -1)
+  if len(lang) == 0 { return }
+  enc = NormalizeEncoding(enc)
+  if enc == nil {
+    delete(languageEncodings, lang)
+  } else {
+    languageEncodings[lang] = enc
+  }
+}
+
+// NormalizeEncoding returns nil if enc is nil or wraps a nil pointer, such as a nil *charmap.Charmap assigned to an
+// encoding.Encoding. Returns enc otherwise.
+//
+// Functions accepting an encoding.Encoding treat both cases as "no encoding".
+func NormalizeEncoding(enc encoding.Encoding) encoding.Encoding {
+  switch e := enc.(type) {
+    case nil:
+      return nil
+    case *charmap.Charmap:
+      if e == nil { return nil }
+      return enc
+  }
+  if v := reflect.ValueOf(enc); v.Kind() == reflect.Ptr && v.IsNil() { return nil }
+  return enc
+}
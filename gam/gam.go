@@ -0,0 +1,840 @@
+/*
+Package gam provides a typed model for GAM V2.0 savegame resources, as used by Baldur's Gate 2 and the Enhanced Edition
+games. It is built on top of the buffers package.
+*/
+package gam
+
+import (
+  "errors"
+  "fmt"
+  "io"
+  "strings"
+
+  "github.com/InfinityTools/go-ietools"
+  "github.com/InfinityTools/go-ietools/buffers"
+)
+
+const (
+  // Available familiar alignment slots
+  FAMILIAR_LG         = 0   // Lawful good
+  FAMILIAR_LN         = 1   // Lawful neutral
+  FAMILIAR_CG         = 2   // Chaotic good
+  FAMILIAR_NG         = 3   // Neutral good
+  FAMILIAR_TN         = 4   // True neutral
+  FAMILIAR_NE         = 5   // Neutral evil
+  FAMILIAR_LE         = 6   // Lawful evil
+  FAMILIAR_CE         = 7   // Chaotic evil
+  FAMILIAR_CN         = 8   // Chaotic neutral
+
+  // Available variable type flags
+  VAR_INT             = ietools.BIT0
+  VAR_FLOAT           = ietools.BIT1
+  VAR_SCRIPT_NAME     = ietools.BIT2
+  VAR_RESREF          = ietools.BIT3
+  VAR_STRREF          = ietools.BIT4
+  VAR_DWORD           = ietools.BIT5
+
+  // Maximum number of party members
+  MAX_PARTY_SIZE      = 6
+
+  // Party order value of NPCs outside of the party
+  PARTY_ORDER_NONE    = 0xffff
+
+  headerSize          = 0xb4
+  npcSize             = 0x160
+  variableSize        = 0x54
+  journalSize         = 0x0c
+  familiarSize        = 0x190
+  locationSize        = 0x0c
+  itemSize            = 0x14
+)
+
+// Potential errors in addition to ietools errors.
+var (
+  ErrPartyFull        = errors.New("Party is full")
+  ErrNoSuchVariable   = errors.New("Variable does not exist")
+)
+
+// Game contains the necessary information to query or alter GAM V2.0 savegame data.
+type Game struct {
+  header      *buffers.Buffer   // header structure; offsets and counts are rewritten on save
+  party       []*Npc            // party members in party order
+  nonParty    []*Npc            // NPCs outside of the party
+  inventory   []byte            // raw party inventory item structures (unused by the games), preserved as is
+  globals     []*Variable       // GLOBAL namespace variables
+  journal     []*JournalEntry   // journal entries
+  familiar    *Familiar         // optional familiar info, may be nil
+  stored      []Location        // stored locations
+  pocket      []Location        // pocket plane locations
+  dirty       bool              // true if content has been modified
+  err         error
+}
+
+// Npc represents a single character entry of the GAM resource together with the optionally embedded CRE resource.
+type Npc struct {
+  data        *buffers.Buffer   // the NPC structure; CRE offset and size are rewritten on save
+  cre         *buffers.Buffer   // the embedded CRE resource, may be nil
+}
+
+// Variable represents a single GLOBAL namespace variable.
+type Variable struct {
+  Name        string    // variable name (up to 32 characters)
+  Type        int       // variable type flags (see VAR_xxx constants)
+  RefValue    int       // reference value
+  DwordValue  uint32    // unsigned value
+  IntValue    int32     // the value used by the game scripts
+  FloatValue  [8]byte   // raw floating point value
+  ScriptName  string    // script name value (up to 32 characters)
+}
+
+// JournalEntry represents a single entry of the in-game journal.
+type JournalEntry struct {
+  StrRef      int32     // text of the entry
+  Time        int32     // game time in seconds
+  Chapter     int       // chapter number
+  ReadBy      int       // read by character (unused)
+  Section     int       // section flags (quest, completed quest, journal info, ...)
+  Location    int       // 0x1f if added by user, 0xff if taken from dialog.tlk
+}
+
+// Location defines a position on the specified area.
+type Location struct {
+  Area        string    // area resref
+  X, Y        int       // coordinates on the area
+}
+
+// Familiar contains the familiar definitions for all alignments and the list of extra familiar resources.
+type Familiar struct {
+  data        []byte    // the familiar info structure; offset to extra resources is rewritten on save
+  extra       []string  // list of extra familiar resources
+}
+
+
+// Load uses the given Reader to load GAM V2.0 data from the underlying buffer. The function returns a pointer to the
+// Game object.
+//
+// Use function Error to check if the Load function returned successfully.
+func Load(r io.Reader) *Game {
+  g := Game{}
+  buf := buffers.Load(r)
+  if buf.Error() != nil { g.err = buf.Error(); return &g }
+  g.importGame(buf)
+  return &g
+}
+
+// Save writes the current savegame content to the specified Writer. Offsets to substructures are rewritten as needed.
+//
+// Does nothing if the Game is in an invalid state (see Error function).
+func (g *Game) Save(w io.Writer) {
+  if g.err != nil { return }
+
+  buf := g.exportGame()
+  if buf.Error() != nil { g.err = buf.Error(); return }
+  buf.Save(w)
+  if buf.Error() != nil { g.err = buf.Error(); return }
+  g.dirty = false
+}
+
+
+// Error returns the error state of the most recent operation on Game. Use ClearError function to clear the current
+// error state.
+func (g *Game) Error() error {
+  return g.err
+}
+
+// ClearError clears the error state from the last Game operation. Must be called for subsequent operations to work
+// correctly.
+func (g *Game) ClearError() {
+  g.err = nil
+}
+
+// IsModified returns whether the current savegame content has been modified by a previous operation.
+// The return value is only provided for informal purposes. None of the Game functions rely on it.
+//
+// Note: Modifications made directly to buffers returned by Header, Npc.Buffer or Npc.Cre are not tracked.
+func (g *Game) IsModified() bool {
+  return g.dirty
+}
+
+// ClearModified explicitly marks the Game object as unmodified.
+func (g *Game) ClearModified() {
+  g.dirty = false
+}
+
+
+// Header returns the buffer of the GAM header structure for direct access to fields not covered by the Game type.
+// Offset and count fields of substructures are overwritten when the savegame is saved.
+func (g *Game) Header() *buffers.Buffer {
+  if g.err != nil { return nil }
+  return g.header
+}
+
+// GetGameTime returns the game time in game seconds (300 units == 1 hour).
+// Operation is skipped if error state is set.
+func (g *Game) GetGameTime() int {
+  if g.err != nil { return 0 }
+  return int(g.header.GetUint32(0x08))
+}
+
+// SetGameTime sets the game time in game seconds (300 units == 1 hour).
+// Operation is skipped if error state is set.
+func (g *Game) SetGameTime(value int) {
+  if g.err != nil { return }
+  g.putHeader32(0x08, uint32(value))
+}
+
+// GetPartyGold returns the amount of gold owned by the party.
+// Operation is skipped if error state is set.
+func (g *Game) GetPartyGold() int {
+  if g.err != nil { return 0 }
+  return int(g.header.GetUint32(0x18))
+}
+
+// SetPartyGold sets the amount of gold owned by the party.
+// Operation is skipped if error state is set.
+func (g *Game) SetPartyGold(value int) {
+  if g.err != nil { return }
+  g.putHeader32(0x18, uint32(value))
+}
+
+// GetReputation returns the party reputation multiplied by 10.
+// Operation is skipped if error state is set.
+func (g *Game) GetReputation() int {
+  if g.err != nil { return 0 }
+  return int(g.header.GetUint32(0x54))
+}
+
+// SetReputation sets the party reputation multiplied by 10.
+// Operation is skipped if error state is set.
+func (g *Game) SetReputation(value int) {
+  if g.err != nil { return }
+  g.putHeader32(0x54, uint32(value))
+}
+
+// GetMainArea returns the resref of the area the game world is centered on.
+// Operation is skipped if error state is set.
+func (g *Game) GetMainArea() string {
+  if g.err != nil { return "" }
+  return g.header.GetString(0x40, 8, true)
+}
+
+// SetMainArea sets the resref of the area the game world is centered on.
+// Operation is skipped if error state is set.
+func (g *Game) SetMainArea(resref string) {
+  if g.err != nil { return }
+  g.putHeaderString(0x40, resref)
+}
+
+// GetCurrentArea returns the resref of the area currently displayed.
+// Operation is skipped if error state is set.
+func (g *Game) GetCurrentArea() string {
+  if g.err != nil { return "" }
+  return g.header.GetString(0x58, 8, true)
+}
+
+// SetCurrentArea sets the resref of the area currently displayed.
+// Operation is skipped if error state is set.
+func (g *Game) SetCurrentArea(resref string) {
+  if g.err != nil { return }
+  g.putHeaderString(0x58, resref)
+}
+
+
+// PartySize returns the number of NPCs in the party, including the protagonist.
+// Operation is skipped if error state is set.
+func (g *Game) PartySize() int {
+  if g.err != nil { return 0 }
+  return len(g.party)
+}
+
+// NonPartySize returns the number of NPCs outside of the party.
+// Operation is skipped if error state is set.
+func (g *Game) NonPartySize() int {
+  if g.err != nil { return 0 }
+  return len(g.nonParty)
+}
+
+// GetPartyNpc returns the party member at the specified position in party order.
+//
+// Sets error state if index is out of range. Operation is skipped if error state is set.
+func (g *Game) GetPartyNpc(index int) *Npc {
+  if g.err != nil { return nil }
+  if index < 0 || index >= len(g.party) { g.err = ietools.ErrIllegalArguments; return nil }
+  return g.party[index]
+}
+
+// GetNonPartyNpc returns the non-party NPC at the specified index.
+//
+// Sets error state if index is out of range. Operation is skipped if error state is set.
+func (g *Game) GetNonPartyNpc(index int) *Npc {
+  if g.err != nil { return nil }
+  if index < 0 || index >= len(g.nonParty) { g.err = ietools.ErrIllegalArguments; return nil }
+  return g.nonParty[index]
+}
+
+// FindNpc returns the party status and index of the first NPC whose character name or CRE resref matches the given
+// name (case-insensitive). Returns -1 as index if no NPC could be found.
+// Operation is skipped if error state is set.
+func (g *Game) FindNpc(name string) (inParty bool, index int) {
+  if g.err != nil { return false, -1 }
+  for i, npc := range g.party {
+    if npc.matches(name) { return true, i }
+  }
+  for i, npc := range g.nonParty {
+    if npc.matches(name) { return false, i }
+  }
+  return false, -1
+}
+
+// JoinParty moves the non-party NPC at the specified index to the end of the party and returns the new party position.
+//
+// Sets error state if index is out of range or the party is full. Operation is skipped if error state is set.
+func (g *Game) JoinParty(index int) int {
+  if g.err != nil { return -1 }
+  if index < 0 || index >= len(g.nonParty) { g.err = ietools.ErrIllegalArguments; return -1 }
+  if len(g.party) >= MAX_PARTY_SIZE { g.err = ErrPartyFull; return -1 }
+
+  npc := g.nonParty[index]
+  g.nonParty = append(g.nonParty[:index], g.nonParty[index+1:]...)
+  g.party = append(g.party, npc)
+  g.updatePartyOrder()
+  g.dirty = true
+  return len(g.party) - 1
+}
+
+// LeaveParty moves the party member at the specified position to the end of the non-party list and returns the new
+// non-party index. Remaining party members move up to fill the gap.
+//
+// Sets error state if index is out of range. Operation is skipped if error state is set.
+func (g *Game) LeaveParty(index int) int {
+  if g.err != nil { return -1 }
+  if index < 0 || index >= len(g.party) { g.err = ietools.ErrIllegalArguments; return -1 }
+
+  npc := g.party[index]
+  g.party = append(g.party[:index], g.party[index+1:]...)
+  npc.data.PutUint16(0x00, 0)
+  npc.data.PutUint16(0x02, PARTY_ORDER_NONE)
+  g.nonParty = append(g.nonParty, npc)
+  g.updatePartyOrder()
+  g.dirty = true
+  return len(g.nonParty) - 1
+}
+
+// AddNonPartyNpc adds the given NPC to the end of the non-party list and returns its index.
+// Operation is skipped if error state is set.
+func (g *Game) AddNonPartyNpc(npc *Npc) int {
+  if g.err != nil { return -1 }
+  if npc == nil { g.err = ietools.ErrIllegalArguments; return -1 }
+
+  npc.data.PutUint16(0x02, PARTY_ORDER_NONE)
+  g.nonParty = append(g.nonParty, npc)
+  g.dirty = true
+  return len(g.nonParty) - 1
+}
+
+// RemoveNonPartyNpc removes the non-party NPC at the specified index and returns it.
+//
+// Sets error state if index is out of range. Operation is skipped if error state is set.
+func (g *Game) RemoveNonPartyNpc(index int) *Npc {
+  if g.err != nil { return nil }
+  if index < 0 || index >= len(g.nonParty) { g.err = ietools.ErrIllegalArguments; return nil }
+
+  npc := g.nonParty[index]
+  g.nonParty = append(g.nonParty[:index], g.nonParty[index+1:]...)
+  g.dirty = true
+  return npc
+}
+
+
+// GlobalCount returns the number of available GLOBAL variables.
+// Operation is skipped if error state is set.
+func (g *Game) GlobalCount() int {
+  if g.err != nil { return 0 }
+  return len(g.globals)
+}
+
+// GetGlobalAt returns the GLOBAL variable at the specified index. Changes to the returned variable are applied directly.
+//
+// Sets error state if index is out of range. Operation is skipped if error state is set.
+func (g *Game) GetGlobalAt(index int) *Variable {
+  if g.err != nil { return nil }
+  if index < 0 || index >= len(g.globals) { g.err = ietools.ErrIllegalArguments; return nil }
+  return g.globals[index]
+}
+
+// GetGlobal returns the value of the specified GLOBAL variable. Variable names are case-insensitive.
+// Returns false as second value if the variable does not exist.
+// Operation is skipped if error state is set.
+func (g *Game) GetGlobal(name string) (int, bool) {
+  if g.err != nil { return 0, false }
+  idx := g.findGlobal(name)
+  if idx < 0 { return 0, false }
+  return int(g.globals[idx].IntValue), true
+}
+
+// SetGlobal assigns value to the specified GLOBAL variable. The variable is added if it does not exist yet.
+//
+// Sets error state if name is empty or longer than 32 characters. Operation is skipped if error state is set.
+func (g *Game) SetGlobal(name string, value int) {
+  if g.err != nil { return }
+  name = strings.TrimSpace(name)
+  if len(name) == 0 || len(name) > 32 { g.err = ietools.ErrIllegalArguments; return }
+
+  idx := g.findGlobal(name)
+  if idx < 0 {
+    g.globals = append(g.globals, &Variable{Name: strings.ToUpper(name), Type: VAR_INT, IntValue: int32(value)})
+    g.dirty = true
+  } else if g.globals[idx].IntValue != int32(value) {
+    g.globals[idx].IntValue = int32(value)
+    g.dirty = true
+  }
+}
+
+// DeleteGlobal removes the specified GLOBAL variable.
+//
+// Sets error state if the variable does not exist. Operation is skipped if error state is set.
+func (g *Game) DeleteGlobal(name string) {
+  if g.err != nil { return }
+  idx := g.findGlobal(name)
+  if idx < 0 { g.err = ErrNoSuchVariable; return }
+
+  g.globals = append(g.globals[:idx], g.globals[idx+1:]...)
+  g.dirty = true
+}
+
+
+// JournalCount returns the number of journal entries.
+// Operation is skipped if error state is set.
+func (g *Game) JournalCount() int {
+  if g.err != nil { return 0 }
+  return len(g.journal)
+}
+
+// GetJournalEntry returns the journal entry at the specified index. Changes to the returned entry are applied directly.
+//
+// Sets error state if index is out of range. Operation is skipped if error state is set.
+func (g *Game) GetJournalEntry(index int) *JournalEntry {
+  if g.err != nil { return nil }
+  if index < 0 || index >= len(g.journal) { g.err = ietools.ErrIllegalArguments; return nil }
+  return g.journal[index]
+}
+
+// AddJournalEntry appends the given journal entry and returns its index.
+// Operation is skipped if error state is set.
+func (g *Game) AddJournalEntry(entry JournalEntry) int {
+  if g.err != nil { return -1 }
+  g.journal = append(g.journal, &entry)
+  g.dirty = true
+  return len(g.journal) - 1
+}
+
+// DeleteJournalEntry removes the journal entry at the specified index.
+//
+// Sets error state if index is out of range. Operation is skipped if error state is set.
+func (g *Game) DeleteJournalEntry(index int) {
+  if g.err != nil { return }
+  if index < 0 || index >= len(g.journal) { g.err = ietools.ErrIllegalArguments; return }
+  g.journal = append(g.journal[:index], g.journal[index+1:]...)
+  g.dirty = true
+}
+
+
+// GetFamiliar returns the familiar info structure. Returns nil if the savegame does not contain familiar info.
+// Operation is skipped if error state is set.
+func (g *Game) GetFamiliar() *Familiar {
+  if g.err != nil { return nil }
+  return g.familiar
+}
+
+// GetStoredLocations returns a copy of the stored locations list.
+// Operation is skipped if error state is set.
+func (g *Game) GetStoredLocations() []Location {
+  if g.err != nil { return nil }
+  return append([]Location(nil), g.stored...)
+}
+
+// SetStoredLocations replaces the stored locations list.
+// Operation is skipped if error state is set.
+func (g *Game) SetStoredLocations(locs []Location) {
+  if g.err != nil { return }
+  g.stored = append([]Location(nil), locs...)
+  g.dirty = true
+}
+
+// GetPocketPlaneLocations returns a copy of the pocket plane locations list.
+// Operation is skipped if error state is set.
+func (g *Game) GetPocketPlaneLocations() []Location {
+  if g.err != nil { return nil }
+  return append([]Location(nil), g.pocket...)
+}
+
+// SetPocketPlaneLocations replaces the pocket plane locations list.
+// Operation is skipped if error state is set.
+func (g *Game) SetPocketPlaneLocations(locs []Location) {
+  if g.err != nil { return }
+  g.pocket = append([]Location(nil), locs...)
+  g.dirty = true
+}
+
+
+// CreateNpc returns a new NPC entry with the given CRE resource embedded. Specify a nil cre to create an NPC that refers
+// to a CRE resource by resref only.
+func CreateNpc(cre *buffers.Buffer) *Npc {
+  npc := Npc{ data: buffers.Wrap(make([]byte, npcSize)), cre: cre }
+  npc.data.PutUint16(0x02, PARTY_ORDER_NONE)
+  return &npc
+}
+
+// Buffer returns the buffer of the NPC structure for direct access to fields not covered by the Npc type.
+// CRE offset and size fields are overwritten when the savegame is saved.
+func (n *Npc) Buffer() *buffers.Buffer {
+  return n.data
+}
+
+// Cre returns the embedded CRE resource. Returns nil if the NPC refers to a CRE resource by resref.
+func (n *Npc) Cre() *buffers.Buffer {
+  return n.cre
+}
+
+// SetCre replaces the embedded CRE resource. Specify nil to remove the embedded resource.
+func (n *Npc) SetCre(cre *buffers.Buffer) {
+  n.cre = cre
+}
+
+// GetName returns the character name as stored in the NPC structure.
+func (n *Npc) GetName() string {
+  return n.data.GetString(0xc0, 32, true)
+}
+
+// SetName sets the character name as stored in the NPC structure.
+func (n *Npc) SetName(name string) {
+  n.data.PutString(0xc0, 32, name)
+}
+
+// GetCreResRef returns the CRE resref of the NPC.
+func (n *Npc) GetCreResRef() string {
+  return n.data.GetString(0x0c, 8, true)
+}
+
+// SetCreResRef sets the CRE resref of the NPC.
+func (n *Npc) SetCreResRef(resref string) {
+  n.data.PutString(0x0c, 8, resref)
+}
+
+// GetArea returns the resref of the area the NPC is located in.
+func (n *Npc) GetArea() string {
+  return n.data.GetString(0x18, 8, true)
+}
+
+// SetArea sets the resref of the area the NPC is located in.
+func (n *Npc) SetArea(resref string) {
+  n.data.PutString(0x18, 8, resref)
+}
+
+// GetPosition returns the coordinates of the NPC on the current area.
+func (n *Npc) GetPosition() (x, y int) {
+  return int(n.data.GetUint16(0x20)), int(n.data.GetUint16(0x22))
+}
+
+// SetPosition sets the coordinates of the NPC on the current area.
+func (n *Npc) SetPosition(x, y int) {
+  n.data.PutUint16(0x20, uint16(x))
+  n.data.PutUint16(0x22, uint16(y))
+}
+
+// GetPartyOrder returns the party position of the NPC. Returns PARTY_ORDER_NONE for NPCs outside of the party.
+func (n *Npc) GetPartyOrder() int {
+  return int(n.data.GetUint16(0x02))
+}
+
+
+// GetFamiliar returns the familiar resref for the specified alignment (see FAMILIAR_xxx constants).
+func (f *Familiar) GetFamiliar(alignment int) string {
+  if alignment < FAMILIAR_LG || alignment > FAMILIAR_CN { return "" }
+  buf := buffers.Wrap(f.data)
+  return buf.GetString(alignment * 8, 8, true)
+}
+
+// SetFamiliar sets the familiar resref for the specified alignment (see FAMILIAR_xxx constants).
+func (f *Familiar) SetFamiliar(alignment int, resref string) {
+  if alignment < FAMILIAR_LG || alignment > FAMILIAR_CN { return }
+  buf := buffers.Wrap(f.data)
+  buf.PutString(alignment * 8, 8, resref)
+}
+
+// GetExtraFamiliars returns a copy of the list of extra familiar resources.
+func (f *Familiar) GetExtraFamiliars() []string {
+  return append([]string(nil), f.extra...)
+}
+
+
+// Used internally. Writes a 32-bit header value and updates modified state.
+func (g *Game) putHeader32(offset int, value uint32) {
+  if g.header.PutUint32(offset, value) != value { g.dirty = true }
+  if g.header.Error() != nil { g.err = g.header.Error() }
+}
+
+// Used internally. Writes a resref header value and updates modified state.
+func (g *Game) putHeaderString(offset int, value string) {
+  if g.header.GetString(offset, 8, true) != value { g.dirty = true }
+  g.header.PutString(offset, 8, value)
+  if g.header.Error() != nil { g.err = g.header.Error() }
+}
+
+// Used internally. Returns the index of the specified global variable, or -1 if not found.
+func (g *Game) findGlobal(name string) int {
+  name = strings.TrimSpace(name)
+  for i, v := range g.globals {
+    if strings.EqualFold(v.Name, name) { return i }
+  }
+  return -1
+}
+
+// Used internally. Assigns party order values to all party members.
+func (g *Game) updatePartyOrder() {
+  for i, npc := range g.party {
+    npc.data.PutUint16(0x02, uint16(i))
+  }
+}
+
+// Used internally. Returns whether name matches character name or CRE resref of the NPC.
+func (n *Npc) matches(name string) bool {
+  name = strings.TrimSpace(name)
+  return strings.EqualFold(n.GetName(), name) || strings.EqualFold(n.GetCreResRef(), name)
+}
+
+
+// Used internally. Parses GAM data from the specified buffer.
+func (g *Game) importGame(buf *buffers.Buffer) {
+  if buf.BufferLength() < headerSize { g.err = errors.New("GAM input buffer too small"); return }
+  sig := buf.GetString(0x00, 4, false)
+  ver := buf.GetString(0x04, 4, false)
+  if sig != "GAME" { g.err = fmt.Errorf("Invalid GAM signature: %q", sig); return }
+  if ver != "V2.0" { g.err = fmt.Errorf("Unsupported GAM version: %q", ver); return }
+
+  g.header = buffers.Wrap(buf.GetBuffer(0, headerSize))
+
+  // table sizes must be checked before allocating memory
+  tables := []struct { ofs, cnt, size int; name string }{
+    { 0x20, 0x24, npcSize, "party NPC" },
+    { 0x28, 0x2c, itemSize, "party inventory" },
+    { 0x30, 0x34, npcSize, "non-party NPC" },
+    { 0x38, 0x3c, variableSize, "variable" },
+    { 0x50, 0x4c, journalSize, "journal entry" },
+    { 0x6c, 0x70, locationSize, "stored location" },
+    { 0x78, 0x7c, locationSize, "pocket plane location" },
+  }
+  for _, t := range tables {
+    if !tableInRange(buf, int(buf.GetUint32(t.ofs)), int(buf.GetUint32(t.cnt)), t.size) {
+      g.err = fmt.Errorf("GAM %s count out of range", t.name)
+      return
+    }
+  }
+
+  g.party = importNpcs(buf, int(buf.GetUint32(0x20)), int(buf.GetUint32(0x24)))
+  g.nonParty = importNpcs(buf, int(buf.GetUint32(0x30)), int(buf.GetUint32(0x34)))
+  if cnt := int(buf.GetUint32(0x2c)); cnt > 0 {
+    g.inventory = buf.GetBuffer(int(buf.GetUint32(0x28)), cnt * itemSize)
+  }
+
+  ofs, cnt := int(buf.GetUint32(0x38)), int(buf.GetUint32(0x3c))
+  g.globals = make([]*Variable, 0, cnt)
+  for i := 0; i < cnt && buf.Error() == nil; i++ {
+    base := ofs + i * variableSize
+    v := Variable{}
+    v.Name = buf.GetString(base, 32, true)
+    v.Type = int(buf.GetUint16(base + 0x20))
+    v.RefValue = int(buf.GetUint16(base + 0x22))
+    v.DwordValue = buf.GetUint32(base + 0x24)
+    v.IntValue = buf.GetInt32(base + 0x28)
+    copy(v.FloatValue[:], buf.GetBuffer(base + 0x2c, 8))
+    v.ScriptName = buf.GetString(base + 0x34, 32, true)
+    g.globals = append(g.globals, &v)
+  }
+
+  ofs, cnt = int(buf.GetUint32(0x50)), int(buf.GetUint32(0x4c))
+  g.journal = make([]*JournalEntry, 0, cnt)
+  for i := 0; i < cnt && buf.Error() == nil; i++ {
+    base := ofs + i * journalSize
+    e := JournalEntry{}
+    e.StrRef = buf.GetInt32(base)
+    e.Time = buf.GetInt32(base + 0x04)
+    e.Chapter = int(buf.GetUint8(base + 0x08))
+    e.ReadBy = int(buf.GetUint8(base + 0x09))
+    e.Section = int(buf.GetUint8(base + 0x0a))
+    e.Location = int(buf.GetUint8(base + 0x0b))
+    g.journal = append(g.journal, &e)
+  }
+
+  ofs = int(buf.GetUint32(0x68))
+  if ofs > 0 && ofs <= buf.BufferLength() - familiarSize {
+    f := Familiar{ data: buf.GetBuffer(ofs, familiarSize) }
+    fb := buffers.Wrap(f.data)
+    // sum of up to 81 unchecked counts, must not overflow
+    var sum uint64
+    for i := 0x4c; i < familiarSize; i += 4 {
+      sum += uint64(fb.GetUint32(i))
+    }
+    extraOfs := int(fb.GetUint32(0x48))
+    if sum > uint64(buf.BufferLength() / 8) || (extraOfs == 0 && sum > 0) ||
+       !tableInRange(buf, extraOfs, int(sum), 8) {
+      g.err = errors.New("GAM extra familiar count out of range")
+      return
+    }
+    numExtra := int(sum)
+    f.extra = make([]string, 0, numExtra)
+    for i := 0; i < numExtra && buf.Error() == nil; i++ {
+      f.extra = append(f.extra, buf.GetString(extraOfs + i*8, 8, true))
+    }
+    g.familiar = &f
+  }
+
+  g.stored = importLocations(buf, int(buf.GetUint32(0x6c)), int(buf.GetUint32(0x70)))
+  g.pocket = importLocations(buf, int(buf.GetUint32(0x78)), int(buf.GetUint32(0x7c)))
+
+  if buf.Error() != nil { g.err = buf.Error() }
+}
+
+// Used internally. Returns whether a table of cnt entries of the given size at offset ofs is located within the buffer.
+func tableInRange(buf *buffers.Buffer, ofs, cnt, size int) bool {
+  if cnt == 0 { return true }
+  // avoids integer overflow
+  return ofs >= 0 && cnt > 0 && ofs <= buf.BufferLength() && cnt <= (buf.BufferLength() - ofs) / size
+}
+
+// Used internally. Parses a list of NPC structures and their embedded CRE resources.
+func importNpcs(buf *buffers.Buffer, ofs, cnt int) []*Npc {
+  list := make([]*Npc, 0, cnt)
+  for i := 0; i < cnt && buf.Error() == nil; i++ {
+    base := ofs + i * npcSize
+    npc := Npc{ data: buffers.Wrap(buf.GetBuffer(base, npcSize)) }
+    creOfs, creSize := int(buf.GetUint32(base + 0x04)), int(buf.GetUint32(base + 0x08))
+    if creOfs > 0 && creSize > 0 {
+      npc.cre = buffers.Wrap(buf.GetBuffer(creOfs, creSize))
+    }
+    list = append(list, &npc)
+  }
+  return list
+}
+
+// Used internally. Parses a list of location structures.
+func importLocations(buf *buffers.Buffer, ofs, cnt int) []Location {
+  list := make([]Location, 0, cnt)
+  for i := 0; i < cnt && buf.Error() == nil; i++ {
+    base := ofs + i * locationSize
+    list = append(list, Location{ buf.GetString(base, 8, true), int(buf.GetUint16(base + 8)), int(buf.GetUint16(base + 10)) })
+  }
+  return list
+}
+
+// Used internally. Assembles the current savegame content into a new buffer and rewrites all offsets and counts.
+func (g *Game) exportGame() *buffers.Buffer {
+  buf := buffers.Create()
+  buf.InsertBytes(0, headerSize)
+  buf.PutBuffer(0, g.header.Bytes())
+
+  ofs := exportNpcs(buf, g.party)
+  buf.PutUint32(0x20, uint32(ofs))
+  buf.PutUint32(0x24, uint32(len(g.party)))
+  ofs = exportNpcs(buf, g.nonParty)
+  buf.PutUint32(0x30, uint32(ofs))
+  buf.PutUint32(0x34, uint32(len(g.nonParty)))
+
+  if len(g.inventory) > 0 {
+    ofs = buf.BufferLength()
+    buf.InsertBytes(ofs, len(g.inventory))
+    buf.PutBuffer(ofs, g.inventory)
+    buf.PutUint32(0x28, uint32(ofs))
+  } else {
+    buf.PutUint32(0x28, 0)
+  }
+  buf.PutUint32(0x2c, uint32(len(g.inventory) / itemSize))
+
+  ofs = buf.BufferLength()
+  buf.InsertBytes(ofs, len(g.globals) * variableSize)
+  for i, v := range g.globals {
+    base := ofs + i * variableSize
+    buf.PutString(base, 32, v.Name)
+    buf.PutUint16(base + 0x20, uint16(v.Type))
+    buf.PutUint16(base + 0x22, uint16(v.RefValue))
+    buf.PutUint32(base + 0x24, v.DwordValue)
+    buf.PutInt32(base + 0x28, v.IntValue)
+    buf.PutBuffer(base + 0x2c, v.FloatValue[:])
+    buf.PutString(base + 0x34, 32, v.ScriptName)
+  }
+  buf.PutUint32(0x38, uint32(ofs))
+  buf.PutUint32(0x3c, uint32(len(g.globals)))
+
+  ofs = buf.BufferLength()
+  buf.InsertBytes(ofs, len(g.journal) * journalSize)
+  for i, e := range g.journal {
+    base := ofs + i * journalSize
+    buf.PutInt32(base, e.StrRef)
+    buf.PutInt32(base + 0x04, e.Time)
+    buf.PutUint8(base + 0x08, uint8(e.Chapter))
+    buf.PutUint8(base + 0x09, uint8(e.ReadBy))
+    buf.PutUint8(base + 0x0a, uint8(e.Section))
+    buf.PutUint8(base + 0x0b, uint8(e.Location))
+  }
+  buf.PutUint32(0x50, uint32(ofs))
+  buf.PutUint32(0x4c, uint32(len(g.journal)))
+
+  if g.familiar != nil {
+    ofs = buf.BufferLength()
+    buf.InsertBytes(ofs, familiarSize + len(g.familiar.extra) * 8)
+    buf.PutBuffer(ofs, g.familiar.data)
+    extraOfs := 0
+    if len(g.familiar.extra) > 0 { extraOfs = ofs + familiarSize }
+    buf.PutUint32(ofs + 0x48, uint32(extraOfs))
+    for i, s := range g.familiar.extra {
+      buf.PutString(ofs + familiarSize + i*8, 8, s)
+    }
+    buf.PutUint32(0x68, uint32(ofs))
+  } else {
+    buf.PutUint32(0x68, 0)
+  }
+
+  ofs = exportLocations(buf, g.stored)
+  buf.PutUint32(0x6c, uint32(ofs))
+  buf.PutUint32(0x70, uint32(len(g.stored)))
+  ofs = exportLocations(buf, g.pocket)
+  buf.PutUint32(0x78, uint32(ofs))
+  buf.PutUint32(0x7c, uint32(len(g.pocket)))
+
+  return buf
+}
+
+// Used internally. Appends the given NPC structures, followed by their embedded CRE resources, to the buffer.
+// Returns the offset to the first NPC structure.
+func exportNpcs(buf *buffers.Buffer, list []*Npc) int {
+  ofs := buf.BufferLength()
+  buf.InsertBytes(ofs, len(list) * npcSize)
+  for i, npc := range list {
+    base := ofs + i * npcSize
+    buf.PutBuffer(base, npc.data.Bytes())
+    if npc.cre != nil && npc.cre.BufferLength() > 0 {
+      creOfs := buf.BufferLength()
+      buf.InsertBytes(creOfs, npc.cre.BufferLength())
+      buf.PutBuffer(creOfs, npc.cre.Bytes())
+      buf.PutUint32(base + 0x04, uint32(creOfs))
+      buf.PutUint32(base + 0x08, uint32(npc.cre.BufferLength()))
+    } else {
+      buf.PutUint32(base + 0x04, 0)
+      buf.PutUint32(base + 0x08, 0)
+    }
+  }
+  return ofs
+}
+
+// Used internally. Appends the given location structures to the buffer. Returns the offset to the first structure.
+func exportLocations(buf *buffers.Buffer, list []Location) int {
+  ofs := buf.BufferLength()
+  buf.InsertBytes(ofs, len(list) * locationSize)
+  for i, loc := range list {
+    base := ofs + i * locationSize
+    buf.PutString(base, 8, loc.Area)
+    buf.PutUint16(base + 8, uint16(loc.X))
+    buf.PutUint16(base + 10, uint16(loc.Y))
+  }
+  return ofs
+}
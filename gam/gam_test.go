@@ -0,0 +1,47 @@
+package gam
+
+import (
+  "bytes"
+  "encoding/binary"
+  "testing"
+)
+
+// Returns a minimal GAM resource with a familiar info structure. Every familiar count is set to count, the offset to
+// the extra familiar resources is set to extraOfs. Additional data is appended to the familiar structure.
+func familiarGame(count, extraOfs uint32, extra []byte) []byte {
+  data := make([]byte, headerSize + familiarSize, headerSize + familiarSize + len(extra))
+  copy(data, "GAMEV2.0")
+  binary.LittleEndian.PutUint32(data[0x68:], headerSize)
+  f := data[headerSize:]
+  binary.LittleEndian.PutUint32(f[0x48:], extraOfs)
+  for i := 0x4c; i < familiarSize; i += 4 { binary.LittleEndian.PutUint32(f[i:], count) }
+  return append(data, extra...)
+}
+
+
+func TestLoadFamiliarCounts(t *testing.T) {
+  for _, extraOfs := range []uint32{ 0, headerSize, 0xffffffff } {
+    data := familiarGame(0xffffffff, extraOfs, nil)
+    if len(data) != 580 { t.Fatalf("size = %d", len(data)) }
+    if g := Load(bytes.NewReader(data)); g.Error() == nil { t.Errorf("offset 0x%x: counts accepted", extraOfs) }
+  }
+
+  // one count without extra familiar resources
+  data := familiarGame(0, 0, nil)
+  binary.LittleEndian.PutUint32(data[headerSize + 0x4c:], 1)
+  if g := Load(bytes.NewReader(data)); g.Error() == nil { t.Error("count without offset accepted") }
+
+  g := Load(bytes.NewReader(familiarGame(0, 0, nil)))
+  if g.Error() != nil || g.GetFamiliar() == nil || len(g.GetFamiliar().GetExtraFamiliars()) != 0 { t.Fatal(g.Error()) }
+}
+
+func TestLoadExtraFamiliars(t *testing.T) {
+  extra := []byte("FAMCAT\x00\x00FAMDUST\x00")
+  data := familiarGame(0, headerSize + familiarSize, extra)
+  binary.LittleEndian.PutUint32(data[headerSize + 0x4c:], 2)
+  g := Load(bytes.NewReader(data))
+  if g.Error() != nil { t.Fatal(g.Error()) }
+  if list := g.GetFamiliar().GetExtraFamiliars(); len(list) != 2 || list[0] != "FAMCAT" || list[1] != "FAMDUST" {
+    t.Fatalf("extra = %q", list)
+  }
+}
@@ -3,16 +3,24 @@ Package ietools provides a collection of types, constants and functions inspired
 
 More specific functionality can be found in the respective sub-packages:
   - package buffers:  Functions and types for manipulating data buffers.
+  - package dlg:      Functions and types for DLG V1.0 dialog resources.
+  - package eff:      Functions and types for V1 and V2 effect structures.
+  - package gam:      Functions and types for GAM V2.0 savegame resources.
+  - package ini:      Functions and types for INI and baldur.lua configuration files.
   - package pvrz:     Functions and types for handling pvr/pvrz data.
+  - package script:   Functions and types for BCS/BS script resources.
   - package tables:   Functions and types for table-related operations.
+  - package tlk:      Functions and types for TLK string tables.
 */
 package ietools
 
 import (
   "errors"
   "path"
+  "strconv"
   "strings"
 
+  "golang.org/x/text/encoding"
   "golang.org/x/text/encoding/charmap"
 )
 
@@ -59,23 +67,27 @@ var (
 )
 
 
-// AnsiToUtf8 converts an ANSI-encoded byte array into an UTF-8 string with the provided character map.
-// Provide a nil charmap to assume Windows-1252 encoding.
-func AnsiToUtf8(buffer []byte, cm *charmap.Charmap) (string, error) {
+// AnsiToUtf8 converts an ANSI-encoded byte array into an UTF-8 string with the provided encoding, such as a character
+// map of package charmap or a multi-byte encoding (see EncodingByLanguage).
+// Provide a nil encoding to assume Windows-1252 encoding.
+func AnsiToUtf8(buffer []byte, enc encoding.Encoding) (string, error) {
   if buffer == nil || len(buffer) == 0 { return "", nil }
 
-  if cm == nil { cm = charmap.Windows1252 }
-  decoder := cm.NewDecoder()
+  enc = NormalizeEncoding(enc)
+  if enc == nil { enc = charmap.Windows1252 }
+  decoder := enc.NewDecoder()
   out, err := decoder.Bytes(buffer)
   if err != nil { return "", err }
   return string(out), nil
 }
 
-// Utf8ToAnsi converts an UTF-8 string into a byte array of the specified ANSI encoding.
-// Provide a nil charmap to convert to Windows-1252 encoding.
-func Utf8ToAnsi(text string, cm *charmap.Charmap) ([]byte, error) {
-  if cm == nil { cm = charmap.Windows1252 }
-  encoder := cm.NewEncoder()
+// Utf8ToAnsi converts an UTF-8 string into a byte array of the specified ANSI encoding, such as a character map of
+// package charmap or a multi-byte encoding (see EncodingByLanguage).
+// Provide a nil encoding to convert to Windows-1252 encoding.
+func Utf8ToAnsi(text string, enc encoding.Encoding) ([]byte, error) {
+  enc = NormalizeEncoding(enc)
+  if enc == nil { enc = charmap.Windows1252 }
+  encoder := enc.NewEncoder()
   out, err := encoder.Bytes([]byte(text))
   if err != nil { return nil, err }
   return out, nil
@@ -113,3 +125,32 @@ func AssembleFilePath(dir, name, ext string) string {
 
   return retVal
 }
+
+// ParseNumber parses a decimal or hexadecimal (0x prefix) integer with an optional leading sign. Only a single sign
+// is accepted, e.g. "--5" and "-+5" are rejected.
+//
+// The value must fit into a signed integer of bitSize bits (1 to 64). Unsigned hexadecimal values may cover the full
+// unsigned range of bitSize bits and are interpreted as two's complement, e.g. "0xFFFFFFFF" returns -1 for bitSize 32.
+// Errors are of type *strconv.NumError.
+func ParseNumber(s string, bitSize int) (int64, error) {
+  if bitSize < 1 || bitSize > 64 { bitSize = 64 }
+  digits, neg := s, false
+  if len(digits) > 0 && (digits[0] == '-' || digits[0] == '+') { digits, neg = digits[1:], digits[0] == '-' }
+  base := 10
+  if len(digits) > 2 && strings.EqualFold(digits[:2], "0x") { digits, base = digits[2:], 16 }
+
+  // ParseUint does not accept a sign of its own
+  u, err := strconv.ParseUint(digits, base, bitSize)
+  if err != nil { return 0, &strconv.NumError{ Func: "ParseNumber", Num: s, Err: err.(*strconv.NumError).Err } }
+  limit := uint64(1) << uint(bitSize - 1)
+  switch {
+    case neg && u <= limit:
+      return -int64(u), nil
+    case !neg && u < limit:
+      return int64(u), nil
+    case !neg && base == 16:
+      shift := uint(64 - bitSize)
+      return int64(u << shift) >> shift, nil
+  }
+  return 0, &strconv.NumError{ Func: "ParseNumber", Num: s, Err: strconv.ErrRange }
+}
@@ -0,0 +1,437 @@
+/*
+Package ini provides functions for reading and modifying INI-style configuration files, such as BALDUR.INI or the
+spawn INIs of areas, as well as the baldur.lua files of the Enhanced Edition games.
+*/
+package ini
+
+import (
+  "io"
+  "io/ioutil"
+  "regexp"
+  "strconv"
+  "strings"
+
+  "github.com/InfinityTools/go-ietools"
+  "golang.org/x/text/encoding"
+  "golang.org/x/text/encoding/charmap"
+)
+
+const (
+  // Available file formats
+  FORMAT_INI  = 0   // INI file with [section] headers and key=value entries
+  FORMAT_LUA  = 1   // Lua file with SetPrivateProfileString('section','key','value') entries (baldur.lua)
+
+  // Types of lines
+  lineOther   = 0   // blank line, comment or unrecognized content
+  lineSection = 1   // section header
+  lineEntry   = 2   // key/value entry
+)
+
+var (
+  regLua = regexp.MustCompile(`^\s*SetPrivateProfileString\s*\(\s*'((?:[^'\\]|\\.)*)'\s*,\s*'((?:[^'\\]|\\.)*)'\s*,\s*'((?:[^'\\]|\\.)*)'\s*\)\s*;?\s*$`)
+)
+
+// Ini contains the necessary information to query or alter configuration data.
+//
+// Sections and keys are matched case-insensitively. Lines that are not affected by modifications, including comments
+// and blank lines, are preserved as is.
+type Ini struct {
+  lines   []*line             // all lines of the file in original order
+  format  int                 // file format (see FORMAT_xxx constants)
+  cmap    encoding.Encoding   // the character map or encoding to be used for ANSI decoding or encoding
+  newline string              // line break style
+  dirty   bool                // true if content has been modified
+  err     error
+}
+
+// Used internally. A single line of the configuration file.
+type line struct {
+  kind    int       // type of line
+  raw     string    // line content without line break
+  section string    // section name of section headers and entries
+  key     string    // entry key
+  value   string    // entry value
+  prefix  string    // entry line content preceding the value, only used in INI format
+  suffix  string    // entry line content following the value, only used in INI format
+}
+
+
+// Create returns an empty configuration of the specified format (see FORMAT_xxx constants).
+//
+// Text is encoded in ANSI Windows-1252 when saved.
+func Create(format int) *Ini {
+  i := Ini{ lines: make([]*line, 0), format: format, cmap: charmap.Windows1252, newline: "\r\n" }
+  if format != FORMAT_INI && format != FORMAT_LUA { i.err = ietools.ErrIllegalArguments }
+  return &i
+}
+
+// Load uses the given Reader to load configuration data from the underlying buffer. The file format is detected
+// automatically. The function returns a pointer to the Ini object.
+//
+// This function assumes that text is encoded in ANSI Windows-1252.
+// Use function Error to check if the Load function returned successfully.
+func Load(r io.Reader) *Ini {
+  return LoadEx(r, charmap.Windows1252)
+}
+
+// LoadEx uses the given Reader to load configuration data from the underlying buffer, using the specified character
+// map or encoding for ANSI decoding. The file format is detected automatically.
+//
+// Specify a nil encoding to skip the decoding operation, e.g. for UTF-8 encoded files of the Enhanced Edition games.
+// Use function Error to check if the Load function returned successfully.
+func LoadEx(r io.Reader, cmap encoding.Encoding) *Ini {
+  i := Create(FORMAT_INI)
+  i.cmap = ietools.NormalizeEncoding(cmap)
+  data, err := ioutil.ReadAll(r)
+  if err != nil { i.err = err; return i }
+
+  text := string(data)
+  if i.cmap != nil {
+    text, err = ietools.AnsiToUtf8(data, i.cmap)
+    if err != nil { i.err = err; return i }
+  }
+  i.importIni(text)
+  return i
+}
+
+// Save writes the current configuration data to the specified Writer, encoding text as specified by the Load
+// function.
+//
+// Does nothing if the Ini is in an invalid state (see Error function).
+func (i *Ini) Save(w io.Writer) {
+  i.SaveEx(w, i.cmap)
+}
+
+// SaveEx writes the current configuration data to the specified Writer, using the specified character map or encoding
+// for ANSI encoding.
+//
+// Specify a nil encoding to skip the encoding operation. Does nothing if the Ini is in an invalid state (see Error
+// function).
+func (i *Ini) SaveEx(w io.Writer, cmap encoding.Encoding) {
+  if i.err != nil { return }
+
+  var sb strings.Builder
+  for _, l := range i.lines {
+    sb.WriteString(l.raw)
+    sb.WriteString(i.newline)
+  }
+  data := []byte(sb.String())
+  if cmap = ietools.NormalizeEncoding(cmap); cmap != nil {
+    buf, err := ietools.Utf8ToAnsi(sb.String(), cmap)
+    if err != nil { i.err = err; return }
+    data = buf
+  }
+  _, err := w.Write(data)
+  if err != nil { i.err = err; return }
+  i.dirty = false
+}
+
+
+// Error returns the error state of the most recent operation on Ini. Use ClearError function to clear the current
+// error state.
+func (i *Ini) Error() error {
+  return i.err
+}
+
+// ClearError clears the error state from the last Ini operation. Must be called for subsequent operations to work
+// correctly.
+func (i *Ini) ClearError() {
+  i.err = nil
+}
+
+// IsModified returns whether the current configuration has been modified by a previous operation.
+// The return value is only provided for informal purposes. None of the Ini functions rely on it.
+func (i *Ini) IsModified() bool {
+  return i.dirty
+}
+
+// ClearModified explicitly marks the Ini object as unmodified.
+func (i *Ini) ClearModified() {
+  i.dirty = false
+}
+
+// GetFormat returns the file format of the configuration (see FORMAT_xxx constants).
+func (i *Ini) GetFormat() int {
+  return i.format
+}
+
+
+// Sections returns the names of all sections in order of their first appearance.
+// Operation is skipped if error state is set.
+func (i *Ini) Sections() []string {
+  if i.err != nil { return nil }
+  list := make([]string, 0)
+  seen := make(map[string]bool)
+  for _, l := range i.lines {
+    if l.kind == lineOther { continue }
+    if name := strings.ToLower(l.section); !seen[name] {
+      seen[name] = true
+      list = append(list, l.section)
+    }
+  }
+  return list
+}
+
+// HasSection returns whether the specified section exists.
+// Operation is skipped if error state is set.
+func (i *Ini) HasSection(section string) bool {
+  if i.err != nil { return false }
+  for _, l := range i.lines {
+    if l.kind != lineOther && strings.EqualFold(l.section, section) { return true }
+  }
+  return false
+}
+
+// Keys returns the keys of all entries of the specified section in order of their first appearance.
+// Operation is skipped if error state is set.
+func (i *Ini) Keys(section string) []string {
+  if i.err != nil { return nil }
+  list := make([]string, 0)
+  seen := make(map[string]bool)
+  for _, l := range i.lines {
+    if l.kind != lineEntry || !strings.EqualFold(l.section, section) { continue }
+    if key := strings.ToLower(l.key); !seen[key] {
+      seen[key] = true
+      list = append(list, l.key)
+    }
+  }
+  return list
+}
+
+// Get returns the value of the first entry with the specified key in the specified section. Returns false if the
+// entry does not exist.
+// Operation is skipped if error state is set.
+func (i *Ini) Get(section, key string) (string, bool) {
+  if i.err != nil { return "", false }
+  if l := i.find(section, key); l != nil { return l.value, true }
+  return "", false
+}
+
+// GetDef returns the value of the first entry with the specified key in the specified section. Returns def if the
+// entry does not exist.
+// Operation is skipped if error state is set.
+func (i *Ini) GetDef(section, key, def string) string {
+  if v, ok := i.Get(section, key); ok { return v }
+  return def
+}
+
+// GetIntDef returns the value of the first entry with the specified key in the specified section as integer value.
+// Decimal and hexadecimal notation (0x prefix) is supported. Returns def if the entry does not exist or is not a valid
+// number.
+// Operation is skipped if error state is set.
+func (i *Ini) GetIntDef(section, key string, def int) int {
+  v, ok := i.Get(section, key)
+  if !ok { return def }
+  n, err := strconv.ParseInt(strings.TrimSpace(v), 0, 64)
+  if err != nil { return def }
+  return int(n)
+}
+
+// GetList returns the comma-separated items of the first entry with the specified key in the specified section, such
+// as the list of events or critters of spawn INIs. Items are trimmed. Empty items are skipped.
+// Operation is skipped if error state is set.
+func (i *Ini) GetList(section, key string) []string {
+  list := make([]string, 0)
+  v, ok := i.Get(section, key)
+  if !ok { return list }
+  for _, item := range strings.Split(v, ",") {
+    if item = strings.TrimSpace(item); len(item) > 0 { list = append(list, item) }
+  }
+  return list
+}
+
+// Set assigns value to the first entry with the specified key in the specified section. The entry is added to the end
+// of the section if it does not exist. The section is added to the end of the file if it does not exist.
+//
+// Sets error state if section or key are empty. Operation is skipped if error state is set.
+func (i *Ini) Set(section, key, value string) {
+  if i.err != nil { return }
+  section, key = strings.TrimSpace(section), strings.TrimSpace(key)
+  if len(section) == 0 || len(key) == 0 { i.err = ietools.ErrIllegalArguments; return }
+
+  if l := i.find(section, key); l != nil {
+    if l.value != value {
+      l.value = value
+      l.raw = i.formatEntry(l)
+      i.dirty = true
+    }
+    return
+  }
+
+  l := &line{ kind: lineEntry, section: section, key: key, value: value }
+  l.raw = i.formatEntry(l)
+  i.insertLine(i.sectionEnd(section), l)
+  i.dirty = true
+}
+
+// SetList assigns the given items as comma-separated list to the first entry with the specified key in the specified
+// section. See Set for details.
+func (i *Ini) SetList(section, key string, items []string) {
+  i.Set(section, key, strings.Join(items, ","))
+}
+
+// Remove removes all entries with the specified key from the specified section. Returns the number of removed entries.
+// Operation is skipped if error state is set.
+func (i *Ini) Remove(section, key string) int {
+  if i.err != nil { return 0 }
+  return i.removeLines(func(l *line) bool {
+    return l.kind == lineEntry && strings.EqualFold(l.section, section) && strings.EqualFold(l.key, key)
+  })
+}
+
+// RemoveSection removes the specified section including all entries, comments and blank lines up to the next
+// section. Returns the number of removed entries.
+// Operation is skipped if error state is set.
+func (i *Ini) RemoveSection(section string) int {
+  if i.err != nil { return 0 }
+  count := 0
+  for _, l := range i.lines {
+    if l.kind == lineEntry && strings.EqualFold(l.section, section) { count++ }
+  }
+
+  inSection := false
+  i.removeLines(func(l *line) bool {
+    if l.kind == lineSection { inSection = strings.EqualFold(l.section, section) }
+    if i.format == FORMAT_LUA { return l.kind == lineEntry && strings.EqualFold(l.section, section) }
+    return inSection
+  })
+  return count
+}
+
+
+// Used internally. Parses the lines of the given text.
+func (i *Ini) importIni(text string) {
+  if idx := strings.Index(text, "\n"); idx >= 0 {
+    if idx > 0 && text[idx-1] == '\r' { i.newline = "\r\n" } else { i.newline = "\n" }
+  }
+  text = strings.TrimSuffix(strings.Replace(text, "\r\n", "\n", -1), "\n")
+  if len(text) == 0 { return }
+
+  for _, s := range strings.Split(text, "\n") {
+    if regLua.MatchString(s) { i.format = FORMAT_LUA; break }
+  }
+
+  section := ""
+  for _, s := range strings.Split(text, "\n") {
+    l := &line{ kind: lineOther, raw: s }
+    if i.format == FORMAT_LUA {
+      if m := regLua.FindStringSubmatch(s); m != nil {
+        l.kind, l.section, l.key, l.value = lineEntry, unescapeLua(m[1]), unescapeLua(m[2]), unescapeLua(m[3])
+      }
+    } else {
+      trimmed := strings.TrimSpace(s)
+      switch {
+        case len(trimmed) == 0 || trimmed[0] == ';' || trimmed[0] == '#' || strings.HasPrefix(trimmed, "//"):
+          // comment or blank line
+        case trimmed[0] == '[':
+          if end := strings.Index(trimmed, "]"); end > 0 {
+            section = strings.TrimSpace(trimmed[1:end])
+            l.kind, l.section = lineSection, section
+          }
+        case strings.Contains(s, "="):
+          pos := strings.Index(s, "=")
+          start := pos + 1
+          for start < len(s) && (s[start] == ' ' || s[start] == '\t') { start++ }
+          end := len(s)
+          for end > start && (s[end-1] == ' ' || s[end-1] == '\t') { end-- }
+          l.kind, l.section, l.key = lineEntry, section, strings.TrimSpace(s[:pos])
+          l.value, l.prefix, l.suffix = s[start:end], s[:start], s[end:]
+      }
+    }
+    i.lines = append(i.lines, l)
+  }
+}
+
+// Used internally. Returns the first entry with the specified key in the specified section. Returns nil if not found.
+func (i *Ini) find(section, key string) *line {
+  for _, l := range i.lines {
+    if l.kind == lineEntry && strings.EqualFold(l.section, section) && strings.EqualFold(l.key, key) { return l }
+  }
+  return nil
+}
+
+// Used internally. Returns the textual representation of the given entry line.
+func (i *Ini) formatEntry(l *line) string {
+  if i.format == FORMAT_LUA {
+    return "SetPrivateProfileString('" + escapeLua(l.section) + "','" + escapeLua(l.key) + "','" +
+           escapeLua(l.value) + "')"
+  }
+  if len(l.prefix) == 0 { l.prefix = l.key + "=" }
+  return l.prefix + l.value + l.suffix
+}
+
+// Used internally. Returns the line index where new entries of the specified section should be inserted. Adds the
+// section header if needed.
+func (i *Ini) sectionEnd(section string) int {
+  if i.format == FORMAT_LUA {
+    for idx := len(i.lines) - 1; idx >= 0; idx-- {
+      if i.lines[idx].kind == lineEntry && strings.EqualFold(i.lines[idx].section, section) { return idx + 1 }
+    }
+    return len(i.lines)
+  }
+
+  // position after the last entry of the section
+  pos, inSection := -1, false
+  for idx, l := range i.lines {
+    if l.kind == lineSection {
+      inSection = strings.EqualFold(l.section, section)
+      if inSection { pos = idx + 1 }
+    } else if inSection && l.kind == lineEntry {
+      pos = idx + 1
+    }
+  }
+  if pos >= 0 { return pos }
+
+  if len(i.lines) > 0 && len(strings.TrimSpace(i.lines[len(i.lines) - 1].raw)) > 0 {
+    i.insertLine(len(i.lines), &line{ kind: lineOther })
+  }
+  i.insertLine(len(i.lines), &line{ kind: lineSection, section: section, raw: "[" + section + "]" })
+  return len(i.lines)
+}
+
+// Used internally. Inserts a line at the specified position.
+func (i *Ini) insertLine(pos int, l *line) {
+  i.lines = append(i.lines, nil)
+  copy(i.lines[pos+1:], i.lines[pos:])
+  i.lines[pos] = l
+}
+
+// Used internally. Removes all lines matching the given predicate. Returns the number of removed entry lines.
+func (i *Ini) removeLines(pred func(l *line) bool) int {
+  count := 0
+  lines := make([]*line, 0, len(i.lines))
+  for _, l := range i.lines {
+    if pred(l) {
+      if l.kind == lineEntry { count++ }
+      continue
+    }
+    lines = append(lines, l)
+  }
+  if len(lines) != len(i.lines) { i.dirty = true }
+  i.lines = lines
+  return count
+}
+
+// Used internally. Resolves backslash escape sequences of a Lua string literal.
+func unescapeLua(s string) string {
+  if !strings.Contains(s, "\\") { return s }
+  var sb strings.Builder
+  for pos := 0; pos < len(s); pos++ {
+    if s[pos] == '\\' && pos + 1 < len(s) {
+      pos++
+      switch s[pos] {
+        case 'n': sb.WriteByte('\n')
+        case 't': sb.WriteByte('\t')
+        default:  sb.WriteByte(s[pos])
+      }
+      continue
+    }
+    sb.WriteByte(s[pos])
+  }
+  return sb.String()
+}
+
+// Used internally. Escapes special characters for use in a single-quoted Lua string literal.
+func escapeLua(s string) string {
+  return strings.NewReplacer("\\", "\\\\", "'", "\\'", "\n", "\\n", "\t", "\\t").Replace(s)
+}
@@ -0,0 +1,60 @@
+package ini
+
+import (
+  "fmt"
+  "regexp"
+  "strconv"
+  "strings"
+)
+
+var (
+  regSpawnPoint = regexp.MustCompile(`\[\s*(-?\d+)\s*[.,]\s*(-?\d+)\s*(?::\s*(-?\d+)\s*)?\]`)
+)
+
+// SpawnPoint defines a single location of the spawn_point entries in spawn INIs.
+type SpawnPoint struct {
+  X           int
+  Y           int
+  Orientation int   // orientation (0-15), -1 if not specified
+}
+
+
+// String returns the spawn point in INI notation, e.g. "[1234.567:8]".
+func (p SpawnPoint) String() string {
+  if p.Orientation < 0 { return fmt.Sprintf("[%d.%d]", p.X, p.Y) }
+  return fmt.Sprintf("[%d.%d:%d]", p.X, p.Y, p.Orientation)
+}
+
+// GetSpawnPoints returns the spawn points of the first entry with the specified key in the specified section, such as
+// "spawn_point" of spawn INIs. Points are expected in the form "[x.y:o]" or "[x.y]" and may be separated by commas or
+// whitespace. Returns an empty list if the entry does not exist.
+//
+// Sets error state if the entry contains malformed content. Operation is skipped if error state is set.
+func (i *Ini) GetSpawnPoints(section, key string) []SpawnPoint {
+  list := make([]SpawnPoint, 0)
+  v, ok := i.Get(section, key)
+  if !ok { return list }
+
+  rest := v
+  for _, m := range regSpawnPoint.FindAllStringSubmatch(v, -1) {
+    p := SpawnPoint{ Orientation: -1 }
+    p.X, _ = strconv.Atoi(m[1])
+    p.Y, _ = strconv.Atoi(m[2])
+    if len(m[3]) > 0 { p.Orientation, _ = strconv.Atoi(m[3]) }
+    list = append(list, p)
+    rest = strings.Replace(rest, m[0], "", 1)
+  }
+  if len(strings.Trim(rest, ", \t")) > 0 {
+    i.err = fmt.Errorf("Invalid spawn point definition: %q", v)
+    return make([]SpawnPoint, 0)
+  }
+  return list
+}
+
+// SetSpawnPoints assigns the given spawn points to the first entry with the specified key in the specified section.
+// Points are separated by commas. See Set for details.
+func (i *Ini) SetSpawnPoints(section, key string, points []SpawnPoint) {
+  items := make([]string, len(points))
+  for idx, p := range points { items[idx] = p.String() }
+  i.SetList(section, key, items)
+}
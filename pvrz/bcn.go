@@ -0,0 +1,444 @@
+package pvrz
+
+import (
+  "image"
+  "sort"
+)
+
+const (
+  maxClusterIterations  = 8   // max. number of refinement passes of the iterative cluster fit
+)
+
+var (
+  metricUniform     = [3]float64{ 1.0, 1.0, 1.0 }
+  metricPerceptual  = [3]float64{ 0.2126, 0.7152, 0.0722 }
+  grid565           = [3]float64{ 31.0, 63.0, 31.0 }
+)
+
+// Parameters and pixel data of a single color block to be encoded.
+type colorBlock struct {
+  colors    [16][3]float64  // pixel colors in range [0, 1]
+  weights   [16]float64     // pixel weights
+  indices   []int           // pixels that contribute to the color fit
+  metric    [3]float64      // color channel weights
+  quality   int             // encoding quality (see QUALITY_xxx constants)
+}
+
+// Result of a color fit.
+type colorFit struct {
+  start   [3]float64    // quantized color of the first endpoint
+  end     [3]float64    // quantized color of the second endpoint
+  codes   [16]int       // interpolation position of each pixel: 0 (start) to steps-1 (end)
+  steps   int           // number of interpolation steps: 3 or 4
+  err     float64       // weighted squared error
+}
+
+
+// Used internally. Decodes a BC1 block. Blocks with color0 <= color1 provide three colors and transparent black.
+func decodeBC1(block []byte, pixels []uint8) {
+  decodeColorBlock(block, pixels, true)
+}
+
+// Used internally. Decodes a BC2 block with explicit 4-bit alpha.
+func decodeBC2(block []byte, pixels []uint8) {
+  decodeColorBlock(block[8:16], pixels, false)
+  for i := 0; i < 16; i++ {
+    a := (block[i >> 1] >> (uint(i & 1) * 4)) & 15
+    pixels[i * 4 + 3] = a | (a << 4)
+  }
+}
+
+// Used internally. Decodes a BC3 block with interpolated alpha.
+func decodeBC3(block []byte, pixels []uint8) {
+  decodeColorBlock(block[8:16], pixels, false)
+  decodeAlphaBlock(block[0:8], pixels[3:])
+}
+
+// Used internally. Decodes the color part of a BC1, BC2 or BC3 block. Set "isBC1" to support the three-color mode.
+func decodeColorBlock(block []byte, pixels []uint8, isBC1 bool) {
+  c0, c1 := int(block[0]) | int(block[1]) << 8, int(block[2]) | int(block[3]) << 8
+  var codes [4][4]int
+  codes[0], codes[1] = unpack565(c0), unpack565(c1)
+  for c := 0; c < 3; c++ {
+    if c0 > c1 || !isBC1 {
+      codes[2][c] = (2 * codes[0][c] + codes[1][c]) / 3
+      codes[3][c] = (codes[0][c] + 2 * codes[1][c]) / 3
+    } else {
+      codes[2][c] = (codes[0][c] + codes[1][c]) / 2
+    }
+  }
+  codes[0][3], codes[1][3], codes[2][3] = 255, 255, 255
+  if c0 > c1 || !isBC1 { codes[3][3] = 255 }
+
+  for i := 0; i < 16; i++ {
+    idx := (block[4 + (i >> 2)] >> (uint(i & 3) * 2)) & 3
+    for c := 0; c < 4; c++ { pixels[i * 4 + c] = uint8(codes[idx][c]) }
+  }
+}
+
+// Used internally. Encodes 32-bit non-premultiplied pixel data into BC1, BC2 or BC3 blocks. Dimension must be a
+// multiple of 4.
+//
+// Quality modes correspond to the squish fitting algorithms: QUALITY_LOW uses a range fit along the principal axis,
+// QUALITY_DEFAULT a cluster fit and QUALITY_HIGH an iterative cluster fit.
+func encodeBlocks(img *image.NRGBA, pixelType, quality int, weightByAlpha, useMetric bool) []byte {
+  bf, ok := blockFormats[pixelType]
+  if !ok || (pixelType != TYPE_BC1 && pixelType != TYPE_BC2 && pixelType != TYPE_BC3) { return nil }
+  width, height := img.Bounds().Dx(), img.Bounds().Dy()
+  bx, by := bf.blocks(width, height)
+  data := make([]byte, bx * by * bf.size)
+  pixels := make([]uint8, 64)
+  for y := 0; y < by; y++ {
+    for x := 0; x < bx; x++ {
+      for row := 0; row < 4; row++ {
+        src := img.PixOffset(x * 4, y * 4 + row)
+        copy(pixels[row * 16:(row + 1) * 16], img.Pix[src:src + 16])
+      }
+      ofs := (y * bx + x) * bf.size
+      encodeBlock(pixels, data[ofs:ofs + bf.size], pixelType, quality, weightByAlpha, useMetric)
+    }
+  }
+  return data
+}
+
+// Used internally. Encodes the 4x4 RGBA pixels of a single block.
+func encodeBlock(pixels []uint8, block []byte, pixelType, quality int, weightByAlpha, useMetric bool) {
+  cb := colorBlock{ metric: metricUniform, quality: quality }
+  if useMetric { cb.metric = metricPerceptual }
+  transparent := false
+  for i := 0; i < 16; i++ {
+    for c := 0; c < 3; c++ { cb.colors[i][c] = float64(pixels[i * 4 + c]) / 255.0 }
+    cb.weights[i] = 1.0
+    if weightByAlpha { cb.weights[i] = float64(int(pixels[i * 4 + 3]) + 1) / 256.0 }
+    if pixelType == TYPE_BC1 && pixels[i * 4 + 3] < 128 {
+      transparent = true
+      continue
+    }
+    cb.indices = append(cb.indices, i)
+  }
+
+  switch pixelType {
+    case TYPE_BC1:
+      var fit colorFit
+      if transparent {
+        fit = cb.fit(3)
+      } else {
+        fit = cb.fit(4)
+        if fit3 := cb.fit(3); fit3.err < fit.err { fit = fit3 }
+      }
+      writeColorBlock(fit, &cb, block[0:8])
+    case TYPE_BC2:
+      writeColorBlock(cb.fit(4), &cb, block[8:16])
+      for i := 0; i < 16; i++ {
+        a := (int(pixels[i * 4 + 3]) * 15 + 127) / 255
+        block[i >> 1] |= byte(a << (uint(i & 1) * 4))
+      }
+    case TYPE_BC3:
+      writeColorBlock(cb.fit(4), &cb, block[8:16])
+      var alpha [16]int
+      for i := 0; i < 16; i++ { alpha[i] = int(pixels[i * 4 + 3]) }
+      encodeAlphaBlock(alpha, block[0:8])
+  }
+}
+
+// Used internally. Returns the best fit of the block colors with the specified number of interpolation steps (3 or 4).
+func (cb *colorBlock) fit(steps int) colorFit {
+  best := colorFit{ steps: steps }
+  if len(cb.indices) == 0 { return best }
+
+  // single color: use exact endpoints
+  single := true
+  for _, i := range cb.indices[1:] {
+    if cb.colors[i] != cb.colors[cb.indices[0]] { single = false; break }
+  }
+  axis := cb.principalAxis()
+  if single || cb.quality == QUALITY_LOW {
+    return cb.rangeFit(axis, steps)
+  }
+
+  best.err = -1.0
+  iterations := 1
+  if cb.quality == QUALITY_HIGH { iterations = maxClusterIterations }
+  for iter := 0; iter < iterations; iter++ {
+    fit := cb.clusterFit(axis, steps)
+    if best.err >= 0.0 && fit.err >= best.err { break }
+    best = fit
+    for c := 0; c < 3; c++ { axis[c] = best.end[c] - best.start[c] }
+  }
+  return best
+}
+
+// Used internally. Computes the principal axis of the weighted block colors by power iteration.
+func (cb *colorBlock) principalAxis() [3]float64 {
+  var mean [3]float64
+  total := 0.0
+  for _, i := range cb.indices {
+    for c := 0; c < 3; c++ { mean[c] += cb.weights[i] * cb.colors[i][c] }
+    total += cb.weights[i]
+  }
+  if total > 0.0 {
+    for c := 0; c < 3; c++ { mean[c] /= total }
+  }
+
+  var cov [3][3]float64
+  for _, i := range cb.indices {
+    var d [3]float64
+    for c := 0; c < 3; c++ { d[c] = (cb.colors[i][c] - mean[c]) * cb.metric[c] }
+    for r := 0; r < 3; r++ {
+      for c := 0; c < 3; c++ { cov[r][c] += cb.weights[i] * d[r] * d[c] }
+    }
+  }
+
+  axis := [3]float64{ 1.0, 1.0, 1.0 }
+  for iter := 0; iter < 8; iter++ {
+    var v [3]float64
+    for r := 0; r < 3; r++ { v[r] = cov[r][0] * axis[0] + cov[r][1] * axis[1] + cov[r][2] * axis[2] }
+    m := maxAbs(v)
+    if m == 0.0 { break }
+    for c := 0; c < 3; c++ { axis[c] = v[c] / m }
+  }
+  return axis
+}
+
+// Used internally. Fits endpoints to the extreme block colors along the given axis.
+func (cb *colorBlock) rangeFit(axis [3]float64, steps int) colorFit {
+  first, last := cb.indices[0], cb.indices[0]
+  minDot, maxDot := dot(cb.colors[first], axis), dot(cb.colors[first], axis)
+  for _, i := range cb.indices[1:] {
+    d := dot(cb.colors[i], axis)
+    if d < minDot { minDot, first = d, i }
+    if d > maxDot { maxDot, last = d, i }
+  }
+  fit := colorFit{ start: quantize565(cb.colors[first]), end: quantize565(cb.colors[last]), steps: steps }
+  cb.assignCodes(&fit)
+  return fit
+}
+
+// Used internally. Fits endpoints by least squares for every ordered partition of the block colors along the given
+// axis into clusters of the interpolation steps.
+func (cb *colorBlock) clusterFit(axis [3]float64, steps int) colorFit {
+  order := make([]int, len(cb.indices))
+  copy(order, cb.indices)
+  sort.SliceStable(order, func(a, b int) bool { return dot(cb.colors[order[a]], axis) < dot(cb.colors[order[b]], axis) })
+
+  n := len(order)
+  best := colorFit{ steps: steps, err: -1.0 }
+  // cluster sizes: c0 points at the start, c1 (and c2) points at the interpolated positions, remainder at the end
+  try := func(sizes []int) {
+    var alpha2, beta2, alphabeta float64
+    var alphax, betax [3]float64
+    pos := 0
+    for cluster, size := range sizes {
+      a := 1.0 - float64(cluster) / float64(steps - 1)
+      b := 1.0 - a
+      for k := 0; k < size; k++ {
+        i := order[pos]
+        w := cb.weights[i]
+        alpha2 += w * a * a
+        beta2 += w * b * b
+        alphabeta += w * a * b
+        for c := 0; c < 3; c++ {
+          alphax[c] += w * a * cb.colors[i][c]
+          betax[c] += w * b * cb.colors[i][c]
+        }
+        pos++
+      }
+    }
+    denom := alpha2 * beta2 - alphabeta * alphabeta
+    if denom == 0.0 { return }
+    var fit colorFit
+    fit.steps = steps
+    for c := 0; c < 3; c++ {
+      fit.start[c] = (alphax[c] * beta2 - betax[c] * alphabeta) / denom
+      fit.end[c] = (betax[c] * alpha2 - alphax[c] * alphabeta) / denom
+    }
+    fit.start, fit.end = quantize565(fit.start), quantize565(fit.end)
+    cb.assignCodes(&fit)
+    if best.err < 0.0 || fit.err < best.err { best = fit }
+  }
+
+  if steps == 3 {
+    for c0 := 0; c0 <= n; c0++ {
+      for c1 := 0; c0 + c1 <= n; c1++ { try([]int{ c0, c1, n - c0 - c1 }) }
+    }
+  } else {
+    for c0 := 0; c0 <= n; c0++ {
+      for c1 := 0; c0 + c1 <= n; c1++ {
+        for c2 := 0; c0 + c1 + c2 <= n; c2++ { try([]int{ c0, c1, c2, n - c0 - c1 - c2 }) }
+      }
+    }
+  }
+  if best.err < 0.0 { return cb.rangeFit(axis, steps) }
+  return best
+}
+
+// Used internally. Assigns the closest interpolation position to each contributing pixel and updates the fit error.
+func (cb *colorBlock) assignCodes(fit *colorFit) {
+  var points [4][3]float64
+  for s := 0; s < fit.steps; s++ {
+    a := 1.0 - float64(s) / float64(fit.steps - 1)
+    for c := 0; c < 3; c++ { points[s][c] = a * fit.start[c] + (1.0 - a) * fit.end[c] }
+  }
+  fit.err = 0.0
+  for _, i := range cb.indices {
+    bestErr := -1.0
+    for s := 0; s < fit.steps; s++ {
+      e := 0.0
+      for c := 0; c < 3; c++ {
+        d := (cb.colors[i][c] - points[s][c]) * cb.metric[c]
+        e += d * d
+      }
+      if bestErr < 0.0 || e < bestErr { bestErr, fit.codes[i] = e, s }
+    }
+    fit.err += cb.weights[i] * bestErr
+  }
+}
+
+// Used internally. Writes endpoints and indices of the given color fit as 8-byte color block.
+func writeColorBlock(fit colorFit, cb *colorBlock, block []byte) {
+  a, b := pack565(fit.start), pack565(fit.end)
+  var remap []int
+  if fit.steps == 4 {
+    remap = []int{ 0, 2, 3, 1 }
+    if a < b {
+      a, b = b, a
+      remap = []int{ 1, 3, 2, 0 }
+    } else if a == b {
+      remap = []int{ 0, 0, 0, 0 }
+    }
+  } else {
+    remap = []int{ 0, 2, 1 }
+    if a > b {
+      a, b = b, a
+      remap = []int{ 1, 2, 0 }
+    }
+  }
+
+  var codes [16]int
+  for i := range codes { codes[i] = 3 }   // transparent pixels of three-color mode
+  for _, i := range cb.indices { codes[i] = remap[fit.codes[i]] }
+  block[0], block[1], block[2], block[3] = byte(a), byte(a >> 8), byte(b), byte(b >> 8)
+  for row := 0; row < 4; row++ {
+    block[4 + row] = byte(codes[row*4] | codes[row*4+1] << 2 | codes[row*4+2] << 4 | codes[row*4+3] << 6)
+  }
+}
+
+// Used internally. Encodes alpha values as interpolated 8-byte alpha block, choosing the better of the 6-value
+// (with explicit 0 and 255) and the 8-value mode.
+func encodeAlphaBlock(alpha [16]int, block []byte) {
+  min5, max5, min7, max7 := 255, 0, 255, 0
+  for _, a := range alpha {
+    if a < min7 { min7 = a }
+    if a > max7 { max7 = a }
+    if a != 0 && a < min5 { min5 = a }
+    if a != 255 && a > max5 { max5 = a }
+  }
+  if min5 > max5 { min5, max5 = max5, min5 }
+  if max7 - min7 < 7 { min7, max7 = fixAlphaRange(min7, max7, 7) }
+  if max5 - min5 < 5 { min5, max5 = fixAlphaRange(min5, max5, 5) }
+
+  codes5, err5 := alphaCodes(alpha, min5, max5, 5)
+  codes7, err7 := alphaCodes(alpha, min7, max7, 7)
+  var codes [16]int
+  if err5 <= err7 {
+    // 6-value mode requires a0 <= a1
+    block[0], block[1] = byte(min5), byte(max5)
+    codes = codes5
+  } else {
+    // 8-value mode requires a0 > a1
+    block[0], block[1] = byte(max7), byte(min7)
+    for i, c := range codes7 {
+      switch c {
+        case 0: codes[i] = 1
+        case 1: codes[i] = 0
+        default: codes[i] = 9 - c
+      }
+    }
+  }
+
+  bits := uint64(0)
+  for i := 15; i >= 0; i-- { bits = (bits << 3) | uint64(codes[i]) }
+  for i := 2; i < 8; i++ {
+    block[i] = byte(bits)
+    bits >>= 8
+  }
+}
+
+// Used internally. Returns the closest code of each alpha value for the given range and the total squared error.
+// Codes refer to the decoding order with a0 = min and a1 = max.
+func alphaCodes(alpha [16]int, min, max, steps int) ([16]int, int) {
+  var values [8]int
+  values[0], values[1] = min, max
+  for i := 1; i < steps; i++ { values[i + 1] = ((steps - i) * min + i * max + steps / 2) / steps }
+  count := steps + 1
+  if steps == 5 {
+    values[6], values[7] = 0, 255
+    count = 8
+  }
+
+  var codes [16]int
+  total := 0
+  for p, a := range alpha {
+    bestErr := -1
+    for i := 0; i < count; i++ {
+      d := a - values[i]
+      if bestErr < 0 || d * d < bestErr { bestErr, codes[p] = d * d, i }
+    }
+    total += bestErr
+  }
+  return codes, total
+}
+
+// Used internally. Widens an alpha range to cover at least the given number of steps.
+func fixAlphaRange(min, max, steps int) (int, int) {
+  if max - min < steps {
+    max = min + steps
+    if max > 255 { max = 255 }
+  }
+  if max - min < steps {
+    min = max - steps
+    if min < 0 { min = 0 }
+  }
+  return min, max
+}
+
+// Used internally. Returns the given color clamped to [0, 1] and snapped to the 5:6:5 grid.
+func quantize565(color [3]float64) [3]float64 {
+  var out [3]float64
+  for c := 0; c < 3; c++ {
+    v := color[c]
+    if v < 0.0 { v = 0.0 }
+    if v > 1.0 { v = 1.0 }
+    out[c] = float64(int(v * grid565[c] + 0.5)) / grid565[c]
+  }
+  return out
+}
+
+// Used internally. Packs a quantized color into a 16-bit 5:6:5 value.
+func pack565(color [3]float64) int {
+  r := int(color[0] * 31.0 + 0.5)
+  g := int(color[1] * 63.0 + 0.5)
+  b := int(color[2] * 31.0 + 0.5)
+  return (r << 11) | (g << 5) | b
+}
+
+// Used internally. Unpacks a 16-bit 5:6:5 value into 8-bit RGBA components with full alpha.
+func unpack565(value int) [4]int {
+  r, g, b := (value >> 11) & 31, (value >> 5) & 63, value & 31
+  return [4]int{ (r << 3) | (r >> 2), (g << 2) | (g >> 4), (b << 3) | (b >> 2), 255 }
+}
+
+// Used internally. Returns the dot product of two vectors.
+func dot(a, b [3]float64) float64 {
+  return a[0] * b[0] + a[1] * b[1] + a[2] * b[2]
+}
+
+// Used internally. Returns the largest absolute component of a vector.
+func maxAbs(v [3]float64) float64 {
+  m := 0.0
+  for _, x := range v {
+    if x < 0.0 { x = -x }
+    if x > m { m = x }
+  }
+  return m
+}
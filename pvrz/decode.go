@@ -0,0 +1,97 @@
+package pvrz
+
+import (
+  "errors"
+  "image"
+  "image/draw"
+)
+
+// Describes the block layout of a compressed pixel format.
+type blockFormat struct {
+  width     int   // block width in pixels
+  height    int   // block height in pixels
+  size      int   // block size in bytes
+  minBlocks int   // minimum number of blocks per dimension
+  // decodes a single block into 32-bit RGBA pixels with non-premultiplied alpha, in row-major order.
+  // nil if the pixel format is not decoded block by block.
+  decode    func(block []byte, pixels []uint8)
+}
+
+var blockFormats = map[int]blockFormat{
+  TYPE_BC1:             { 4, 4, 8, 1, decodeBC1 },
+  TYPE_BC2:             { 4, 4, 16, 1, decodeBC2 },
+  TYPE_BC3:             { 4, 4, 16, 1, decodeBC3 },
+  TYPE_BC4:             { 4, 4, 8, 1, decodeBC4 },
+  TYPE_BC5:             { 4, 4, 16, 1, decodeBC5 },
+  TYPE_BC7:             { 4, 4, 16, 1, decodeBC7 },
+  TYPE_ETC1:            { 4, 4, 8, 1, decodeETC1 },
+  TYPE_ETC2_RGB:        { 4, 4, 8, 1, decodeETC2 },
+  TYPE_ETC2_RGBA:       { 4, 4, 16, 1, decodeETC2RGBA },
+  TYPE_ETC2_RGB_A1:     { 4, 4, 8, 1, decodeETC2A1 },
+  TYPE_PVRTC_2BPP_RGB:  { 8, 4, 8, 2, nil },
+  TYPE_PVRTC_2BPP_RGBA: { 8, 4, 8, 2, nil },
+  TYPE_PVRTC_4BPP_RGB:  { 4, 4, 8, 2, nil },
+  TYPE_PVRTC_4BPP_RGBA: { 4, 4, 8, 2, nil },
+}
+
+
+// Used internally. Returns the number of blocks per row and column of a block-compressed texture.
+func (bf blockFormat) blocks(width, height int) (int, int) {
+  bx, by := (width + bf.width - 1) / bf.width, (height + bf.height - 1) / bf.height
+  if bx < bf.minBlocks { bx = bf.minBlocks }
+  if by < bf.minBlocks { by = bf.minBlocks }
+  return bx, by
+}
+
+// Used internally. Returns an error if the given dimension is not supported by the specified block-compressed
+// pixel format.
+func checkDimension(width, height, pixelType int) error {
+  switch pixelType {
+    case TYPE_PVRTC_2BPP_RGB, TYPE_PVRTC_2BPP_RGBA, TYPE_PVRTC_4BPP_RGB, TYPE_PVRTC_4BPP_RGBA:
+      if width & (width - 1) != 0 || height & (height - 1) != 0 {
+        return errors.New("PVRTC texture dimension must be a power of 2")
+      }
+    default:
+      if (height & 3) != 0 { return errors.New("Texture height must be a multiple of 4") }
+      if (width & 3) != 0 { return errors.New("Texture width must be a multiple of 4") }
+  }
+  return nil
+}
+
+// Used internally. Decodes texture data of the specified pure-Go decoding formats into a 32-bit image with
+// non-premultiplied alpha. The dimension must be padded to full blocks. Returns nil on error.
+func decodeBlocks(data []byte, width, height, pixelType int) draw.Image {
+  bf, ok := blockFormats[pixelType]
+  if !ok { return nil }
+  bx, by := bf.blocks(width, height)
+  if len(data) < bx * by * bf.size { return nil }
+
+  switch pixelType {
+    case TYPE_PVRTC_2BPP_RGB, TYPE_PVRTC_2BPP_RGBA:
+      return decodePVRTC(data, bx, by, true)
+    case TYPE_PVRTC_4BPP_RGB, TYPE_PVRTC_4BPP_RGBA:
+      return decodePVRTC(data, bx, by, false)
+  }
+  if bf.decode == nil { return nil }
+
+  img := image.NewNRGBA(image.Rect(0, 0, bx * bf.width, by * bf.height))
+  pixels := make([]uint8, bf.width * bf.height * 4)
+  for y := 0; y < by; y++ {
+    for x := 0; x < bx; x++ {
+      ofs := (y * bx + x) * bf.size
+      bf.decode(data[ofs:ofs+bf.size], pixels)
+      for row := 0; row < bf.height; row++ {
+        dst := img.PixOffset(x * bf.width, y * bf.height + row)
+        copy(img.Pix[dst:dst + bf.width * 4], pixels[row * bf.width * 4:(row + 1) * bf.width * 4])
+      }
+    }
+  }
+  return img
+}
+
+// Used internally. Returns "value" clamped to the range of a byte.
+func clampByte(value int) uint8 {
+  if value < 0 { return 0 }
+  if value > 255 { return 255 }
+  return uint8(value)
+}
@@ -0,0 +1,224 @@
+package pvrz
+
+var (
+  // BC7 modes: subsets, partition bits, rotation bits, index selection bits, color bits, alpha bits,
+  // endpoint p-bits, shared p-bits, index bits, secondary index bits
+  bc7Modes = [8][10]uint{
+    { 3, 4, 0, 0, 4, 0, 1, 0, 3, 0 },
+    { 2, 6, 0, 0, 6, 0, 0, 1, 3, 0 },
+    { 3, 6, 0, 0, 5, 0, 0, 0, 2, 0 },
+    { 2, 6, 0, 0, 7, 0, 1, 0, 2, 0 },
+    { 1, 0, 2, 1, 5, 6, 0, 0, 2, 3 },
+    { 1, 0, 2, 0, 7, 8, 0, 0, 2, 2 },
+    { 1, 0, 0, 0, 7, 7, 1, 0, 4, 0 },
+    { 2, 6, 0, 0, 5, 5, 1, 0, 2, 0 },
+  }
+
+  bc7Weights = [][]int{
+    nil, nil,
+    { 0, 21, 43, 64 },
+    { 0, 9, 18, 27, 37, 46, 55, 64 },
+    { 0, 4, 9, 13, 17, 21, 26, 30, 34, 38, 43, 47, 51, 55, 60, 64 },
+  }
+
+  // Bit masks of pixels assigned to the second subset of the 2-subset partitions
+  bc7Partitions2 = [64]uint16{
+    0xcccc, 0x8888, 0xeeee, 0xecc8, 0xc880, 0xfeec, 0xfec8, 0xec80,
+    0xc800, 0xffec, 0xfe80, 0xe800, 0xffe8, 0xff00, 0xfff0, 0xf000,
+    0xf710, 0x008e, 0x7100, 0x08ce, 0x008c, 0x7310, 0x3100, 0x8cce,
+    0x088c, 0x3110, 0x6666, 0x366c, 0x17e8, 0x0ff0, 0x718e, 0x399c,
+    0xaaaa, 0xf0f0, 0x5a5a, 0x33cc, 0x3c3c, 0x55aa, 0x9696, 0xa55a,
+    0x73ce, 0x13c8, 0x324c, 0x3bdc, 0x6996, 0xc33c, 0x9966, 0x0660,
+    0x0272, 0x04e4, 0x4e40, 0x2720, 0xc936, 0x936c, 0x39c6, 0x639c,
+    0x9336, 0x9cc6, 0x817e, 0xe718, 0xccf0, 0x0fcc, 0x7744, 0xee22,
+  }
+
+  // Subset of each pixel of the 3-subset partitions, 2 bits per pixel starting with the least significant bits
+  bc7Partitions3 = [64]uint32{
+    0xaa685050, 0x6a5a5040, 0x5a5a4200, 0x5450a0a8, 0xa5a50000, 0xa0a05050, 0x5555a0a0, 0x5a5a5050,
+    0xaa550000, 0xaa555500, 0xaaaa5500, 0x90909090, 0x94949494, 0xa4a4a4a4, 0xa9a59450, 0x2a0a4250,
+    0xa5945040, 0x0a425054, 0xa5a5a500, 0x55a0a0a0, 0xa8a85454, 0x6a6a4040, 0xa4a45000, 0x1a1a0500,
+    0x0050a4a4, 0xaaa59090, 0x14696914, 0x69691400, 0xa08585a0, 0xaa821414, 0x50a4a450, 0x6a5a0200,
+    0xa9a58000, 0x5090a0a8, 0xa8a09050, 0x24242424, 0x00aa5500, 0x24924924, 0x24499224, 0x50a50a50,
+    0x500aa550, 0xaaaa4444, 0x66660000, 0xa5a0a5a0, 0x50a050a0, 0x69286928, 0x44aaaa44, 0x66666600,
+    0xaa444444, 0x54a854a8, 0x95809580, 0x96969600, 0xa85454a8, 0x80959580, 0xaa141414, 0x96960000,
+    0xaaaa1414, 0xa05050a0, 0xa0a5a5a0, 0x96000000, 0x40804080, 0xa9a8a9a8, 0xaaaaaa44, 0x2a4a5254,
+  }
+
+  // Anchor index of the second subset of the 2-subset partitions
+  bc7Anchors2 = [64]uint8{
+    15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15,
+    15,  2,  8,  2,  2,  8,  8, 15,  2,  8,  2,  2,  8,  8,  2,  2,
+    15, 15,  6,  8,  2,  8, 15, 15,  2,  8,  2,  2,  2, 15, 15,  6,
+     6,  2,  6,  8, 15, 15,  2,  2, 15, 15, 15, 15, 15,  2,  2, 15,
+  }
+
+  // Anchor indices of the second and third subsets of the 3-subset partitions
+  bc7Anchors3 = [2][64]uint8{
+    {  3,  3, 15, 15,  8,  3, 15, 15,  8,  8,  6,  6,  6,  5,  3,  3,
+       3,  3,  8, 15,  3,  3,  6, 10,  5,  8,  8,  6,  8,  5, 15, 15,
+       8, 15,  3,  5,  6, 10,  8, 15, 15,  3, 15,  5, 15, 15, 15, 15,
+       3, 15,  5,  5,  5,  8,  5, 10,  5, 10,  8, 13, 15, 12,  3,  3 },
+    { 15,  8,  8,  3, 15, 15,  3,  8, 15, 15, 15, 15, 15, 15, 15,  8,
+      15,  8, 15,  3, 15,  8, 15,  8,  3, 15,  6, 10, 15, 15, 10,  8,
+      15,  3, 15, 10, 10,  8,  9, 10,  6, 15,  8, 15,  3,  6,  6,  8,
+      15,  3, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15,  3, 15, 15,  8 },
+  }
+)
+
+
+// Used internally. Decodes a BC3-style interpolated single channel block of 8 bytes into every 4th byte of "pixels".
+func decodeAlphaBlock(block []byte, pixels []uint8) {
+  var values [8]int
+  a0, a1 := int(block[0]), int(block[1])
+  values[0], values[1] = a0, a1
+  if a0 > a1 {
+    for i := 1; i < 7; i++ { values[i + 1] = ((7 - i) * a0 + i * a1 + 3) / 7 }
+  } else {
+    for i := 1; i < 5; i++ { values[i + 1] = ((5 - i) * a0 + i * a1 + 2) / 5 }
+    values[6], values[7] = 0, 255
+  }
+
+  bits := uint64(0)
+  for i := 7; i >= 2; i-- { bits = (bits << 8) | uint64(block[i]) }
+  for i := 0; i < 16; i++ {
+    pixels[i * 4] = uint8(values[bits & 7])
+    bits >>= 3
+  }
+}
+
+// Used internally. Decodes a BC4 block into red pixels.
+func decodeBC4(block []byte, pixels []uint8) {
+  decodeAlphaBlock(block, pixels)
+  for i := 0; i < 16; i++ { pixels[i*4+1], pixels[i*4+2], pixels[i*4+3] = 0, 0, 255 }
+}
+
+// Used internally. Decodes a BC5 block into red and green pixels.
+func decodeBC5(block []byte, pixels []uint8) {
+  decodeAlphaBlock(block[0:8], pixels)
+  decodeAlphaBlock(block[8:16], pixels[1:])
+  for i := 0; i < 16; i++ { pixels[i*4+2], pixels[i*4+3] = 0, 255 }
+}
+
+// Used internally. Provides sequential access to the bits of a BC7 block, starting at the least significant bit.
+type bitReader struct {
+  data  []byte
+  pos   uint
+}
+
+// Used internally. Returns the next "count" bits as unsigned value.
+func (r *bitReader) read(count uint) int {
+  value := 0
+  for i := uint(0); i < count; i++ {
+    bit := (r.data[r.pos >> 3] >> (r.pos & 7)) & 1
+    value |= int(bit) << i
+    r.pos++
+  }
+  return value
+}
+
+// Used internally. Decodes a BC7 block. Blocks of reserved modes are decoded as transparent black.
+func decodeBC7(block []byte, pixels []uint8) {
+  r := bitReader{ data: block }
+  mode := 0
+  for mode < 8 && r.read(1) == 0 { mode++ }
+  if mode >= 8 {
+    for i := range pixels { pixels[i] = 0 }
+    return
+  }
+  m := bc7Modes[mode]
+  numSubsets, colorBits, alphaBits, indexBits, indexBits2 := int(m[0]), m[4], m[5], m[8], m[9]
+  partition := r.read(m[1])
+  rotation := r.read(m[2])
+  indexSelection := r.read(m[3])
+
+  // endpoints: [subset * 2 + endpoint][channel]
+  var endpoints [6][4]int
+  for c := 0; c < 3; c++ {
+    for e := 0; e < numSubsets * 2; e++ { endpoints[e][c] = r.read(colorBits) }
+  }
+  for e := 0; e < numSubsets * 2; e++ {
+    if alphaBits > 0 { endpoints[e][3] = r.read(alphaBits) } else { endpoints[e][3] = 255 }
+  }
+
+  // p-bits and expansion to 8 bits
+  var pbits [6]int
+  numPBits := 0
+  if m[6] > 0 {
+    numPBits = 1
+    for e := 0; e < numSubsets * 2; e++ { pbits[e] = r.read(1) }
+  } else if m[7] > 0 {
+    numPBits = 1
+    for s := 0; s < numSubsets; s++ {
+      p := r.read(1)
+      pbits[s * 2], pbits[s * 2 + 1] = p, p
+    }
+  }
+  for e := 0; e < numSubsets * 2; e++ {
+    for c := 0; c < 4; c++ {
+      bits := colorBits
+      if c == 3 {
+        if alphaBits == 0 { continue }
+        bits = alphaBits
+      }
+      v := endpoints[e][c]
+      if numPBits > 0 {
+        v = (v << 1) | pbits[e]
+        bits++
+      }
+      v <<= 8 - bits
+      endpoints[e][c] = v | (v >> bits)
+    }
+  }
+
+  // subset and anchor of each pixel
+  var subsets [16]int
+  var anchors [16]bool
+  anchors[0] = true
+  for i := 0; i < 16; i++ {
+    switch numSubsets {
+      case 2: subsets[i] = int(bc7Partitions2[partition] >> uint(i)) & 1
+      case 3: subsets[i] = int(bc7Partitions3[partition] >> uint(i * 2)) & 3
+    }
+  }
+  switch numSubsets {
+    case 2: anchors[bc7Anchors2[partition]] = true
+    case 3: anchors[bc7Anchors3[0][partition]], anchors[bc7Anchors3[1][partition]] = true, true
+  }
+
+  // primary and secondary indices
+  var indices, indices2 [16]int
+  for i := 0; i < 16; i++ {
+    bits := indexBits
+    if anchors[i] { bits-- }
+    indices[i] = r.read(bits)
+  }
+  if indexBits2 > 0 {
+    for i := 0; i < 16; i++ {
+      bits := indexBits2
+      if i == 0 { bits-- }
+      indices2[i] = r.read(bits)
+    }
+  }
+
+  for i := 0; i < 16; i++ {
+    e0, e1 := endpoints[subsets[i] * 2], endpoints[subsets[i] * 2 + 1]
+    colorIndex, colorWeights := indices[i], bc7Weights[indexBits]
+    alphaIndex, alphaWeights := indices[i], bc7Weights[indexBits]
+    if indexBits2 > 0 {
+      alphaIndex, alphaWeights = indices2[i], bc7Weights[indexBits2]
+      if indexSelection != 0 {
+        colorIndex, alphaIndex = alphaIndex, colorIndex
+        colorWeights, alphaWeights = alphaWeights, colorWeights
+      }
+    }
+    pixel := pixels[i*4:i*4+4]
+    for c := 0; c < 3; c++ {
+      w := colorWeights[colorIndex]
+      pixel[c] = uint8(((64 - w) * e0[c] + w * e1[c] + 32) >> 6)
+    }
+    w := alphaWeights[alphaIndex]
+    pixel[3] = uint8(((64 - w) * e0[3] + w * e1[3] + 32) >> 6)
+    if rotation > 0 { pixel[rotation - 1], pixel[3] = pixel[3], pixel[rotation - 1] }
+  }
+}
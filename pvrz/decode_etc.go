@@ -0,0 +1,206 @@
+package pvrz
+
+var (
+  // ETC1/ETC2 intensity modifiers of individual and differential mode
+  etcModifiers = [8][2]int{
+    { 2, 8 }, { 5, 17 }, { 9, 29 }, { 13, 42 }, { 18, 60 }, { 24, 80 }, { 33, 106 }, { 47, 183 },
+  }
+
+  // ETC2 distances of T and H mode
+  etcDistances = [8]int{ 3, 6, 11, 16, 23, 32, 41, 64 }
+
+  // EAC alpha modifiers
+  eacModifiers = [16][8]int{
+    { -3, -6, -9, -15, 2, 5, 8, 14 },
+    { -3, -7, -10, -13, 2, 6, 9, 12 },
+    { -2, -5, -8, -13, 1, 4, 7, 12 },
+    { -2, -4, -6, -13, 1, 3, 5, 12 },
+    { -3, -6, -8, -12, 2, 5, 7, 11 },
+    { -3, -7, -9, -11, 2, 6, 8, 10 },
+    { -4, -7, -8, -11, 3, 6, 7, 10 },
+    { -3, -5, -8, -11, 2, 4, 7, 10 },
+    { -2, -6, -8, -10, 1, 5, 7, 9 },
+    { -2, -5, -8, -10, 1, 4, 7, 9 },
+    { -2, -4, -8, -10, 1, 3, 7, 9 },
+    { -2, -5, -7, -10, 1, 4, 6, 9 },
+    { -3, -4, -7, -10, 2, 3, 6, 9 },
+    { -1, -2, -3, -10, 0, 1, 2, 9 },
+    { -4, -6, -8, -9, 3, 5, 7, 8 },
+    { -3, -5, -7, -9, 2, 4, 6, 8 },
+  }
+)
+
+
+// Used internally. Decodes an ETC1 block.
+func decodeETC1(block []byte, pixels []uint8) {
+  hi, lo := etcWords(block)
+  etcDecodeIndividual(hi, lo, hi & 2 != 0, false, pixels)
+}
+
+// Used internally. Decodes an ETC2 RGB block.
+func decodeETC2(block []byte, pixels []uint8) {
+  decodeETC2Color(block, pixels, false)
+}
+
+// Used internally. Decodes an ETC2 RGB block with punchthrough alpha.
+func decodeETC2A1(block []byte, pixels []uint8) {
+  decodeETC2Color(block, pixels, true)
+}
+
+// Used internally. Decodes an ETC2 RGBA block, consisting of an EAC alpha block and an ETC2 RGB block.
+func decodeETC2RGBA(block []byte, pixels []uint8) {
+  decodeETC2Color(block[8:16], pixels, false)
+
+  base, mul, table := int(block[0]), int(block[1] >> 4), int(block[1] & 15)
+  bits := uint64(0)
+  for i := 2; i < 8; i++ { bits = (bits << 8) | uint64(block[i]) }
+  for i := uint(0); i < 16; i++ {
+    idx := (bits >> (45 - i * 3)) & 7
+    x, y := i >> 2, i & 3
+    pixels[(y * 4 + x) * 4 + 3] = clampByte(base + eacModifiers[table][idx] * mul)
+  }
+}
+
+// Used internally. Decodes an ETC2 RGB block with optional punchthrough alpha.
+func decodeETC2Color(block []byte, pixels []uint8, punchthrough bool) {
+  hi, lo := etcWords(block)
+  diff := hi & 2 != 0
+  opaque := !punchthrough || diff
+  if !punchthrough && !diff {
+    etcDecodeIndividual(hi, lo, false, false, pixels)
+    return
+  }
+
+  // overflow of a differential base color selects one of the additional modes
+  r, g, b := int(hi >> 27), int((hi >> 19) & 31), int((hi >> 11) & 31)
+  dr, dg, db := signExtend3(hi >> 24), signExtend3(hi >> 16), signExtend3(hi >> 8)
+  switch {
+    case r + dr < 0 || r + dr > 31: etcDecodeT(hi, lo, opaque, pixels)
+    case g + dg < 0 || g + dg > 31: etcDecodeH(hi, lo, opaque, pixels)
+    case b + db < 0 || b + db > 31: etcDecodePlanar(hi, lo, pixels)
+    default:                        etcDecodeIndividual(hi, lo, true, !opaque, pixels)
+  }
+}
+
+// Used internally. Returns the high and low 32-bit words of a big-endian ETC block.
+func etcWords(block []byte) (hi, lo uint32) {
+  hi = uint32(block[0]) << 24 | uint32(block[1]) << 16 | uint32(block[2]) << 8 | uint32(block[3])
+  lo = uint32(block[4]) << 24 | uint32(block[5]) << 16 | uint32(block[6]) << 8 | uint32(block[7])
+  return
+}
+
+// Used internally. Returns the 2-bit pixel index of the pixel at (x, y) of an ETC block.
+func etcIndex(lo uint32, x, y int) int {
+  i := uint(x * 4 + y)
+  return int((lo >> (i + 15)) & 2) | int((lo >> i) & 1)
+}
+
+// Used internally. Decodes an ETC block of individual or differential mode. "transparent" enables the punchthrough
+// alpha interpretation of pixel indices.
+func etcDecodeIndividual(hi, lo uint32, diff, transparent bool, pixels []uint8) {
+  var base [2][3]int
+  if diff {
+    for c := uint(0); c < 3; c++ {
+      v := int((hi >> (27 - c * 8)) & 31)
+      v2 := v + signExtend3(hi >> (24 - c * 8))
+      base[0][c] = (v << 3) | (v >> 2)
+      base[1][c] = ((v2 & 31) << 3) | ((v2 & 31) >> 2)
+    }
+  } else {
+    for c := uint(0); c < 3; c++ {
+      base[0][c] = int((hi >> (28 - c * 8)) & 15) * 17
+      base[1][c] = int((hi >> (24 - c * 8)) & 15) * 17
+    }
+  }
+  tables := [2]int{ int((hi >> 5) & 7), int((hi >> 2) & 7) }
+  flip := hi & 1 != 0
+
+  for y := 0; y < 4; y++ {
+    for x := 0; x < 4; x++ {
+      sub := 0
+      if (flip && y >= 2) || (!flip && x >= 2) { sub = 1 }
+      idx := etcIndex(lo, x, y)
+      pixel := pixels[(y * 4 + x) * 4:(y * 4 + x) * 4 + 4]
+      if transparent && idx == 2 {
+        pixel[0], pixel[1], pixel[2], pixel[3] = 0, 0, 0, 0
+        continue
+      }
+      mod := etcModifiers[tables[sub]][idx & 1]
+      if transparent && idx == 0 { mod = 0 }
+      if idx & 2 != 0 { mod = -mod }
+      for c := 0; c < 3; c++ { pixel[c] = clampByte(base[sub][c] + mod) }
+      pixel[3] = 255
+    }
+  }
+}
+
+// Used internally. Decodes an ETC2 block of T mode.
+func etcDecodeT(hi, lo uint32, opaque bool, pixels []uint8) {
+  c1 := [3]int{ int(((hi >> 25) & 12) | ((hi >> 24) & 3)) * 17, int((hi >> 20) & 15) * 17, int((hi >> 16) & 15) * 17 }
+  c2 := [3]int{ int((hi >> 12) & 15) * 17, int((hi >> 8) & 15) * 17, int((hi >> 4) & 15) * 17 }
+  d := etcDistances[((hi >> 1) & 6) | (hi & 1)]
+  var paint [4][3]int
+  for c := 0; c < 3; c++ {
+    paint[0][c], paint[1][c], paint[2][c], paint[3][c] = c1[c], c2[c] + d, c2[c], c2[c] - d
+  }
+  etcPaint(lo, paint, opaque, pixels)
+}
+
+// Used internally. Decodes an ETC2 block of H mode.
+func etcDecodeH(hi, lo uint32, opaque bool, pixels []uint8) {
+  r1, g1, b1 := int((hi >> 27) & 15), int(((hi >> 23) & 14) | ((hi >> 20) & 1)), int(((hi >> 16) & 8) | ((hi >> 15) & 7))
+  r2, g2, b2 := int((hi >> 11) & 15), int((hi >> 7) & 15), int((hi >> 3) & 15)
+  idx := int(hi & 4) | int((hi << 1) & 2)
+  if (r1 << 8 | g1 << 4 | b1) >= (r2 << 8 | g2 << 4 | b2) { idx |= 1 }
+  d := etcDistances[idx]
+  c1, c2 := [3]int{ r1 * 17, g1 * 17, b1 * 17 }, [3]int{ r2 * 17, g2 * 17, b2 * 17 }
+  var paint [4][3]int
+  for c := 0; c < 3; c++ {
+    paint[0][c], paint[1][c], paint[2][c], paint[3][c] = c1[c] + d, c1[c] - d, c2[c] + d, c2[c] - d
+  }
+  etcPaint(lo, paint, opaque, pixels)
+}
+
+// Used internally. Assigns paint colors of T and H mode to the pixels of a block. Paint color 2 is transparent if
+// "opaque" is not set.
+func etcPaint(lo uint32, paint [4][3]int, opaque bool, pixels []uint8) {
+  for y := 0; y < 4; y++ {
+    for x := 0; x < 4; x++ {
+      idx := etcIndex(lo, x, y)
+      pixel := pixels[(y * 4 + x) * 4:(y * 4 + x) * 4 + 4]
+      if !opaque && idx == 2 {
+        pixel[0], pixel[1], pixel[2], pixel[3] = 0, 0, 0, 0
+        continue
+      }
+      for c := 0; c < 3; c++ { pixel[c] = clampByte(paint[idx][c]) }
+      pixel[3] = 255
+    }
+  }
+}
+
+// Used internally. Decodes an ETC2 block of planar mode.
+func etcDecodePlanar(hi, lo uint32, pixels []uint8) {
+  expand6 := func(v uint32) int { return int((v << 2) | (v >> 4)) }
+  expand7 := func(v uint32) int { return int((v << 1) | (v >> 6)) }
+  o := [3]int{
+    expand6((hi >> 25) & 63),
+    expand7(((hi >> 18) & 64) | ((hi >> 17) & 63)),
+    expand6(((hi >> 11) & 32) | ((hi >> 8) & 24) | ((hi >> 7) & 7)),
+  }
+  h := [3]int{ expand6(((hi >> 1) & 62) | (hi & 1)), expand7((lo >> 25) & 127), expand6((lo >> 19) & 63) }
+  v := [3]int{ expand6((lo >> 13) & 63), expand7((lo >> 6) & 127), expand6(lo & 63) }
+  for y := 0; y < 4; y++ {
+    for x := 0; x < 4; x++ {
+      pixel := pixels[(y * 4 + x) * 4:(y * 4 + x) * 4 + 4]
+      for c := 0; c < 3; c++ { pixel[c] = clampByte((x * (h[c] - o[c]) + y * (v[c] - o[c]) + 4 * o[c] + 2) >> 2) }
+      pixel[3] = 255
+    }
+  }
+}
+
+// Used internally. Returns the lowest 3 bits of "value" as signed integer.
+func signExtend3(value uint32) int {
+  v := int(value & 7)
+  if v >= 4 { v -= 8 }
+  return v
+}
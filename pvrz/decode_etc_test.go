@@ -0,0 +1,53 @@
+package pvrz
+
+import (
+  "encoding/hex"
+  "testing"
+)
+
+// Expected RGBA output of ETC2 blocks, calculated from the bit layouts of the ETC2 specification.
+var etc2Blocks = []struct {
+  name          string
+  block         string
+  punchthrough  bool
+  pixels        string
+}{
+  { "individual", "a35cf1a95a3c96e1", false,
+    "faa5ffff923de7ffc26dffff5a05afffc26dffff5a05afff5a05afffc26dffff" +
+    "2ac308ff50e92eff50e92eff2ac308ff2ac308ff50e92eff2ac308ff50e92eff" },
+  { "differential", "a52affe2c3a50f69", false,
+    "000048ffd458ffff8431efff8e3bf9ffd458ffff000048ff8431efff8e3bf9ff" +
+    "7600d0ffffe0ffff9441ffff8a37f5ffffe0ffff7600d0ff9441ffff8a37f5ff" },
+  { "T", "f369c27b1e87b42d", false,
+    "ac0257ffbb6699ffbb6699ffac0257ffcc2277ffec4297ffcc2277ffec4297ff" +
+    "ac0257ffbb6699ffac0257ffbb6699ffec4297ffcc2277ffcc2277ffec4297ff" },
+  { "T red", "0400000200000000", false,
+    "000000ff000000ff000000ff000000ff000000ff000000ff000000ff000000ff" +
+    "000000ff000000ff000000ff000000ff000000ff000000ff000000ff000000ff" },
+  { "H", "35f314ee69c35af0", false,
+    "42b9fdff469b46ff42b9fdff469b46ff42b9fdff469b46ff469b46ff42b9fdff" +
+    "86db86ff0279bdff86db86ff0279bdff86db86ff0279bdff0279bdff86db86ff" },
+  { "planar", "5935ebce8d37a25c", false,
+    "b2b5beffacabb5ffa6a1acffa097a3ffc38cabffbd82a2ffb77899ffb16e90ff" +
+    "d56498ffcf5a8fffc95086ffc3467dffe63b84ffe0317bffda2772ffd41d69ff" },
+  { "punchthrough differential", "a52affe0c3a50f69", true,
+    "000048ffa529ffff8431efff8c39f7ffa529ffff000048ff8431efff8c39f7ff" +
+    "00000000ffe0ffff9441ffff00000000ffe0ffff000000009441ffff00000000" },
+  { "punchthrough T", "f369c2791e87b42d", true,
+    "ac0257ffbb6699ffbb6699ffac0257ff00000000ec4297ff00000000ec4297ff" +
+    "ac0257ffbb6699ffac0257ffbb6699ffec4297ff0000000000000000ec4297ff" },
+}
+
+
+func TestDecodeETC2(t *testing.T) {
+  for _, tc := range etc2Blocks {
+    block, _ := hex.DecodeString(tc.block)
+    pixels := make([]uint8, 64)
+    if tc.punchthrough {
+      decodeETC2A1(block, pixels)
+    } else {
+      decodeETC2(block, pixels)
+    }
+    if s := hex.EncodeToString(pixels); s != tc.pixels { t.Errorf("%s: pixels = %s", tc.name, s) }
+  }
+}
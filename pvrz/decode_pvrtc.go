@@ -0,0 +1,175 @@
+package pvrz
+
+import (
+  "image"
+  "image/draw"
+)
+
+var (
+  pvrtcWeights             = [4]int{ 0, 3, 5, 8 }   // modulation weights of standard mode
+  pvrtcPunchthroughWeights = [4]int{ 0, 4, 4, 8 }   // modulation weights of 4bpp punchthrough mode
+)
+
+
+// Used internally. Decodes PVRTC 2bpp or 4bpp texture data of the given number of blocks into a 32-bit image with
+// non-premultiplied alpha.
+//
+// Blocks are stored in twiddled (Morton) order. Colors A and B of each block are bilinearly upscaled across
+// neighboring blocks, which wrap around at the texture edges, and blended per pixel by the modulation data.
+func decodePVRTC(data []byte, bx, by int, is2bpp bool) draw.Image {
+  bw, bh := 4, 4
+  if is2bpp { bw = 8 }
+  width, height := bx * bw, by * bh
+
+  colorA := make([][4]int, bx * by)
+  colorB := make([][4]int, bx * by)
+  weights := make([]int, width * height)    // modulation weights (0-8) of stored pixels
+  modes := make([]int, width * height)      // 0: stored, 1: average of 4 neighbors, 2: horizontal, 3: vertical
+  punch := make([]bool, width * height)     // transparent pixels of punchthrough mode
+
+  for y := 0; y < by; y++ {
+    for x := 0; x < bx; x++ {
+      ofs := pvrtcTwiddle(x, y, bx, by) * 8
+      mod := uint32(data[ofs]) | uint32(data[ofs+1]) << 8 | uint32(data[ofs+2]) << 16 | uint32(data[ofs+3]) << 24
+      col := uint32(data[ofs+4]) | uint32(data[ofs+5]) << 8 | uint32(data[ofs+6]) << 16 | uint32(data[ofs+7]) << 24
+      colorA[y * bx + x], colorB[y * bx + x] = pvrtcColorA(col), pvrtcColorB(col)
+
+      flag := col & 1 != 0
+      for py := 0; py < bh; py++ {
+        for px := 0; px < bw; px++ {
+          idx := (y * bh + py) * width + x * bw + px
+          switch {
+            case !is2bpp && flag:
+              v := (mod >> uint((py * 4 + px) * 2)) & 3
+              weights[idx], punch[idx] = pvrtcPunchthroughWeights[v], v == 2
+            case !is2bpp:
+              weights[idx] = pvrtcWeights[(mod >> uint((py * 4 + px) * 2)) & 3]
+            case !flag:
+              weights[idx] = int((mod >> uint(py * 8 + px)) & 1) * 8
+          }
+        }
+      }
+
+      if is2bpp && flag {
+        // interpolated modulation: only pixels of a checkerboard pattern are stored
+        mode := 1
+        if mod & 1 != 0 {
+          if mod & (1 << 20) != 0 { mode = 3 } else { mode = 2 }
+          if mod & (1 << 21) != 0 { mod |= 1 << 20 } else { mod &= ^uint32(1 << 20) }
+        }
+        if mod & 2 != 0 { mod |= 1 } else { mod &= ^uint32(1) }
+        for py := 0; py < bh; py++ {
+          for px := 0; px < bw; px++ {
+            idx := (y * bh + py) * width + x * bw + px
+            if (px ^ py) & 1 == 0 {
+              weights[idx] = pvrtcWeights[mod & 3]
+              mod >>= 2
+            } else {
+              modes[idx] = mode
+            }
+          }
+        }
+      }
+    }
+  }
+
+  img := image.NewNRGBA(image.Rect(0, 0, width, height))
+  for y := 0; y < height; y++ {
+    for x := 0; x < width; x++ {
+      w := pvrtcWeight(weights, modes, x, y, width, height)
+      a := pvrtcInterpolate(colorA, x, y, bx, by, bw, bh)
+      b := pvrtcInterpolate(colorB, x, y, bx, by, bw, bh)
+      pixel := img.Pix[img.PixOffset(x, y):img.PixOffset(x, y) + 4]
+      for c := 0; c < 4; c++ { pixel[c] = uint8((a[c] * (8 - w) + b[c] * w + 4) >> 3) }
+      if punch[y * width + x] { pixel[3] = 0 }
+    }
+  }
+  return img
+}
+
+// Used internally. Returns the modulation weight of the pixel at (x, y), averaging the neighbors of pixels which are
+// not stored explicitly.
+func pvrtcWeight(weights, modes []int, x, y, width, height int) int {
+  at := func(x, y int) int { return weights[((y + height) % height) * width + (x + width) % width] }
+  switch modes[y * width + x] {
+    case 1:  return (at(x - 1, y) + at(x + 1, y) + at(x, y - 1) + at(x, y + 1) + 2) / 4
+    case 2:  return (at(x - 1, y) + at(x + 1, y) + 1) / 2
+    case 3:  return (at(x, y - 1) + at(x, y + 1) + 1) / 2
+    default: return weights[y * width + x]
+  }
+}
+
+// Used internally. Returns the bilinearly interpolated block color at the pixel (x, y). Block colors are located at
+// the block centers.
+func pvrtcInterpolate(colors [][4]int, x, y, bx, by, bw, bh int) [4]int {
+  u, v := x - bw / 2, y - bh / 2
+  x0, y0 := floorDiv(u, bw), floorDiv(v, bh)
+  fx, fy := u - x0 * bw, v - y0 * bh
+  x1, y1 := (x0 + 1 + bx) % bx, (y0 + 1 + by) % by
+  x0, y0 = (x0 + bx) % bx, (y0 + by) % by
+
+  p, q, r, s := colors[y0 * bx + x0], colors[y0 * bx + x1], colors[y1 * bx + x0], colors[y1 * bx + x1]
+  var out [4]int
+  total := bw * bh
+  for c := 0; c < 4; c++ {
+    sum := p[c] * (bw - fx) * (bh - fy) + q[c] * fx * (bh - fy) + r[c] * (bw - fx) * fy + s[c] * fx * fy
+    out[c] = (sum + total / 2) / total
+  }
+  return out
+}
+
+// Used internally. Returns color A of the given PVRTC color word as 8-bit RGBA values. 3-bit alpha is stored as the
+// upper bits of a 4-bit value.
+func pvrtcColorA(col uint32) [4]int {
+  if col & 0x8000 != 0 {
+    return [4]int{ expand5(col >> 10), expand5(col >> 5), expand4(col >> 1), 255 }
+  }
+  return [4]int{ expand4(col >> 8), expand4(col >> 4), expand3(col >> 1), expand4((col >> 11) & 14) }
+}
+
+// Used internally. Returns color B of the given PVRTC color word as 8-bit RGBA values.
+func pvrtcColorB(col uint32) [4]int {
+  if col & 0x80000000 != 0 {
+    return [4]int{ expand5(col >> 26), expand5(col >> 21), expand5(col >> 16), 255 }
+  }
+  return [4]int{ expand4(col >> 24), expand4(col >> 20), expand4(col >> 16), expand4((col >> 27) & 14) }
+}
+
+// Used internally. Returns the offset of the block (x, y) in twiddled order. Bits of the y coordinate occupy the
+// lower bit of each pair. Remaining bits of the larger dimension are appended.
+func pvrtcTwiddle(x, y, bx, by int) int {
+  min := bx
+  if by < min { min = by }
+  value, shift := 0, uint(0)
+  for bit := 1; bit < min; bit <<= 1 {
+    if y & bit != 0 { value |= 1 << (shift * 2) }
+    if x & bit != 0 { value |= 2 << (shift * 2) }
+    shift++
+  }
+  rest := y
+  if bx > by { rest = x }
+  return value | ((rest >> shift) << (shift * 2))
+}
+
+// Used internally. Division rounding towards negative infinity.
+func floorDiv(a, b int) int {
+  if a < 0 { return -((b - 1 - a) / b) }
+  return a / b
+}
+
+// Used internally. Expands the lowest 3 bits of "value" to 8 bits.
+func expand3(value uint32) int {
+  v := int(value & 7)
+  return (v << 5) | (v << 2) | (v >> 1)
+}
+
+// Used internally. Expands the lowest 4 bits of "value" to 8 bits.
+func expand4(value uint32) int {
+  return int(value & 15) * 17
+}
+
+// Used internally. Expands the lowest 5 bits of "value" to 8 bits.
+func expand5(value uint32) int {
+  v := int(value & 31)
+  return (v << 3) | (v >> 2)
+}
@@ -0,0 +1,255 @@
+package pvrz
+
+import (
+  "image"
+  "image/draw"
+  "math"
+)
+
+const (
+  // Available mipmap filter types
+  FILTER_BOX          = 0   // Average of 2x2 pixels of the preceding level (default)
+  FILTER_LANCZOS      = 1   // Lanczos (a=3) resampling of the base image, slower but sharper
+)
+
+
+// GetMipMapCount returns the number of mipmap levels written by the Save() function, including the base image.
+func (p *Pvr) GetMipMapCount() int {
+  if p.err != nil { return 0 }
+  return mipMapCount(p.info.numMipMaps, p.info.width, p.info.height)
+}
+
+
+// SetMipMapCount defines the number of mipmap levels written by the Save() function, including the base image.
+// Specify 1 to write the base image only, or 0 to write the full chain down to a dimension of 1x1 pixels.
+// The number is limited by the texture dimension. Levels which have not been loaded or assigned are generated from the
+// base image on save, using the filter defined by SetMipMapFilter().
+func (p *Pvr) SetMipMapCount(count int) {
+  if p.err != nil { return }
+  if count < 0 { p.err = ErrIllegalArguments; return }
+
+  if count == 0 { count = maxMipMapCount(p.info.width, p.info.height) }
+  p.info.numMipMaps = mipMapCount(count, p.info.width, p.info.height)
+  if len(p.mipmaps) > p.info.numMipMaps - 1 { p.mipmaps = p.mipmaps[:p.info.numMipMaps - 1] }
+}
+
+
+// GetMipMapFilter returns the filter used to generate mipmap levels (see FILTER_xxx constants).
+func (p *Pvr) GetMipMapFilter() int {
+  if p.err != nil { return 0 }
+  return p.mipFilter
+}
+
+
+// SetMipMapFilter defines the filter used to generate mipmap levels. Use one of the FILTER_xxx constants.
+// Levels that have already been generated are not affected. Use GenerateMipMaps() to regenerate them.
+func (p *Pvr) SetMipMapFilter(filter int) {
+  if p.err != nil { return }
+  if filter != FILTER_BOX && filter != FILTER_LANCZOS { p.err = ErrIllegalArguments; return }
+  p.mipFilter = filter
+}
+
+
+// GetMipMapSize returns the dimension of the specified mipmap level. Level 0 refers to the base image.
+func (p *Pvr) GetMipMapSize(level int) (width, height int) {
+  if p.err != nil { return 0, 0 }
+  return mipMapSize(p.info.width, p.info.height, level)
+}
+
+
+// GetMipMap returns a copy of the specified mipmap level. Level 0 refers to the base image.
+// Levels which have not been loaded or assigned are generated from the base image.
+func (p *Pvr) GetMipMap(level int) image.Image {
+  if p.err != nil { return nil }
+  if level < 0 || level >= p.GetMipMapCount() { p.err = ErrIllegalArguments; return nil }
+  if level == 0 { return p.GetImage() }
+
+  img := p.mipMap(level)
+  imgOut := image.NewRGBA(img.Bounds())
+  draw.Draw(imgOut, imgOut.Bounds(), img, img.Bounds().Min, draw.Src)
+  return imgOut
+}
+
+
+// SetMipMap replaces the content of the specified mipmap level by the given image. Level 0 refers to the base image.
+// Image dimension must match the dimension of the mipmap level (see GetMipMapSize()).
+//
+// Note: Assigned levels are discarded when the base image is modified.
+func (p *Pvr) SetMipMap(level int, img image.Image) {
+  if p.err != nil { return }
+  if level < 0 || level >= p.GetMipMapCount() || img == nil { p.err = ErrIllegalArguments; return }
+  if level == 0 { p.SetImage(img); return }
+
+  width, height := mipMapSize(p.info.width, p.info.height, level)
+  if img.Bounds().Dx() != width || img.Bounds().Dy() != height { p.err = ErrIllegalArguments; return }
+  imgOut := image.NewRGBA(image.Rect(0, 0, width, height))
+  draw.Draw(imgOut, imgOut.Bounds(), img, img.Bounds().Min, draw.Src)
+  for len(p.mipmaps) < level { p.mipmaps = append(p.mipmaps, nil) }
+  p.mipmaps[level - 1] = imgOut
+}
+
+
+// GenerateMipMaps discards all loaded or assigned mipmap levels and generates them from the base image, using the
+// filter defined by SetMipMapFilter().
+func (p *Pvr) GenerateMipMaps() {
+  if p.err != nil { return }
+
+  p.mipmaps = nil
+  for level := 1; level < p.GetMipMapCount(); level++ { p.mipMap(level) }
+}
+
+
+// Used internally. Returns the specified mipmap level, level 0 refers to the base image. Missing levels up to the
+// specified level are generated on demand.
+func (p *Pvr) mipMap(level int) draw.Image {
+  if level <= 0 { return p.img }
+  for len(p.mipmaps) < level { p.mipmaps = append(p.mipmaps, nil) }
+  if p.mipmaps[level - 1] == nil {
+    width, height := mipMapSize(p.info.width, p.info.height, level)
+    if p.mipFilter == FILTER_LANCZOS {
+      p.mipmaps[level - 1] = resampleLanczos(p.img, width, height)
+    } else {
+      p.mipmaps[level - 1] = resampleBox(p.mipMap(level - 1), width, height)
+    }
+  }
+  return p.mipmaps[level - 1]
+}
+
+// Used internally. Discards all mipmap levels, which causes them to be regenerated from the base image when needed.
+func (p *Pvr) discardMipMaps() {
+  p.mipmaps = nil
+  p.info.numMipMaps = mipMapCount(p.info.numMipMaps, p.info.width, p.info.height)
+}
+
+// Used internally. Returns the number of mipmap levels of a chain down to a dimension of 1x1 pixels.
+func maxMipMapCount(width, height int) int {
+  count := 1
+  for width > 1 || height > 1 {
+    width, height = width >> 1, height >> 1
+    count++
+  }
+  return count
+}
+
+// Used internally. Returns "count" limited to the valid range of mipmap levels for the given dimension.
+func mipMapCount(count, width, height int) int {
+  if count < 1 { return 1 }
+  if max := maxMipMapCount(width, height); count > max { return max }
+  return count
+}
+
+// Used internally. Returns the dimension of the specified mipmap level.
+func mipMapSize(width, height, level int) (int, int) {
+  width, height = width >> uint(level), height >> uint(level)
+  if width < 1 { width = 1 }
+  if height < 1 { height = 1 }
+  return width, height
+}
+
+// Used internally. Scales the given image down to the specified dimension by averaging blocks of 2x2 pixels.
+// Pixels of odd source dimensions are clamped to the image bounds.
+func resampleBox(img draw.Image, width, height int) draw.Image {
+  src := toRGBA(img)
+  srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+  imgOut := image.NewRGBA(image.Rect(0, 0, width, height))
+  for y := 0; y < height; y++ {
+    y0, y1 := clampInt(y * 2, srcHeight - 1), clampInt(y * 2 + 1, srcHeight - 1)
+    for x := 0; x < width; x++ {
+      x0, x1 := clampInt(x * 2, srcWidth - 1), clampInt(x * 2 + 1, srcWidth - 1)
+      dst := imgOut.PixOffset(x, y)
+      for c := 0; c < 4; c++ {
+        sum := int(src.Pix[src.PixOffset(x0, y0) + c]) + int(src.Pix[src.PixOffset(x1, y0) + c]) +
+               int(src.Pix[src.PixOffset(x0, y1) + c]) + int(src.Pix[src.PixOffset(x1, y1) + c])
+        imgOut.Pix[dst + c] = uint8((sum + 2) >> 2)
+      }
+    }
+  }
+  return imgOut
+}
+
+// Used internally. Scales the given image to the specified dimension by separable Lanczos (a=3) resampling.
+// Pixel data is filtered in premultiplied alpha space.
+func resampleLanczos(img draw.Image, width, height int) draw.Image {
+  src := toRGBA(img)
+  srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+
+  // horizontal pass
+  tmp := make([]float64, width * srcHeight * 4)
+  for x := 0; x < width; x++ {
+    start, weights := lanczosWeights(x, width, srcWidth)
+    for y := 0; y < srcHeight; y++ {
+      ofs := (y * width + x) * 4
+      for i, w := range weights {
+        si := src.PixOffset(clampInt(start + i, srcWidth - 1), y)
+        for c := 0; c < 4; c++ { tmp[ofs + c] += w * float64(src.Pix[si + c]) }
+      }
+    }
+  }
+
+  // vertical pass
+  imgOut := image.NewRGBA(image.Rect(0, 0, width, height))
+  for y := 0; y < height; y++ {
+    start, weights := lanczosWeights(y, height, srcHeight)
+    for x := 0; x < width; x++ {
+      var sum [4]float64
+      for i, w := range weights {
+        ofs := (clampInt(start + i, srcHeight - 1) * width + x) * 4
+        for c := 0; c < 4; c++ { sum[c] += w * tmp[ofs + c] }
+      }
+      // premultiplied color components must not exceed alpha
+      alpha := clampInt(int(math.Floor(sum[3] + 0.5)), 255)
+      dst := imgOut.PixOffset(x, y)
+      for c := 0; c < 3; c++ { imgOut.Pix[dst + c] = uint8(clampInt(int(math.Floor(sum[c] + 0.5)), alpha)) }
+      imgOut.Pix[dst + 3] = uint8(alpha)
+    }
+  }
+  return imgOut
+}
+
+// Used internally. Returns the first source index and the normalized Lanczos weights contributing to the destination
+// index "dst". Source indices may exceed the valid range and have to be clamped by the caller.
+func lanczosWeights(dst, dstSize, srcSize int) (int, []float64) {
+  const a = 3.0
+  scale := float64(srcSize) / float64(dstSize)
+  support := a
+  if scale > 1.0 { support *= scale }
+  center := (float64(dst) + 0.5) * scale
+  start := int(math.Floor(center - support + 0.5))
+  end := int(math.Floor(center + support + 0.5))
+
+  weights := make([]float64, end - start)
+  total := 0.0
+  for i := range weights {
+    x := (float64(start + i) + 0.5 - center)
+    if scale > 1.0 { x /= scale }
+    weights[i] = lanczos(x, a)
+    total += weights[i]
+  }
+  if total != 0.0 {
+    for i := range weights { weights[i] /= total }
+  }
+  return start, weights
+}
+
+// Used internally. The Lanczos kernel of size "a".
+func lanczos(x, a float64) float64 {
+  if x == 0.0 { return 1.0 }
+  if x <= -a || x >= a { return 0.0 }
+  px := math.Pi * x
+  return a * math.Sin(px) * math.Sin(px / a) / (px * px)
+}
+
+// Used internally. Returns the given image as RGBA image with origin at (0, 0).
+func toRGBA(img image.Image) *image.RGBA {
+  if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == image.ZP { return rgba }
+  imgOut := image.NewRGBA(image.Rectangle{image.ZP, img.Bounds().Size()})
+  draw.Draw(imgOut, imgOut.Bounds(), img, img.Bounds().Min, draw.Src)
+  return imgOut
+}
+
+// Used internally. Returns "value" clamped to the range [0, max].
+func clampInt(value, max int) int {
+  if value < 0 { return 0 }
+  if value > max { return max }
+  return value
+}
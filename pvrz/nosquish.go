@@ -0,0 +1,22 @@
+// +build nosquish
+
+package pvrz
+
+import (
+  "image"
+  "image/draw"
+)
+
+// Whether the squish backend is available. Build without tag "nosquish" to enable it.
+const squishAvailable = false
+
+
+// Used internally. Placeholder for builds without squish backend. Always returns nil.
+func decodeSquish(data []byte, width, height, pixelType int) draw.Image {
+  return nil
+}
+
+// Used internally. Placeholder for builds without squish backend. Always returns nil.
+func encodeSquish(img *image.NRGBA, pixelType, quality int, weightByAlpha, useMetric bool) []byte {
+  return nil
+}
@@ -1,6 +1,10 @@
 /*
 Package pvrz provides functionality to deal with data of the PVR and PVRZ formats.
 It has been optimized for use in Enhanced Edition games based on the Infinity Engine.
+
+BC1, BC2 and BC3 pixel data is encoded and decoded by the squish library by default. A pure-Go backend can be
+selected by SetBackend() or SetDefaultBackend(). Build with tag "nosquish" to remove the squish library and its cgo
+dependency from the package, in which case the pure-Go backend is used exclusively.
 */
 package pvrz
 
@@ -11,8 +15,9 @@ import (
   "image/color"
   "image/draw"
   "io"
+  "runtime"
+  "sync"
 
-  "github.com/InfinityTools/go-squish"
   "github.com/InfinityTools/go-ietools/buffers"
 )
 
@@ -25,6 +30,21 @@ const (
   TYPE_BC2            = 9   // aka DXT3, full decoding and encoding support
   TYPE_BC3            = 11  // aka DXT5, full decoding and encoding support
 
+  // Supported compression types with decoding support only
+  TYPE_PVRTC_2BPP_RGB   = 0
+  TYPE_PVRTC_2BPP_RGBA  = 1
+  TYPE_PVRTC_4BPP_RGB   = 2
+  TYPE_PVRTC_4BPP_RGBA  = 3
+  TYPE_ETC1             = 6
+  TYPE_BC4              = 12  // single channel, decoded as red
+  TYPE_BC5              = 13  // two channels, decoded as red and green
+  TYPE_BC7              = 15
+  TYPE_ETC2_RGB         = 22
+  TYPE_ETC2_RGBA        = 23
+  TYPE_ETC2_RGB_A1      = 24  // RGB with punchthrough alpha
+
+  // Uncompressed types are defined by TYPE_RGBA8888, TYPE_BGRA8888, TYPE_RGB565, TYPE_RGBA4444 and TYPE_RGBA5551
+
   // Available color space constants
   SPACE_LRGB          = 0   // linear RGB space (default)
   SPACE_SRGB          = 1   // standard RGB space
@@ -50,11 +70,18 @@ const (
   QUALITY_DEFAULT     = 1   // Encode with a sensible quality/speed ratio
   QUALITY_HIGH        = 2   // Encoed with highest possiblle quality
 
+  // Available BC1/BC2/BC3 pixel encoding backends
+  BACKEND_SQUISH      = 0   // The squish library (default, requires cgo, not available with build tag "nosquish")
+  BACKEND_NATIVE      = 1   // Pure-Go implementation (default with build tag "nosquish")
+
   versionSig          = 0x03525650  // Internally used: the PVR signature
 )
 
 var ErrIllegalArguments = errors.New("Illegal arguments specified")
 
+// Backend assigned to new Pvr objects
+var defaultBackend = initialBackend()
+
 // Stores parsed PVR header information
 type pvrInfo struct {
   flags         int
@@ -74,11 +101,41 @@ type pvrInfo struct {
 type Pvr struct {
   info          pvrInfo
   img           draw.Image  // the uncompressed RGBA pixel data
+  mipmaps       []draw.Image  // uncompressed mipmap levels below the base image, nil entries are generated on demand
 
   err           error
   quality       int         // encoding quality setting (see QUALITY_xxx constants)
   weightByAlpha bool        // whether source uses weighted alpha (improves alpha-blended images)
   useMetric     bool        // whether to apply color weights to improve percepted quality
+  mipFilter     int         // filter used to generate mipmap levels (see FILTER_xxx constants)
+  backend       int         // BC1/BC2/BC3 encoding backend (see BACKEND_xxx constants)
+  concurrency   int         // max. number of concurrent encoding workers, 0 for GOMAXPROCS
+}
+
+
+// BackendAvailable returns whether the specified pixel encoding backend is available in this build.
+func BackendAvailable(backend int) bool {
+  switch backend {
+    case BACKEND_SQUISH: return squishAvailable
+    case BACKEND_NATIVE: return true
+    default:             return false
+  }
+}
+
+
+// GetDefaultBackend returns the pixel encoding backend assigned to new Pvr objects (see BACKEND_xxx constants).
+func GetDefaultBackend() int {
+  return defaultBackend
+}
+
+
+// SetDefaultBackend defines the pixel encoding backend assigned to new Pvr objects, including objects created by
+// Load(). Returns ErrIllegalArguments if the backend is not available. It is not safe to call this function
+// concurrently with other functions of this package.
+func SetDefaultBackend(backend int) error {
+  if !BackendAvailable(backend) { return ErrIllegalArguments }
+  defaultBackend = backend
+  return nil
 }
 
 
@@ -103,6 +160,9 @@ func CreateNew(width, height, pixelType int) *Pvr {
   p.quality = QUALITY_DEFAULT
   p.weightByAlpha = false
   p.useMetric = false
+  p.mipFilter = FILTER_BOX
+  p.backend = defaultBackend
+  p.concurrency = 0
 
   return &p
 }
@@ -171,6 +231,7 @@ func (p *Pvr) ClearError() {
 
 
 // SetImage replaces the current texture graphics with the specified image data.
+// Mipmap levels are discarded and regenerated from the new image when needed.
 //
 // Note: It is strongly recommended to use images with dimensions supported by the desired pixel encoding type.
 func (p *Pvr) SetImage(img image.Image) {
@@ -183,6 +244,7 @@ func (p *Pvr) SetImage(img image.Image) {
   p.info.width = width
   p.info.height = height
   p.img = imgOut
+  p.discardMipMaps()
 }
 
 
@@ -197,11 +259,13 @@ func (p *Pvr) GetImage() image.Image {
 
 
 // SetImageRect draws the content of "img" limited by the region "r" to the texture starting at position "dp".
+// Mipmap levels are discarded and regenerated from the new content when needed.
 func (p *Pvr) SetImageRect(img image.Image, r image.Rectangle, dp image.Point) {
   if p.err != nil { return }
 
   dr := image.Rectangle{dp, dp.Add(r.Size())}
   draw.Draw(p.img, dr, img, r.Min, draw.Src)
+  p.discardMipMaps()
 }
 
 
@@ -216,10 +280,12 @@ func (p *Pvr) GetImageRect(r image.Rectangle) image.Image {
 
 
 // FillImageRect fills the region "r" of the texture with the specified color.
+// Mipmap levels are discarded and regenerated from the new content when needed.
 func (p *Pvr) FillImageRect(r image.Rectangle, col color.Color) {
   if p.err != nil { return }
 
   draw.Draw(p.img, r, &image.Uniform{col}, image.ZP, draw.Src)
+  p.discardMipMaps()
 }
 
 
@@ -238,6 +304,7 @@ func (p *Pvr) GetHeight() int {
 
 
 // SetDimension can be used to resize the current pixel buffer. Specify "preserve" to preserve as much of old content if possible.
+// Mipmap levels are discarded and regenerated from the new content when needed.
 func (p *Pvr) SetDimension(width, height int, preserve bool) {
   if p.err != nil { return }
   if width == p.info.width && height == p.info.height && preserve { return }
@@ -249,7 +316,7 @@ func (p *Pvr) SetDimension(width, height int, preserve bool) {
   p.info.width = imgNew.Bounds().Dx()
   p.info.height = imgNew.Bounds().Dy()
   p.img = imgNew
-
+  p.discardMipMaps()
 }
 
 
@@ -260,9 +327,10 @@ func (p *Pvr) GetPixelType() int {
 
 
 // SetPixelType sets the pixel compression type that is applied when using the Save() function.
+// Pixel types with decoding support only are not accepted.
 func (p *Pvr) SetPixelType(pixelType int) {
   if p.err != nil { return }
-  if !pixelTypeSupported(pixelType) { p.err = ErrIllegalArguments; return }
+  if !pixelTypeEncodable(pixelType) { p.err = ErrIllegalArguments; return }
 
   p.info.pixelType = pixelType
 }
@@ -346,16 +414,67 @@ func (p *Pvr) SetPerceptiveMetric(set bool) {
 }
 
 
-// Used internally. Returns whether the specified pixel format is supported by this package.
+// GetBackend returns the backend used to encode and decode BC1, BC2 and BC3 pixel data (see BACKEND_xxx constants).
+func (p *Pvr) GetBackend() int {
+  if p.err != nil { return 0 }
+  return p.backend
+}
+
+
+// SetBackend defines the backend used to encode and decode BC1, BC2 and BC3 pixel data. Use one of the BACKEND_xxx
+// constants. Sets the error state if the backend is not available in this build.
+func (p *Pvr) SetBackend(backend int) {
+  if p.err != nil { return }
+  if !BackendAvailable(backend) { p.err = ErrIllegalArguments; return }
+  p.backend = backend
+}
+
+
+// GetConcurrency returns the maximum number of workers used to encode pixel data concurrently. 0 indicates that the
+// current GOMAXPROCS value is used.
+func (p *Pvr) GetConcurrency() int {
+  if p.err != nil { return 0 }
+  return p.concurrency
+}
+
+
+// SetConcurrency defines the maximum number of workers used to encode pixel data concurrently. Specify 0 to use the
+// current GOMAXPROCS value or 1 to encode sequentially. Encoded data does not depend on this setting.
+func (p *Pvr) SetConcurrency(workers int) {
+  if p.err != nil { return }
+  if workers < 0 { p.err = ErrIllegalArguments; return }
+  p.concurrency = workers
+}
+
+
+// Used internally. Returns the backend available by default.
+func initialBackend() int {
+  if squishAvailable { return BACKEND_SQUISH }
+  return BACKEND_NATIVE
+}
+
+
+// Used internally. Returns whether the specified pixel format can be decoded by this package.
 func pixelTypeSupported(value int) bool {
+  return isBlockCompressed(value) || isChannelFormat(value)
+}
+
+// Used internally. Returns whether the specified pixel format can be encoded by this package.
+func pixelTypeEncodable(value int) bool {
   switch value {
   case TYPE_BC1, TYPE_BC2, TYPE_BC3:
     return true
   default:
-    return false
+    return isChannelFormat(value)
   }
 }
 
+// Used internally. Returns whether the specified pixel format encodes pixels in blocks.
+func isBlockCompressed(value int) bool {
+  _, ok := blockFormats[value]
+  return ok
+}
+
 
 // Used internally. Imports PVR or PVRZ data from the specified byte array. The function attempts to determine right format automatically.
 func (p *Pvr) importPvr(data []byte) {
@@ -385,20 +504,20 @@ func (p *Pvr) importPvr(data []byte) {
   if sig != versionSig { p.err = fmt.Errorf("Invalid PVR header signature: %08x", sig); return }
   if buf.BufferLength() < 0x34 { p.err = fmt.Errorf("PVR input buffer too small"); return }
   flags := int(buf.GetInt32(0x04))
-  pf := int(buf.GetInt32(0x0c))
-  if pf != 0 { p.err = fmt.Errorf("Extended pixel format not supported"); return }
-  pixelType := int(buf.GetInt32(0x08))
-  if !pixelTypeSupported(pixelType) { p.err = fmt.Errorf("Unsupported pixel format: %d", pixelType); return }
+  pfLo, pfHi := buf.GetUint32(0x08), buf.GetUint32(0x0c)
+  pixelType := pixelTypeOf(pfLo, pfHi)
+  if pixelType < 0 { p.err = fmt.Errorf("Unsupported pixel format: %s", pixelFormatString(pfLo, pfHi)); return }
   colorSpace := int(buf.GetInt32(0x10))
   if colorSpace < 0 || colorSpace > 1 { p.err = fmt.Errorf("Unsupported color space: %d", colorSpace); return }
   channelType := int(buf.GetInt32(0x14))
   if channelType < CHAN_UBN || channelType > CHAN_SB { p.err = fmt.Errorf("Unsupported channel type: %d", channelType); return }
   height := int(buf.GetInt32(0x18))
   if height < 0 || height > 4096 { p.err = fmt.Errorf("Unsupported texture height: %d", height); return }
-  if (height & 3) != 0 { p.err = errors.New("Texture height must be a multiple of 4"); return }
   width := int(buf.GetInt32(0x1c))
   if width < 0 || width > 4096 { p.err = fmt.Errorf("Unsupported texture width: %d", width); return }
-  if (width & 3) != 0 { p.err = errors.New("Texture width must be a multiple of 4"); return }
+  if isBlockCompressed(pixelType) {
+    if err := checkDimension(width, height, pixelType); err != nil { p.err = err; return }
+  }
   depth := int(buf.GetInt32(0x20))
   if depth != 1 { p.err = fmt.Errorf("Unsupported texture depth: %d", depth); return }
   numSurfaces := int(buf.GetInt32(0x24))
@@ -406,7 +525,10 @@ func (p *Pvr) importPvr(data []byte) {
   numFaces := int(buf.GetInt32(0x28))
   if numFaces != 1 { p.err = fmt.Errorf("Unsupported number of texture faces: %d", numFaces); return }
   numMipMaps := int(buf.GetInt32(0x2c))
-  if numMipMaps != 1 { p.err = fmt.Errorf("Unsupported number of texture mip maps: %d", numMipMaps); return }
+  if numMipMaps < 1 || numMipMaps > maxMipMapCount(width, height) {
+    p.err = fmt.Errorf("Unsupported number of texture mip maps: %d", numMipMaps)
+    return
+  }
   metaLen := int(buf.GetInt32(0x30))
   if metaLen < 0 { metaLen = 0 }
   if buf.BufferLength() < 0x34 + metaLen { p.err = errors.New("Metadata size mismatch"); return }
@@ -417,18 +539,17 @@ func (p *Pvr) importPvr(data []byte) {
     meta = make([]byte, 0)
   }
 
-  // importing texture data
+  // importing texture data, mipmap levels are stored in order of decreasing size
   ofsData := 0x34 + metaLen
-  dxtFlags := 0
-  switch pixelType {
-    case TYPE_BC1: dxtFlags = squish.FLAGS_DXT1
-    case TYPE_BC2: dxtFlags = squish.FLAGS_DXT3
-    case TYPE_BC3: dxtFlags = squish.FLAGS_DXT5
+  levels := make([]draw.Image, numMipMaps)
+  for level := range levels {
+    w, h := mipMapSize(width, height, level)
+    texSize := textureSize(w, h, pixelType)
+    if buf.BufferLength() - ofsData < texSize { p.err = fmt.Errorf("PVR input buffer too small"); return }
+    levels[level] = decodeLevel(buf.Bytes()[ofsData:ofsData+texSize], w, h, pixelType, p.backend)
+    if levels[level] == nil { p.err = fmt.Errorf("Error while decoding texture data of mipmap level %d", level); return }
+    ofsData += texSize
   }
-  texSize := squish.GetStorageRequirements(width, height, dxtFlags)
-  if buf.BufferLength() - ofsData < texSize { p.err = fmt.Errorf("PVR input buffer too small"); return }
-  img := decodeTexture(buf.Bytes()[ofsData:], width, height, pixelType)
-  if img == nil { p.err = errors.New("Error while decoding texture data"); return }
 
   p.info.flags = flags
   p.info.pixelType = pixelType
@@ -437,17 +558,26 @@ func (p *Pvr) importPvr(data []byte) {
   p.info.height, p.info.width, p.info.depth = height, width, depth
   p.info.numSurfaces, p.info.numFaces, p.info.numMipMaps = numSurfaces, numFaces, numMipMaps
   p.info.meta = meta
-  p.img = img
+  p.img = levels[0]
+  p.mipmaps = levels[1:]
 }
 
-// Used internally. Creates a bye buffer containing PVR data.
+// Used internally. Creates a bye buffer containing PVR data. Missing mipmap levels are generated.
 func (p *Pvr) exportPvr() []byte {
+  if !pixelTypeEncodable(p.info.pixelType) {
+    p.err = fmt.Errorf("Encoding not supported for pixel format: %d", p.info.pixelType)
+    return nil
+  }
+  p.info.numMipMaps = mipMapCount(p.info.numMipMaps, p.info.width, p.info.height)
   hdr := p.prepareHeader()
-  out := encodeTexture(p.img, p.info.pixelType, p.quality, p.weightByAlpha, p.useMetric)
-  if out == nil { p.err = errors.New("Unable to encode texture data"); return nil }
-  buf := make([]byte, len(hdr) + len(out))
-  copy(buf[:len(hdr)], hdr)
-  copy(buf[len(hdr):], out)
+  buf := make([]byte, len(hdr))
+  copy(buf, hdr)
+  for level := 0; level < p.info.numMipMaps; level++ {
+    out := encodeLevel(p.mipMap(level), p.info.pixelType, p.quality, p.weightByAlpha, p.useMetric, p.backend,
+                       p.concurrency)
+    if out == nil { p.err = fmt.Errorf("Unable to encode texture data of mipmap level %d", level); return nil }
+    buf = append(buf, out...)
+  }
 
   return buf
 }
@@ -459,8 +589,9 @@ func (p *Pvr) prepareHeader() []byte {
   buf.InsertBytes(0, 0x34)
   buf.PutInt32(0x00, versionSig)
   buf.PutInt32(0x04, int32(p.info.flags))
-  buf.PutInt32(0x08, int32(p.info.pixelType))
-  buf.PutInt32(0x0c, 0)   // extended pixel format bits not used
+  pfLo, pfHi := pixelFormat(p.info.pixelType)
+  buf.PutUint32(0x08, pfLo)
+  buf.PutUint32(0x0c, pfHi)   // channel bits of uncompressed formats
   buf.PutInt32(0x10, int32(p.info.colorSpace))
   buf.PutInt32(0x14, int32(p.info.channelType))
   buf.PutInt32(0x18, int32(p.info.height))
@@ -477,86 +608,117 @@ func (p *Pvr) prepareHeader() []byte {
   return buf.Bytes()
 }
 
-// Used internally. Decodes raw PVR texture data into 32-bt ARGB pixels.
-func decodeTexture(data []byte, width, height, pixelType int) draw.Image {
-  if width <= 0 || height <= 0 || data == nil { return nil }
+// Used internally. Returns the size of encoded texture data of the specified dimension in bytes.
+func textureSize(width, height, pixelType int) int {
+  if cf, ok := channelFormats[pixelType]; ok { return width * height * cf.pixelSize() }
+  if bf, ok := blockFormats[pixelType]; ok {
+    bx, by := bf.blocks(width, height)
+    return bx * by * bf.size
+  }
+  return 0
+}
+
+// Used internally. Decodes a single texture level of arbitrary dimension. Block-compressed data is decoded with a
+// dimension padded to full blocks and cropped afterwards.
+func decodeLevel(data []byte, width, height, pixelType, backend int) draw.Image {
+  bf, ok := blockFormats[pixelType]
+  if !ok { return decodeTexture(data, width, height, pixelType, backend) }
+  bx, by := bf.blocks(width, height)
+  newWidth, newHeight := bx * bf.width, by * bf.height
+  img := decodeTexture(data, newWidth, newHeight, pixelType, backend)
+  if img == nil || (newWidth == width && newHeight == height) { return img }
+  imgOut := image.NewRGBA(image.Rect(0, 0, width, height))
+  draw.Draw(imgOut, imgOut.Bounds(), img, img.Bounds().Min, draw.Src)
+  return imgOut
+}
 
-  flags := squish.FLAGS_SOURCE_BGRA
-  switch pixelType {
-    case TYPE_BC1: flags |= squish.FLAGS_DXT1
-    case TYPE_BC2: flags |= squish.FLAGS_DXT3
-    case TYPE_BC3: flags |= squish.FLAGS_DXT5
-    default: return nil
+// Used internally. Encodes a single texture level of arbitrary dimension. The image is padded to a multiple of 4
+// for block-compressed pixel types.
+func encodeLevel(img image.Image, pixelType, quality int, weightByAlpha, useMetric bool, backend, workers int) []byte {
+  if !isBlockCompressed(pixelType) {
+    return encodeTexture(img, pixelType, quality, weightByAlpha, useMetric, backend, workers)
   }
-  img := squish.DecompressImage(width, height, data, flags)
-  imgOut, ok := img.(draw.Image)
-  if !ok {
-    imgOut = image.NewRGBA(image.Rectangle{image.ZP, img.Bounds().Size()})
-    draw.Draw(imgOut, imgOut.Bounds(), img, img.Bounds().Min, draw.Src)
+  width, height := img.Bounds().Dx(), img.Bounds().Dy()
+  newWidth, newHeight := (width + 3) & ^3, (height + 3) & ^3
+  if newWidth != width || newHeight != height {
+    img = resizeCanvas(img, newWidth, newHeight, true)
   }
-  return imgOut
+  return encodeTexture(img, pixelType, quality, weightByAlpha, useMetric, backend, workers)
+}
+
+// Used internally. Decodes raw PVR texture data into 32-bt ARGB pixels. BC1, BC2 and BC3 data is decoded by the
+// specified backend.
+func decodeTexture(data []byte, width, height, pixelType, backend int) draw.Image {
+  if width <= 0 || height <= 0 || data == nil { return nil }
+  if isChannelFormat(pixelType) { return decodeChannels(data, width, height, pixelType) }
+
+  if backend == BACKEND_SQUISH && squishAvailable {
+    switch pixelType {
+      case TYPE_BC1, TYPE_BC2, TYPE_BC3: return decodeSquish(data, width, height, pixelType)
+    }
+  }
+  return decodeBlocks(data, width, height, pixelType)
 }
 
 
 // Used internally. Encodes 32-bit ARGB pixel data into the specified texture compression format.
 // Set "quality" to the desired quality setting.
 // Set "useMetric" to use perceptive color weights which may improve visual quality.
-func encodeTexture(img image.Image, pixelType, quality int, weightByAlpha, useMetric bool) []byte {
+// Set "backend" to the backend used for BC1, BC2 and BC3 compression.
+// Set "workers" to the max. number of concurrent workers, 0 to use GOMAXPROCS.
+func encodeTexture(img image.Image, pixelType, quality int, weightByAlpha, useMetric bool, backend, workers int) []byte {
+  if isChannelFormat(pixelType) { return encodeChannels(img, pixelType) }
   width, height := img.Bounds().Dx(), img.Bounds().Dy()
   if width < 1 || width & 3 != 0 || height < 1 || height & 3 != 0 { return nil }
   if quality < QUALITY_LOW { quality = QUALITY_LOW }
   if quality > QUALITY_HIGH { quality = QUALITY_HIGH }
-
-  newWidth, newHeight := width, height
-  flags := 0
   switch pixelType {
-    case TYPE_BC1:
-      flags |= squish.FLAGS_DXT1
-      newWidth = (newWidth + 3) & ^3
-      newHeight = (newHeight + 3) & ^3
-    case TYPE_BC2:
-      flags |= squish.FLAGS_DXT3
-      newWidth = (newWidth + 3) & ^3
-      newHeight = (newHeight + 3) & ^3
-    case TYPE_BC3:
-      flags |= squish.FLAGS_DXT5
-      newWidth = (newWidth + 3) & ^3
-      newHeight = (newHeight + 3) & ^3
+    case TYPE_BC1, TYPE_BC2, TYPE_BC3:
     default: return nil
   }
 
-  if newWidth != width || newHeight != height {
-    img = resizeCanvas(img, newWidth, newHeight, true)
-    if img == nil { return nil }
-    width = newWidth
-    height = newHeight
-  }
-
-  switch quality {
-    case QUALITY_LOW:
-      flags |= squish.FLAGS_RANGE_FIT
-    case QUALITY_HIGH:
-      flags |= squish.FLAGS_ITERATIVE_CLUSTER_FIT
-    default:
-      flags |= squish.FLAGS_CLUSTER_FIT
-  }
+  imgOut := image.NewNRGBA(image.Rect(0, 0, width, height))
+  draw.Draw(imgOut, imgOut.Bounds(), img, img.Bounds().Min, draw.Src)
+  return encodeStrips(imgOut, workers, func(strip *image.NRGBA) []byte {
+    if backend == BACKEND_SQUISH && squishAvailable {
+      return encodeSquish(strip, pixelType, quality, weightByAlpha, useMetric)
+    }
+    return encodeBlocks(strip, pixelType, quality, weightByAlpha, useMetric)
+  })
+}
 
-  if weightByAlpha {
-    flags |= squish.FLAGS_WEIGHT_BY_ALPHA
+// Used internally. Splits the image into horizontal strips of whole block rows, encodes them concurrently by the
+// given function and returns the concatenated result. Strips are assigned to workers in fixed order, so the result
+// does not depend on the number of workers. Returns nil if any strip could not be encoded.
+func encodeStrips(img *image.NRGBA, workers int, encode func(strip *image.NRGBA) []byte) []byte {
+  width, height := img.Bounds().Dx(), img.Bounds().Dy()
+  rows := height / 4
+  if workers <= 0 { workers = runtime.GOMAXPROCS(0) }
+  if workers > rows { workers = rows }
+  if workers <= 1 { return encode(img) }
+
+  results := make([][]byte, workers)
+  var wg sync.WaitGroup
+  for i := 0; i < workers; i++ {
+    y0, y1 := (rows * i / workers) * 4, (rows * (i + 1) / workers) * 4
+    strip := image.NewNRGBA(image.Rect(0, 0, width, y1 - y0))
+    copy(strip.Pix, img.Pix[img.PixOffset(0, y0):img.PixOffset(0, y1)])
+    wg.Add(1)
+    go func(i int, strip *image.NRGBA) {
+      defer wg.Done()
+      results[i] = encode(strip)
+    }(i, strip)
   }
+  wg.Wait()
 
-  var metric []float32 = nil
-  if useMetric {
-    metric = squish.METRIC_PERCEPTUAL
-  } else {
-    metric = squish.METRIC_UNIFORM
+  size := 0
+  for _, data := range results {
+    if data == nil { return nil }
+    size += len(data)
   }
-
-  imgOut := image.NewNRGBA(img.Bounds())
-  draw.Draw(imgOut, imgOut.Bounds(), img, img.Bounds().Min, draw.Src)
-  data := squish.CompressImage(imgOut, flags, metric)
-
-  return data
+  out := make([]byte, 0, size)
+  for _, data := range results { out = append(out, data...) }
+  return out
 }
 
 // Used internally. Resizes the given image object. Optionally preserve as much of old content as possible.
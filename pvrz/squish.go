@@ -0,0 +1,67 @@
+// +build !nosquish
+
+package pvrz
+
+import (
+  "image"
+  "image/draw"
+
+  "github.com/InfinityTools/go-squish"
+)
+
+// Whether the squish backend is available. Build with tag "nosquish" to remove the cgo dependency.
+const squishAvailable = true
+
+
+// Used internally. Decodes BC1, BC2 or BC3 texture data with the squish library. Returns nil on error.
+func decodeSquish(data []byte, width, height, pixelType int) draw.Image {
+  flags := squish.FLAGS_SOURCE_BGRA
+  switch pixelType {
+    case TYPE_BC1: flags |= squish.FLAGS_DXT1
+    case TYPE_BC2: flags |= squish.FLAGS_DXT3
+    case TYPE_BC3: flags |= squish.FLAGS_DXT5
+    default: return nil
+  }
+  img := squish.DecompressImage(width, height, data, flags)
+  if img == nil { return nil }
+  imgOut, ok := img.(draw.Image)
+  if !ok {
+    imgOut = image.NewRGBA(image.Rectangle{image.ZP, img.Bounds().Size()})
+    draw.Draw(imgOut, imgOut.Bounds(), img, img.Bounds().Min, draw.Src)
+  }
+  return imgOut
+}
+
+// Used internally. Encodes 32-bit pixel data into BC1, BC2 or BC3 blocks with the squish library. Dimension must be
+// a multiple of 4. Returns nil on error.
+func encodeSquish(img *image.NRGBA, pixelType, quality int, weightByAlpha, useMetric bool) []byte {
+  flags := 0
+  switch pixelType {
+    case TYPE_BC1: flags |= squish.FLAGS_DXT1
+    case TYPE_BC2: flags |= squish.FLAGS_DXT3
+    case TYPE_BC3: flags |= squish.FLAGS_DXT5
+    default: return nil
+  }
+
+  switch quality {
+    case QUALITY_LOW:
+      flags |= squish.FLAGS_RANGE_FIT
+    case QUALITY_HIGH:
+      flags |= squish.FLAGS_ITERATIVE_CLUSTER_FIT
+    default:
+      flags |= squish.FLAGS_CLUSTER_FIT
+  }
+
+  if weightByAlpha {
+    flags |= squish.FLAGS_WEIGHT_BY_ALPHA
+  }
+
+  var metric []float32 = nil
+  if useMetric {
+    metric = squish.METRIC_PERCEPTUAL
+  } else {
+    metric = squish.METRIC_UNIFORM
+  }
+
+  return squish.CompressImage(img, flags, metric)
+}
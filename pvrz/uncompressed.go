@@ -0,0 +1,168 @@
+package pvrz
+
+import (
+  "fmt"
+  "image"
+  "image/draw"
+)
+
+const (
+  // Supported uncompressed types. Pixel data is defined by the channel order and bits per channel in the PVR header.
+  TYPE_RGBA8888       = 0x100   // 32-bit, one byte per channel in order red, green, blue, alpha
+  TYPE_BGRA8888       = 0x101   // 32-bit, one byte per channel in order blue, green, red, alpha
+  TYPE_RGB565         = 0x102   // 16-bit, red in the most significant bits, no alpha
+  TYPE_RGBA4444       = 0x103   // 16-bit, red in the most significant bits
+  TYPE_RGBA5551       = 0x104   // 16-bit, red in the most significant bits, 1-bit alpha
+)
+
+// Describes an uncompressed pixel format by channel order and bits per channel.
+type channelFormat struct {
+  order string    // channel names in storage order ('r', 'g', 'b' or 'a')
+  bits  []uint    // bits per channel in storage order
+}
+
+var channelFormats = map[int]channelFormat{
+  TYPE_RGBA8888: { "rgba", []uint{ 8, 8, 8, 8 } },
+  TYPE_BGRA8888: { "bgra", []uint{ 8, 8, 8, 8 } },
+  TYPE_RGB565:   { "rgb",  []uint{ 5, 6, 5 } },
+  TYPE_RGBA4444: { "rgba", []uint{ 4, 4, 4, 4 } },
+  TYPE_RGBA5551: { "rgba", []uint{ 5, 5, 5, 1 } },
+}
+
+
+// Used internally. Returns whether the specified pixel type is an uncompressed channel-order format.
+func isChannelFormat(pixelType int) bool {
+  _, ok := channelFormats[pixelType]
+  return ok
+}
+
+// Used internally. Returns the 64-bit PVR pixel format of the given pixel type as low and high 32-bit values.
+// Compressed types are stored in the low value only. Uncompressed types store channel names in the low value and bits
+// per channel in the high value.
+func pixelFormat(pixelType int) (lo, hi uint32) {
+  cf, ok := channelFormats[pixelType]
+  if !ok { return uint32(pixelType), 0 }
+  for i := range cf.bits {
+    lo |= uint32(cf.order[i]) << uint(i * 8)
+    hi |= uint32(cf.bits[i]) << uint(i * 8)
+  }
+  return
+}
+
+// Used internally. Returns the pixel type of the given 64-bit PVR pixel format, or -1 if the format is not supported.
+func pixelTypeOf(lo, hi uint32) int {
+  if hi == 0 {
+    if pixelTypeSupported(int(lo)) && !isChannelFormat(int(lo)) { return int(lo) }
+    return -1
+  }
+  for pixelType := range channelFormats {
+    if l, h := pixelFormat(pixelType); l == lo && h == hi { return pixelType }
+  }
+  return -1
+}
+
+// Used internally. Returns a textual representation of the 64-bit PVR pixel format, e.g. "r5g6b5".
+func pixelFormatString(lo, hi uint32) string {
+  if hi == 0 { return fmt.Sprintf("%d", lo) }
+  s := ""
+  for i := uint(0); i < 4; i++ {
+    name, bits := byte(lo >> (i * 8)), byte(hi >> (i * 8))
+    if name == 0 { break }
+    s += fmt.Sprintf("%c%d", name, bits)
+  }
+  return s
+}
+
+// Used internally. Returns the number of bytes per pixel of the given uncompressed pixel type.
+func (cf channelFormat) pixelSize() int {
+  total := uint(0)
+  for _, b := range cf.bits { total += b }
+  return int((total + 7) / 8)
+}
+
+// Used internally. Returns whether all channels are stored as individual bytes.
+func (cf channelFormat) isByteAligned() bool {
+  for _, b := range cf.bits {
+    if b != 8 { return false }
+  }
+  return true
+}
+
+// Used internally. Decodes uncompressed pixel data into a 32-bit image with non-premultiplied alpha.
+//
+// Byte-aligned channels are stored in the specified order. Packed formats are stored as little-endian words with the
+// first channel in the most significant bits. Missing alpha channels are treated as fully opaque.
+func decodeChannels(data []byte, width, height, pixelType int) draw.Image {
+  cf, ok := channelFormats[pixelType]
+  if !ok || width <= 0 || height <= 0 { return nil }
+  size := cf.pixelSize()
+  if len(data) < width * height * size { return nil }
+
+  img := image.NewNRGBA(image.Rect(0, 0, width, height))
+  for i, ofs := 0, 0; i < width * height; i, ofs = i + 1, ofs + size {
+    value := uint32(0)
+    for b := 0; b < size; b++ { value |= uint32(data[ofs + b]) << uint(b * 8) }
+    pixel := img.Pix[i*4:i*4+4]
+    pixel[3] = 255
+    shift := uint(size * 8)
+    for c, bits := range cf.bits {
+      var v uint32
+      if cf.isByteAligned() {
+        v = uint32(data[ofs + c])
+      } else {
+        shift -= bits
+        v = (value >> shift) & ((1 << bits) - 1)
+      }
+      pixel[channelIndex(cf.order[c])] = expandBits(v, bits)
+    }
+  }
+  return img
+}
+
+// Used internally. Encodes 32-bit pixel data into the specified uncompressed pixel format. Pixel data is stored with
+// non-premultiplied alpha. See decodeChannels for the storage layout.
+func encodeChannels(img image.Image, pixelType int) []byte {
+  cf, ok := channelFormats[pixelType]
+  width, height := img.Bounds().Dx(), img.Bounds().Dy()
+  if !ok || width < 1 || height < 1 { return nil }
+
+  src := image.NewNRGBA(image.Rect(0, 0, width, height))
+  draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+  size := cf.pixelSize()
+  data := make([]byte, width * height * size)
+  for i, ofs := 0, 0; i < width * height; i, ofs = i + 1, ofs + size {
+    pixel := src.Pix[i*4:i*4+4]
+    if cf.isByteAligned() {
+      for c := range cf.bits { data[ofs + c] = pixel[channelIndex(cf.order[c])] }
+      continue
+    }
+    value := uint32(0)
+    for c, bits := range cf.bits {
+      value = (value << bits) | reduceBits(pixel[channelIndex(cf.order[c])], bits)
+    }
+    for b := 0; b < size; b++ { data[ofs + b] = byte(value >> uint(b * 8)) }
+  }
+  return data
+}
+
+// Used internally. Returns the offset of the given channel name within a 32-bit RGBA pixel.
+func channelIndex(name byte) int {
+  switch name {
+    case 'r': return 0
+    case 'g': return 1
+    case 'b': return 2
+    default:  return 3
+  }
+}
+
+// Used internally. Scales a channel value of the given number of bits to 8 bits.
+func expandBits(value uint32, bits uint) uint8 {
+  max := uint32(1 << bits) - 1
+  return uint8((value * 255 + max / 2) / max)
+}
+
+// Used internally. Scales an 8-bit channel value to the given number of bits.
+func reduceBits(value uint8, bits uint) uint32 {
+  max := uint32(1 << bits) - 1
+  return (uint32(value) * max + 127) / 255
+}
@@ -0,0 +1,605 @@
+package script
+
+import (
+  "errors"
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+// Available BAF token types
+const (
+  tokEOF      = iota
+  tokWord     // keywords, symbols and numbers
+  tokString   // "text", ~text~ or %text%
+  tokBracket  // [content]
+  tokPunct    // (, ), ! or ,
+)
+
+// A single token of BAF source code.
+type bafToken struct {
+  kind  int
+  text  string
+  line  int
+}
+
+// A parsed argument of a trigger, action or object identifier.
+type bafArg struct {
+  kind    int         // token type of the argument
+  text    string      // symbol, number, string or bracket content
+  args    []*bafArg   // arguments of function-like arguments
+  call    bool        // true if text is followed by an argument list
+  region  string      // optional region of an object (bracket content)
+  line    int
+}
+
+// Used internally. Parser state for compiling BAF source code.
+type bafParser struct {
+  toks  []bafToken
+  pos   int
+  r     *Resolver
+}
+
+// Used internally. Maps parameter values to the fields of a trigger or action.
+type paramSlots struct {
+  ints    []*int
+  strs    []*string
+  objs    []*Object
+  point   []*int    // x and y fields, nil if points are stored in the integer fields
+}
+
+
+// Decompile returns the script as BAF source code. IDS files required to resolve symbols are requested from r.
+//
+// Returns an error if a trigger or action code is not defined by TRIGGER.IDS or ACTION.IDS.
+// Operation is skipped if error state is set.
+func (s *Script) Decompile(r *Resolver) (string, error) {
+  if s.err != nil { return "", s.err }
+  if r == nil { return "", errors.New("No resolver specified") }
+
+  var sb strings.Builder
+  for _, b := range s.Blocks {
+    sb.WriteString("IF\n")
+    for _, t := range b.Triggers {
+      text, err := r.decompileTrigger(t)
+      if err != nil { return "", err }
+      sb.WriteString("  " + text + "\n")
+    }
+    sb.WriteString("THEN\n")
+    for _, resp := range b.Responses {
+      sb.WriteString(fmt.Sprintf("  RESPONSE #%d\n", resp.Weight))
+      for _, a := range resp.Actions {
+        text, err := r.decompileAction(a)
+        if err != nil { return "", err }
+        sb.WriteString("    " + text + "\n")
+      }
+    }
+    sb.WriteString("END\n\n")
+  }
+  return sb.String(), nil
+}
+
+// Compile compiles BAF source code into a new Script object. IDS files required to resolve symbols are requested
+// from r.
+//
+// Overloaded triggers and actions are selected by the number and type of arguments. Symbols may be specified as
+// numbers as well.
+func Compile(src string, r *Resolver) (*Script, error) {
+  if r == nil { return nil, errors.New("No resolver specified") }
+  toks, err := lexBaf(src)
+  if err != nil { return nil, err }
+
+  p := bafParser{ toks: toks, r: r }
+  s := Script{ Blocks: make([]*Block, 0) }
+  for p.peek().kind != tokEOF {
+    b, err := p.parseBlock()
+    if err != nil { return nil, err }
+    s.Blocks = append(s.Blocks, b)
+  }
+  return &s, nil
+}
+
+
+// Used internally. Returns the parameter slots of the given trigger.
+func triggerSlots(t *Trigger) paramSlots {
+  return paramSlots{ ints: []*int{ &t.Int1, &t.Int2, &t.Int3 }, strs: []*string{ &t.Str1, &t.Str2 },
+                     objs: []*Object{ &t.Object } }
+}
+
+// Used internally. Returns the parameter slots of the given action.
+func actionSlots(a *Action) paramSlots {
+  return paramSlots{ ints: []*int{ &a.Int1, &a.Int2, &a.Int3 }, strs: []*string{ &a.Str1, &a.Str2 },
+                     objs: []*Object{ &a.Objects[1], &a.Objects[2] }, point: []*int{ &a.X, &a.Y } }
+}
+
+// Used internally. Returns the BAF representation of a trigger.
+func (r *Resolver) decompileTrigger(t *Trigger) (string, error) {
+  f, err := r.ids("TRIGGER")
+  if err != nil { return "", err }
+  e, ok := f.byValue[t.ID]
+  if !ok { return "", fmt.Errorf("Unknown trigger code: 0x%x", t.ID) }
+
+  text := r.decompileCall(e, triggerSlots(t))
+  if (t.Flags & 1) != 0 { text = "!" + text }
+  return text, nil
+}
+
+// Used internally. Returns the BAF representation of an action. Actions with an actor object are wrapped in
+// ActionOverride.
+func (r *Resolver) decompileAction(a *Action) (string, error) {
+  f, err := r.ids("ACTION")
+  if err != nil { return "", err }
+  e, ok := f.byValue[a.ID]
+  if !ok { return "", fmt.Errorf("Unknown action code: %d", a.ID) }
+
+  text := r.decompileCall(e, actionSlots(a))
+  if !r.isEmptyObject(&a.Objects[0]) {
+    text = fmt.Sprintf("ActionOverride(%s,%s)", r.decompileObject(&a.Objects[0]), text)
+  }
+  return text, nil
+}
+
+// Used internally. Returns the function call representation of a trigger or action.
+func (r *Resolver) decompileCall(e *idsEntry, slots paramSlots) string {
+  args := make([]string, 0, len(e.params))
+  ni, ns, no := 0, 0, 0
+  for i, p := range e.params {
+    switch p.kind {
+      case 'I':
+        value := 0
+        if ni < len(slots.ints) { value = *slots.ints[ni] }
+        ni++
+        args = append(args, r.symbol(p.ids, value))
+      case 'P':
+        var x, y int
+        if slots.point != nil {
+          x, y = *slots.point[0], *slots.point[1]
+        } else {
+          if ni < len(slots.ints) { x = *slots.ints[ni] }
+          if ni + 1 < len(slots.ints) { y = *slots.ints[ni+1] }
+          ni += 2
+        }
+        args = append(args, fmt.Sprintf("[%d.%d]", x, y))
+      case 'S':
+        value := ""
+        if i > 0 && e.params[i-1].kind == 'S' && isScopeParam(p) {
+          // scope is stored in front of the preceding string
+          if ns - 1 >= len(slots.strs) { args = append(args, bafQuote(value)); continue }
+          value = *slots.strs[ns-1]
+          if len(value) > 6 { value = value[:6] }
+          args[len(args)-1] = bafQuote((*slots.strs[ns-1])[len(value):])
+        } else {
+          if ns < len(slots.strs) { value = *slots.strs[ns] }
+          ns++
+        }
+        args = append(args, bafQuote(value))
+      case 'O':
+        o := &Object{}
+        if no < len(slots.objs) { o = slots.objs[no] }
+        no++
+        args = append(args, r.decompileObject(o))
+      default:
+        args = append(args, "0")
+    }
+  }
+  return e.symbol + "(" + strings.Join(args, ",") + ")"
+}
+
+// Used internally. Returns the BAF representation of an object.
+func (r *Resolver) decompileObject(o *Object) string {
+  if len(o.Name) > 0 { return bafQuote(o.Name) }
+
+  value := func(i int) int {
+    if i < len(o.Values) { return o.Values[i] }
+    return 0
+  }
+
+  // object specifiers, trailing zero values are omitted
+  spec := make([]string, 0, len(r.layout))
+  ids := make([]int, 0, 5)
+  last := -1
+  for i, name := range r.layout {
+    if name == OBJECT_IDENTIFIER {
+      if v := value(i); v != 0 { ids = append(ids, v) }
+      continue
+    }
+    spec = append(spec, r.symbol(name, value(i)))
+    if value(i) != 0 { last = len(spec) - 1 }
+  }
+
+  text := ""
+  if last >= 0 || len(ids) == 0 {
+    if last < 0 { last = 0 }
+    text = "[" + strings.Join(spec[:last+1], ".") + "]"
+  }
+  for i := len(ids) - 1; i >= 0; i-- {
+    sym := r.symbol(OBJECT_IDENTIFIER, ids[i])
+    if len(text) > 0 { sym += "(" + text + ")" }
+    text = sym
+  }
+
+  // additional object values are only emitted if they differ from the defaults
+  extra := make([]string, len(r.extra))
+  modified := false
+  for i, def := range r.extra {
+    v := def
+    if len(r.layout) + i < len(o.Values) { v = o.Values[len(r.layout) + i] }
+    if v != def { modified = true }
+    extra[i] = strconv.Itoa(v)
+  }
+  if modified { text += "[" + strings.Join(extra, ".") + "]" }
+  return text
+}
+
+// Used internally. Returns the given text as BAF string literal with a suitable delimiter.
+func bafQuote(s string) string {
+  switch {
+    case !strings.Contains(s, "\""):
+      return "\"" + s + "\""
+    case !strings.Contains(s, "~"):
+      return "~" + s + "~"
+    default:
+      return "%" + s + "%"
+  }
+}
+
+// Used internally. Splits BAF source code into tokens.
+func lexBaf(src string) ([]bafToken, error) {
+  toks := make([]bafToken, 0, len(src) / 4)
+  line := 1
+  for i := 0; i < len(src); {
+    c := src[i]
+    switch {
+      case c == '\n':
+        line++
+        i++
+      case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+        i++
+      case strings.HasPrefix(src[i:], "//"):
+        for i < len(src) && src[i] != '\n' { i++ }
+      case strings.HasPrefix(src[i:], "/*"):
+        end := strings.Index(src[i+2:], "*/")
+        if end < 0 { return nil, fmt.Errorf("BAF line %d: unterminated comment", line) }
+        line += strings.Count(src[i:i+2+end], "\n")
+        i += end + 4
+      case c == '~' || c == '"' || c == '%':
+        end := strings.IndexByte(src[i+1:], c)
+        if end < 0 { return nil, fmt.Errorf("BAF line %d: unterminated string", line) }
+        toks = append(toks, bafToken{ tokString, src[i+1:i+1+end], line })
+        line += strings.Count(src[i+1:i+1+end], "\n")
+        i += end + 2
+      case c == '[':
+        end := strings.IndexByte(src[i+1:], ']')
+        if end < 0 { return nil, fmt.Errorf("BAF line %d: unterminated bracket", line) }
+        toks = append(toks, bafToken{ tokBracket, strings.TrimSpace(src[i+1:i+1+end]), line })
+        i += end + 2
+      case c == '(' || c == ')' || c == ',' || c == '!':
+        toks = append(toks, bafToken{ tokPunct, src[i:i+1], line })
+        i++
+      default:
+        start := i
+        for i < len(src) && !strings.ContainsRune(" \t\r\n\f\v~\"%[](),!", rune(src[i])) &&
+            !strings.HasPrefix(src[i:], "//") && !strings.HasPrefix(src[i:], "/*") {
+          i++
+        }
+        toks = append(toks, bafToken{ tokWord, src[start:i], line })
+    }
+  }
+  toks = append(toks, bafToken{ tokEOF, "", line })
+  return toks, nil
+}
+
+
+// Used internally. Returns the current token without consuming it.
+func (p *bafParser) peek() bafToken {
+  return p.toks[p.pos]
+}
+
+// Used internally. Returns the current token and advances to the next token.
+func (p *bafParser) next() bafToken {
+  tok := p.toks[p.pos]
+  if tok.kind != tokEOF { p.pos++ }
+  return tok
+}
+
+// Used internally. Returns whether the current token is the specified keyword (case-insensitive).
+func (p *bafParser) isKeyword(keyword string) bool {
+  tok := p.peek()
+  return tok.kind == tokWord && strings.EqualFold(tok.text, keyword)
+}
+
+// Used internally. Returns whether the current token is the specified punctuation character.
+func (p *bafParser) isPunct(punct string) bool {
+  tok := p.peek()
+  return tok.kind == tokPunct && tok.text == punct
+}
+
+// Used internally. Consumes the specified keyword or returns an error.
+func (p *bafParser) expectKeyword(keyword string) error {
+  if !p.isKeyword(keyword) { return p.errorf("%s expected", keyword) }
+  p.pos++
+  return nil
+}
+
+// Used internally. Consumes the specified punctuation character or returns an error.
+func (p *bafParser) expectPunct(punct string) error {
+  if !p.isPunct(punct) { return p.errorf("%q expected", punct) }
+  p.pos++
+  return nil
+}
+
+// Used internally. Returns an error message with line information of the current token.
+func (p *bafParser) errorf(format string, args ...interface{}) error {
+  tok := p.peek()
+  found := tok.text
+  if tok.kind == tokEOF { found = "end of file" }
+  return fmt.Errorf("BAF line %d: %s (found %q)", tok.line, fmt.Sprintf(format, args...), found)
+}
+
+// Used internally. Returns an error message with line information of the given argument.
+func argErrorf(arg *bafArg, format string, args ...interface{}) error {
+  return fmt.Errorf("BAF line %d: %s", arg.line, fmt.Sprintf(format, args...))
+}
+
+// Used internally. Parses a single condition-response block.
+func (p *bafParser) parseBlock() (*Block, error) {
+  if err := p.expectKeyword("IF"); err != nil { return nil, err }
+  b := Block{ Triggers: make([]*Trigger, 0), Responses: make([]*Response, 0) }
+  for !p.isKeyword("THEN") {
+    t, err := p.parseTrigger()
+    if err != nil { return nil, err }
+    b.Triggers = append(b.Triggers, t)
+  }
+  p.pos++
+
+  for p.isKeyword("RESPONSE") {
+    p.pos++
+    tok := p.next()
+    weight, err := strconv.Atoi(strings.TrimPrefix(tok.text, "#"))
+    if tok.kind != tokWord || !strings.HasPrefix(tok.text, "#") || err != nil {
+      p.pos--
+      return nil, p.errorf("response weight expected")
+    }
+    resp := Response{ Weight: weight, Actions: make([]*Action, 0) }
+    for !p.isKeyword("RESPONSE") && !p.isKeyword("END") {
+      a, err := p.parseAction()
+      if err != nil { return nil, err }
+      resp.Actions = append(resp.Actions, a)
+    }
+    b.Responses = append(b.Responses, &resp)
+  }
+  if err := p.expectKeyword("END"); err != nil { return nil, err }
+  return &b, nil
+}
+
+// Used internally. Parses a single trigger.
+func (p *bafParser) parseTrigger() (*Trigger, error) {
+  t := Trigger{}
+  if p.isPunct("!") {
+    p.pos++
+    t.Flags = 1
+  }
+  call, err := p.parseArg()
+  if err != nil { return nil, err }
+  if call.kind != tokWord { return nil, argErrorf(call, "trigger expected") }
+
+  f, err := p.r.ids("TRIGGER")
+  if err != nil { return nil, err }
+  e, err := selectOverload(f, call)
+  if err != nil { return nil, err }
+  t.ID = e.value
+  if err := p.r.compileCall(e, call, triggerSlots(&t)); err != nil { return nil, err }
+  return &t, nil
+}
+
+// Used internally. Parses a single action.
+func (p *bafParser) parseAction() (*Action, error) {
+  call, err := p.parseArg()
+  if err != nil { return nil, err }
+  return p.r.compileAction(call)
+}
+
+// Used internally. Parses a single argument, including nested argument lists.
+func (p *bafParser) parseArg() (*bafArg, error) {
+  tok := p.peek()
+  if tok.kind != tokWord && tok.kind != tokString && tok.kind != tokBracket {
+    return nil, p.errorf("argument expected")
+  }
+  p.pos++
+  arg := bafArg{ kind: tok.kind, text: tok.text, line: tok.line }
+
+  if tok.kind == tokWord && p.isPunct("(") {
+    p.pos++
+    arg.call = true
+    arg.args = make([]*bafArg, 0)
+    for !p.isPunct(")") {
+      if len(arg.args) > 0 {
+        if err := p.expectPunct(","); err != nil { return nil, err }
+      }
+      sub, err := p.parseArg()
+      if err != nil { return nil, err }
+      arg.args = append(arg.args, sub)
+    }
+    p.pos++
+  }
+
+  // optional object region
+  if p.peek().kind == tokBracket { arg.region = p.next().text }
+  return &arg, nil
+}
+
+
+// Used internally. Selects the IDS entry that matches the number and kind of arguments best.
+func selectOverload(f *idsFile, call *bafArg) (*idsEntry, error) {
+  list, ok := f.byName[strings.ToUpper(call.text)]
+  if !ok {
+    // numeric codes are accepted as well
+    if v, err := parseNumber(call.text); err == nil {
+      if e, ok := f.byValue[v]; ok { return e, nil }
+    }
+    return nil, argErrorf(call, "unknown function %s", call.text)
+  }
+
+  var candidate *idsEntry
+  for _, e := range list {
+    if len(e.params) != len(call.args) { continue }
+    if candidate == nil { candidate = e }
+    match := true
+    for i, p := range e.params {
+      isString := call.args[i].kind == tokString
+      if (p.kind == 'S') != isString && p.kind != 'O' { match = false; break }
+    }
+    if match { return e, nil }
+  }
+  if candidate == nil { return nil, argErrorf(call, "wrong number of arguments for %s", call.text) }
+  return candidate, nil
+}
+
+// Used internally. Compiles an action call, including ActionOverride.
+func (r *Resolver) compileAction(call *bafArg) (*Action, error) {
+  if call.kind != tokWord { return nil, argErrorf(call, "action expected") }
+  if strings.EqualFold(call.text, "ActionOverride") && len(call.args) == 2 {
+    a, err := r.compileAction(call.args[1])
+    if err != nil { return nil, err }
+    o, err := r.compileObject(call.args[0])
+    if err != nil { return nil, err }
+    a.Objects[0] = *o
+    return a, nil
+  }
+
+  f, err := r.ids("ACTION")
+  if err != nil { return nil, err }
+  e, err := selectOverload(f, call)
+  if err != nil { return nil, err }
+  a := Action{ ID: e.value }
+  for i := range a.Objects { a.Objects[i] = r.emptyObject() }
+  if err := r.compileCall(e, call, actionSlots(&a)); err != nil { return nil, err }
+  return &a, nil
+}
+
+// Used internally. Assigns the arguments of a trigger or action to the given parameter slots.
+func (r *Resolver) compileCall(e *idsEntry, call *bafArg, slots paramSlots) error {
+  for _, o := range slots.objs { *o = r.emptyObject() }
+  if len(call.args) != len(e.params) {
+    return argErrorf(call, "%s expects %d arguments", e.symbol, len(e.params))
+  }
+
+  ni, ns, no := 0, 0, 0
+  for i, p := range e.params {
+    arg := call.args[i]
+    switch p.kind {
+      case 'I':
+        if arg.kind != tokWord || arg.call { return argErrorf(arg, "numeric argument expected for %s", p.name) }
+        v, err := r.value(p.ids, arg.text)
+        if err != nil { return argErrorf(arg, "%v", err) }
+        if ni < len(slots.ints) { *slots.ints[ni] = v }
+        ni++
+      case 'P':
+        if arg.kind != tokBracket { return argErrorf(arg, "point expected for %s", p.name) }
+        items := strings.Split(arg.text, ".")
+        if len(items) != 2 { return argErrorf(arg, "point expected for %s", p.name) }
+        x, errX := parseNumber(items[0])
+        y, errY := parseNumber(items[1])
+        if errX != nil || errY != nil { return argErrorf(arg, "point expected for %s", p.name) }
+        if slots.point != nil {
+          *slots.point[0], *slots.point[1] = x, y
+        } else {
+          if ni < len(slots.ints) { *slots.ints[ni] = x }
+          if ni + 1 < len(slots.ints) { *slots.ints[ni+1] = y }
+          ni += 2
+        }
+      case 'S':
+        if arg.kind != tokString { return argErrorf(arg, "string expected for %s", p.name) }
+        if i > 0 && e.params[i-1].kind == 'S' && isScopeParam(p) {
+          if ns - 1 >= len(slots.strs) { return argErrorf(arg, "too many string arguments for %s", e.symbol) }
+          *slots.strs[ns-1] = arg.text + *slots.strs[ns-1]
+        } else {
+          if ns < len(slots.strs) { *slots.strs[ns] = arg.text }
+          ns++
+        }
+      case 'O':
+        o, err := r.compileObject(arg)
+        if err != nil { return err }
+        if no < len(slots.objs) { *slots.objs[no] = *o }
+        no++
+      default:
+        return argErrorf(arg, "unsupported parameter type %c", p.kind)
+    }
+  }
+  return nil
+}
+
+// Used internally. Returns whether the object refers to no specific target, taking additional object values into
+// account.
+func (r *Resolver) isEmptyObject(o *Object) bool {
+  if len(o.Name) > 0 { return false }
+  for i, v := range o.Values {
+    def := 0
+    if i >= len(r.layout) && i - len(r.layout) < len(r.extra) { def = r.extra[i - len(r.layout)] }
+    if v != def { return false }
+  }
+  return true
+}
+
+// Used internally. Returns an object without target, as defined by the object layout.
+func (r *Resolver) emptyObject() Object {
+  o := Object{ Values: make([]int, len(r.layout) + len(r.extra)) }
+  copy(o.Values[len(r.layout):], r.extra)
+  return o
+}
+
+// Used internally. Compiles an object argument.
+func (r *Resolver) compileObject(arg *bafArg) (*Object, error) {
+  o := r.emptyObject()
+  if arg.kind == tokString {
+    o.Name = arg.text
+  } else {
+    // object identifiers, outermost first
+    slots := make([]int, 0, 5)
+    for i, name := range r.layout {
+      if name == OBJECT_IDENTIFIER { slots = append(slots, i) }
+    }
+    cur := arg
+    for n := 0; cur != nil && cur.kind == tokWord; n++ {
+      if n >= len(slots) { return nil, argErrorf(cur, "too many object identifiers") }
+      v, err := r.value(OBJECT_IDENTIFIER, cur.text)
+      if err != nil { return nil, argErrorf(cur, "%v", err) }
+      o.Values[slots[n]] = v
+      switch len(cur.args) {
+        case 0:
+          cur = nil
+        case 1:
+          cur = cur.args[0]
+        default:
+          return nil, argErrorf(cur, "object identifiers expect a single argument")
+      }
+    }
+
+    // object specifiers
+    if cur != nil {
+      if cur.kind != tokBracket { return nil, argErrorf(cur, "object expected") }
+      items := strings.Split(cur.text, ".")
+      idx := 0
+      for i, name := range r.layout {
+        if name == OBJECT_IDENTIFIER { continue }
+        if idx >= len(items) { break }
+        v, err := r.value(name, strings.TrimSpace(items[idx]))
+        if err != nil { return nil, argErrorf(cur, "%v", err) }
+        o.Values[i] = v
+        idx++
+      }
+      if idx < len(items) { return nil, argErrorf(cur, "too many object specifiers") }
+    }
+  }
+
+  if len(arg.region) > 0 {
+    items := strings.Split(arg.region, ".")
+    if len(items) != len(r.extra) { return nil, argErrorf(arg, "%d additional object values expected", len(r.extra)) }
+    for i, item := range items {
+      v, err := parseNumber(item)
+      if err != nil { return nil, argErrorf(arg, "numeric value expected: %s", item) }
+      o.Values[len(r.layout) + i] = v
+    }
+  }
+  return &o, nil
+}
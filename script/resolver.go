@@ -0,0 +1,167 @@
+package script
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+
+  "github.com/InfinityTools/go-ietools"
+  "github.com/InfinityTools/go-ietools/tables"
+)
+
+// Predefined object layouts. Each entry names the IDS file associated with the object value at the same position.
+// The special name OBJECT_IDENTIFIER marks object identifier slots (resolved by OBJECT.IDS).
+var (
+  // Object layout of Baldur's Gate, Baldur's Gate 2 and the Enhanced Edition games.
+  LAYOUT_BG = []string{ "EA", "GENERAL", "RACE", "CLASS", "SPECIFIC", "GENDER", "ALIGNMEN",
+                        OBJECT_IDENTIFIER, OBJECT_IDENTIFIER, OBJECT_IDENTIFIER, OBJECT_IDENTIFIER, OBJECT_IDENTIFIER }
+
+  // Additional object values of the Enhanced Edition games (region rectangle).
+  EXTRA_EE  = []int{ -1, -1, -1, -1 }
+)
+
+const (
+  OBJECT_IDENTIFIER = "OBJECT"  // Marks object identifier slots in an object layout
+)
+
+// Resolver provides access to the IDS files needed to decompile or compile scripts.
+type Resolver struct {
+  loader  func(name string) *tables.Table   // returns the IDS table of the given name
+  files   map[string]*idsFile               // cache of parsed IDS files
+  layout  []string                          // object layout
+  extra   []int                             // additional object values
+}
+
+// A single IDS entry.
+type idsEntry struct {
+  value   int
+  symbol  string        // symbol name, excluding parameter definitions
+  params  []idsParam    // parameter definitions for TRIGGER.IDS and ACTION.IDS entries
+}
+
+// A single parameter definition of a trigger or action.
+type idsParam struct {
+  kind  byte    // S, I, O, P or A
+  name  string
+  ids   string  // associated IDS file for numeric parameters, may be empty
+}
+
+// A parsed IDS file.
+type idsFile struct {
+  byValue map[int]*idsEntry     // first entry of a value
+  byName  map[string][]*idsEntry // all entries of an uppercased symbol name
+}
+
+
+// NewResolver returns a new Resolver object that uses the given loader function to retrieve IDS tables. loader is
+// called with the IDS resource name without extension (e.g. "TRIGGER") and should return nil if the table is not
+// available. Each table is only requested once.
+//
+// The resolver uses the object layout LAYOUT_BG without additional object values by default.
+func NewResolver(loader func(name string) *tables.Table) *Resolver {
+  r := Resolver{ loader: loader, files: make(map[string]*idsFile), layout: LAYOUT_BG }
+  return &r
+}
+
+// SetObjectLayout defines the object layout used by the game. layout lists the IDS file names associated with the
+// object values (see LAYOUT_xxx variables). extra defines the default values of additional object values that are
+// not associated with IDS files (see EXTRA_xxx variables).
+func (r *Resolver) SetObjectLayout(layout []string, extra []int) {
+  r.layout = append([]string(nil), layout...)
+  r.extra = append([]int(nil), extra...)
+}
+
+// GetObjectLayout returns the object layout and the default values of additional object values.
+func (r *Resolver) GetObjectLayout() (layout []string, extra []int) {
+  return append([]string(nil), r.layout...), append([]int(nil), r.extra...)
+}
+
+
+// Used internally. Returns the parsed IDS file of the given name.
+func (r *Resolver) ids(name string) (*idsFile, error) {
+  name = strings.ToUpper(name)
+  if f, ok := r.files[name]; ok {
+    if f == nil { return nil, fmt.Errorf("IDS file not available: %s", name) }
+    return f, nil
+  }
+
+  var t *tables.Table
+  if r.loader != nil { t = r.loader(name) }
+  if t == nil || t.Error() != nil {
+    r.files[name] = nil
+    return nil, fmt.Errorf("IDS file not available: %s", name)
+  }
+  f := parseIds(t)
+  r.files[name] = f
+  return f, nil
+}
+
+// Used internally. Returns the symbol of value in the given IDS file, or the number itself if not available.
+func (r *Resolver) symbol(ids string, value int) string {
+  if len(ids) > 0 {
+    if f, err := r.ids(ids); err == nil {
+      if e, ok := f.byValue[value]; ok && len(e.symbol) > 0 { return e.symbol }
+    }
+  }
+  return strconv.Itoa(value)
+}
+
+// Used internally. Returns the value of symbol in the given IDS file. Numeric symbols are returned directly.
+func (r *Resolver) value(ids, symbol string) (int, error) {
+  if v, err := parseNumber(symbol); err == nil { return v, nil }
+  if len(ids) == 0 { return 0, fmt.Errorf("numeric value expected: %s", symbol) }
+  f, err := r.ids(ids)
+  if err != nil { return 0, err }
+  if list, ok := f.byName[strings.ToUpper(symbol)]; ok { return list[0].value, nil }
+  return 0, fmt.Errorf("symbol %s not found in %s.IDS", symbol, strings.ToUpper(ids))
+}
+
+
+// Used internally. Parses the content of an IDS table.
+func parseIds(t *tables.Table) *idsFile {
+  f := idsFile{ byValue: make(map[int]*idsEntry), byName: make(map[string][]*idsEntry) }
+  for _, entry := range t.AsIDS().Entries() {
+    e := parseIdsEntry(entry.Value, entry.Symbol)
+    if _, ok := f.byValue[entry.Value]; !ok { f.byValue[entry.Value] = e }
+    key := strings.ToUpper(e.symbol)
+    f.byName[key] = append(f.byName[key], e)
+  }
+  return &f
+}
+
+// Used internally. Parses a single IDS entry. Symbols of the form Name(T:Param*IDS,...) are split into name and
+// parameter definitions.
+func parseIdsEntry(value int, text string) *idsEntry {
+  e := idsEntry{ value: value, symbol: strings.TrimSpace(text) }
+  open := strings.IndexByte(text, '(')
+  if open < 0 { return &e }
+
+  e.symbol = strings.TrimSpace(text[:open])
+  e.params = make([]idsParam, 0)
+  inner := text[open+1:]
+  if close := strings.LastIndexByte(inner, ')'); close >= 0 { inner = inner[:close] }
+  for _, item := range strings.Split(inner, ",") {
+    item = strings.TrimSpace(item)
+    if len(item) < 2 || item[1] != ':' { continue }
+    p := idsParam{ kind: strings.ToUpper(item[:1])[0] }
+    name := item[2:]
+    if star := strings.IndexByte(name, '*'); star >= 0 {
+      p.ids = strings.TrimSpace(name[star+1:])
+      name = name[:star]
+    }
+    p.name = strings.TrimSpace(name)
+    e.params = append(e.params, p)
+  }
+  return &e
+}
+
+// Used internally. Parses a decimal or hexadecimal (0x prefix) number as signed 32-bit integer.
+func parseNumber(s string) (int, error) {
+  v, err := ietools.ParseNumber(strings.TrimSpace(s), 32)
+  return int(v), err
+}
+
+// Used internally. Returns whether the string parameter p is concatenated with the preceding string parameter.
+func isScopeParam(p idsParam) bool {
+  return p.kind == 'S' && (strings.EqualFold(p.name, "Area") || strings.EqualFold(p.name, "Scope"))
+}
@@ -0,0 +1,328 @@
+/*
+Package script provides functions for dealing with compiled scripts of the BCS and BS resource types.
+
+Compiled scripts can be decompiled to BAF source code and BAF source code can be compiled back into BCS format.
+Symbols are resolved by IDS files (such as TRIGGER.IDS, ACTION.IDS or OBJECT.IDS) which are loaded by the tables
+package.
+*/
+package script
+
+import (
+  "bytes"
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "strconv"
+)
+
+// Script contains a parsed compiled script.
+type Script struct {
+  Blocks  []*Block    // list of condition-response blocks
+  err     error
+}
+
+// Block represents a single condition-response block of the script.
+type Block struct {
+  Triggers  []*Trigger    // list of triggers
+  Responses []*Response   // list of weighted responses
+}
+
+// Trigger represents a single trigger of a condition.
+type Trigger struct {
+  ID      int       // trigger code as defined in TRIGGER.IDS
+  Int1    int
+  Flags   int       // bit 0: negated trigger
+  Int2    int
+  Int3    int
+  Str1    string
+  Str2    string
+  Object  Object
+}
+
+// Response represents a weighted list of actions.
+type Response struct {
+  Weight  int
+  Actions []*Action
+}
+
+// Action represents a single action of a response.
+type Action struct {
+  ID      int       // action code as defined in ACTION.IDS
+  Objects [3]Object // actor (used by ActionOverride), first and second object parameter
+  Int1    int
+  X, Y    int       // point parameter
+  Int2    int
+  Int3    int
+  Str1    string
+  Str2    string
+}
+
+// Object represents a script object.
+type Object struct {
+  Values  []int     // object values as defined by the object layout (see Resolver)
+  Name    string    // script name of the object, takes precedence over Values if not empty
+}
+
+// Available tokens of compiled script data
+const (
+  bcsEOF    = iota
+  bcsTag    // two-letter section tag, e.g. SC or TR
+  bcsInt    // numeric value
+  bcsString // quoted string
+)
+
+// A single token of compiled script data.
+type bcsToken struct {
+  kind  int
+  text  string
+  value int
+}
+
+// Used internally. Parser state for compiled script data.
+type bcsParser struct {
+  data  []byte
+  pos   int
+  tok   bcsToken
+}
+
+
+// Load uses the given Reader to load compiled script data (BCS or BS) from the underlying buffer. The function returns
+// a pointer to the Script object.
+//
+// Use function Error to check if the Load function returned successfully.
+func Load(r io.Reader) *Script {
+  s := Script{ Blocks: make([]*Block, 0) }
+  data, err := ioutil.ReadAll(r)
+  if err != nil { s.err = err; return &s }
+
+  p := bcsParser{ data: data }
+  p.advance()
+  s.Blocks, s.err = p.parseScript()
+  return &s
+}
+
+// Save writes the current script in compiled form to the specified Writer.
+//
+// Does nothing if the Script is in an invalid state (see Error function).
+func (s *Script) Save(w io.Writer) {
+  if s.err != nil { return }
+
+  var buf bytes.Buffer
+  buf.WriteString("SC\n")
+  for _, b := range s.Blocks {
+    buf.WriteString("CR\nCO\n")
+    for _, t := range b.Triggers {
+      buf.WriteString(fmt.Sprintf("TR\n%d %d %d %d %d %s %s ", t.ID, t.Int1, t.Flags, t.Int2, t.Int3, bcsQuote(t.Str1), bcsQuote(t.Str2)))
+      writeObject(&buf, &t.Object)
+      buf.WriteString("TR\n")
+    }
+    buf.WriteString("CO\nRS\n")
+    for _, r := range b.Responses {
+      buf.WriteString(fmt.Sprintf("RE\n%d", r.Weight))
+      for _, a := range r.Actions {
+        buf.WriteString(fmt.Sprintf("AC\n%d", a.ID))
+        for i := range a.Objects { writeObject(&buf, &a.Objects[i]) }
+        buf.WriteString(fmt.Sprintf("%d %d %d %d %d%s %s AC\n", a.Int1, a.X, a.Y, a.Int2, a.Int3, bcsQuote(a.Str1), bcsQuote(a.Str2)))
+      }
+      buf.WriteString("RE\n")
+    }
+    buf.WriteString("RS\nCR\n")
+  }
+  buf.WriteString("SC\n")
+
+  _, s.err = w.Write(buf.Bytes())
+}
+
+// Error returns the error state of the most recent operation on Script. Use ClearError function to clear the current
+// error state.
+func (s *Script) Error() error {
+  return s.err
+}
+
+// ClearError clears the error state from the last Script operation. Must be called for subsequent operations to work
+// correctly.
+func (s *Script) ClearError() {
+  s.err = nil
+}
+
+
+// IsEmpty returns whether the object refers to no specific target.
+func (o *Object) IsEmpty() bool {
+  if len(o.Name) > 0 { return false }
+  for _, v := range o.Values {
+    if v != 0 { return false }
+  }
+  return true
+}
+
+
+// Used internally. Writes an object in compiled form.
+func writeObject(buf *bytes.Buffer, o *Object) {
+  buf.WriteString("OB\n")
+  for _, v := range o.Values {
+    buf.WriteString(strconv.Itoa(v))
+    buf.WriteByte(' ')
+  }
+  buf.WriteString(bcsQuote(o.Name))
+  buf.WriteString("OB\n")
+}
+
+// Used internally. Returns the string in quotes.
+func bcsQuote(s string) string {
+  return "\"" + s + "\""
+}
+
+// Used internally. Reads the next token into p.tok.
+func (p *bcsParser) advance() {
+  for p.pos < len(p.data) && (p.data[p.pos] <= 0x20) { p.pos++ }
+  if p.pos >= len(p.data) { p.tok = bcsToken{ kind: bcsEOF }; return }
+
+  c := p.data[p.pos]
+  switch {
+    case c == '"':
+      end := bytes.IndexByte(p.data[p.pos+1:], '"')
+      if end < 0 { end = len(p.data) - p.pos - 1 }
+      p.tok = bcsToken{ kind: bcsString, text: string(p.data[p.pos+1:p.pos+1+end]) }
+      p.pos += end + 2
+    case c == '-' || (c >= '0' && c <= '9'):
+      start := p.pos
+      p.pos++
+      for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' { p.pos++ }
+      value, _ := strconv.ParseInt(string(p.data[start:p.pos]), 10, 64)
+      p.tok = bcsToken{ kind: bcsInt, text: string(p.data[start:p.pos]), value: int(int32(value)) }
+    default:
+      end := p.pos + 2
+      if end > len(p.data) { end = len(p.data) }
+      p.tok = bcsToken{ kind: bcsTag, text: string(p.data[p.pos:end]) }
+      p.pos = end
+  }
+}
+
+// Used internally. Consumes the specified tag or returns an error.
+func (p *bcsParser) expect(tag string) error {
+  if p.tok.kind != bcsTag || p.tok.text != tag {
+    return fmt.Errorf("BCS offset %d: %s expected", p.pos, tag)
+  }
+  p.advance()
+  return nil
+}
+
+// Used internally. Returns whether the current token is the specified tag.
+func (p *bcsParser) isTag(tag string) bool {
+  return p.tok.kind == bcsTag && p.tok.text == tag
+}
+
+// Used internally. Reads a sequence of numeric values.
+func (p *bcsParser) ints() []int {
+  list := make([]int, 0, 16)
+  for p.tok.kind == bcsInt {
+    list = append(list, p.tok.value)
+    p.advance()
+  }
+  return list
+}
+
+// Used internally. Reads a sequence of strings.
+func (p *bcsParser) strs() []string {
+  list := make([]string, 0, 2)
+  for p.tok.kind == bcsString {
+    list = append(list, p.tok.text)
+    p.advance()
+  }
+  return list
+}
+
+// Used internally. Parses a whole script.
+func (p *bcsParser) parseScript() ([]*Block, error) {
+  blocks := make([]*Block, 0)
+  if p.tok.kind == bcsEOF { return blocks, nil }
+  if err := p.expect("SC"); err != nil { return nil, err }
+  for p.isTag("CR") {
+    p.advance()
+    b := Block{ Triggers: make([]*Trigger, 0), Responses: make([]*Response, 0) }
+    if err := p.expect("CO"); err != nil { return nil, err }
+    for p.isTag("TR") {
+      t, err := p.parseTrigger()
+      if err != nil { return nil, err }
+      b.Triggers = append(b.Triggers, t)
+    }
+    if err := p.expect("CO"); err != nil { return nil, err }
+    if err := p.expect("RS"); err != nil { return nil, err }
+    for p.isTag("RE") {
+      r, err := p.parseResponse()
+      if err != nil { return nil, err }
+      b.Responses = append(b.Responses, r)
+    }
+    if err := p.expect("RS"); err != nil { return nil, err }
+    if err := p.expect("CR"); err != nil { return nil, err }
+    blocks = append(blocks, &b)
+  }
+  if err := p.expect("SC"); err != nil { return nil, err }
+  return blocks, nil
+}
+
+// Used internally. Parses a single trigger.
+func (p *bcsParser) parseTrigger() (*Trigger, error) {
+  if err := p.expect("TR"); err != nil { return nil, err }
+  t := Trigger{}
+  ints, strs := p.ints(), p.strs()
+  fields := []*int{ &t.ID, &t.Int1, &t.Flags, &t.Int2, &t.Int3 }
+  for i := 0; i < len(ints) && i < len(fields); i++ { *fields[i] = ints[i] }
+  if len(strs) > 0 { t.Str1 = strs[0] }
+  if len(strs) > 1 { t.Str2 = strs[1] }
+  o, err := p.parseObject()
+  if err != nil { return nil, err }
+  t.Object = *o
+  if err := p.expect("TR"); err != nil { return nil, err }
+  return &t, nil
+}
+
+// Used internally. Parses a single weighted response.
+func (p *bcsParser) parseResponse() (*Response, error) {
+  if err := p.expect("RE"); err != nil { return nil, err }
+  r := Response{ Actions: make([]*Action, 0) }
+  if p.tok.kind == bcsInt {
+    r.Weight = p.tok.value
+    p.advance()
+  }
+  for p.isTag("AC") {
+    a, err := p.parseAction()
+    if err != nil { return nil, err }
+    r.Actions = append(r.Actions, a)
+  }
+  if err := p.expect("RE"); err != nil { return nil, err }
+  return &r, nil
+}
+
+// Used internally. Parses a single action.
+func (p *bcsParser) parseAction() (*Action, error) {
+  if err := p.expect("AC"); err != nil { return nil, err }
+  a := Action{}
+  if p.tok.kind != bcsInt { return nil, fmt.Errorf("BCS offset %d: action code expected", p.pos) }
+  a.ID = p.tok.value
+  p.advance()
+  for i := range a.Objects {
+    o, err := p.parseObject()
+    if err != nil { return nil, err }
+    a.Objects[i] = *o
+  }
+  ints, strs := p.ints(), p.strs()
+  fields := []*int{ &a.Int1, &a.X, &a.Y, &a.Int2, &a.Int3 }
+  for i := 0; i < len(ints) && i < len(fields); i++ { *fields[i] = ints[i] }
+  if len(strs) > 0 { a.Str1 = strs[0] }
+  if len(strs) > 1 { a.Str2 = strs[1] }
+  if err := p.expect("AC"); err != nil { return nil, err }
+  return &a, nil
+}
+
+// Used internally. Parses a single object.
+func (p *bcsParser) parseObject() (*Object, error) {
+  if err := p.expect("OB"); err != nil { return nil, err }
+  o := Object{ Values: p.ints() }
+  strs := p.strs()
+  if len(strs) > 0 { o.Name = strs[0] }
+  if err := p.expect("OB"); err != nil { return nil, err }
+  if len(o.Values) == 0 && len(o.Name) == 0 && p.tok.kind == bcsEOF { return nil, errors.New("BCS: unexpected end of data") }
+  return &o, nil
+}
@@ -0,0 +1,237 @@
+package tables
+
+import (
+  "encoding/csv"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "strings"
+
+  "golang.org/x/text/encoding/charmap"
+)
+
+const (
+  // Available table formats for import and export
+  FORMAT_TABLE  = 0   // Generic table, rows are converted as is
+  FORMAT_2DA    = 1   // 2DA table with column headers, row labels and default value
+  FORMAT_IDS    = 2   // IDS table with value/symbol pairs
+)
+
+var (
+  // Type names of the table formats in JSON data
+  jsonTypes = []string{ "TABLE", "2DA", "IDS" }
+)
+
+// JSON representation of table content.
+type jsonTable struct {
+  Type    string      `json:"type"`               // one of the jsonTypes names
+  Default string      `json:"default,omitempty"`  // 2DA default value
+  Columns []string    `json:"columns,omitempty"`  // 2DA column headers
+  Rows    [][]string  `json:"rows"`               // 2DA: label and values, IDS: value and symbol, TABLE: all items
+}
+
+
+// Format returns the table format of the current content (see FORMAT_xxx constants).
+// Operation is skipped if error state is set.
+func (t *Table) Format() int {
+  if t.err != nil { return FORMAT_TABLE }
+  if t.Is2DA() { return FORMAT_2DA }
+  if t.IsIDS() || t.AsIDS().HasHeader() { return FORMAT_IDS }
+  return FORMAT_TABLE
+}
+
+// ExportCSV writes the table content as comma-separated values to the specified Writer. Specify comma to define the
+// field delimiter, e.g. ',' for CSV or '\t' for TSV.
+//
+// 2DA tables are written as a header row followed by one record per table row. The first field of the header row
+// contains the default value, followed by the column headers. Missing cells are filled with the default value.
+// IDS tables are written as VALUE and SYMBOL fields. Other tables are written as is.
+//
+// Operation is skipped if error state is set.
+func (t *Table) ExportCSV(w io.Writer, comma rune) {
+  if t.err != nil { return }
+
+  cw := csv.NewWriter(w)
+  cw.Comma = comma
+  records := t.exportRecords()
+  if t.Format() == FORMAT_IDS { records = append([][]string{ { "VALUE", "SYMBOL" } }, records...) }
+  if err := cw.WriteAll(records); err != nil { t.err = err }
+}
+
+// ExportTSV writes the table content as tab-separated values to the specified Writer. See ExportCSV for details.
+// Operation is skipped if error state is set.
+func (t *Table) ExportTSV(w io.Writer) {
+  t.ExportCSV(w, '\t')
+}
+
+// ImportCSV uses the given Reader to load comma-separated values and returns a new table in the specified format (see
+// FORMAT_xxx constants). Specify comma to define the field delimiter, e.g. ',' for CSV or '\t' for TSV.
+//
+// The data is expected to be structured as written by ExportCSV. 2DA tables use "*" as default value if none is
+// specified. Trailing empty columns of 2DA tables are dropped, empty column headers in between result in an error.
+// Empty or missing fields of 2DA rows are filled with the default value, empty fields of other tables are skipped.
+// Whitespace within fields is replaced by underscores, except for IDS symbols. The header row of IDS tables is
+// optional. The returned table is encoded in ANSI Windows-1252 when saved.
+// Use function Error to check if the function returned successfully.
+func ImportCSV(r io.Reader, comma rune, format int) *Table {
+  cr := csv.NewReader(r)
+  cr.Comma = comma
+  cr.FieldsPerRecord = -1
+  cr.LazyQuotes = true
+  records, err := cr.ReadAll()
+  if err != nil { return &Table{ cmap: charmap.Windows1252, err: err } }
+
+  switch format {
+    case FORMAT_2DA:
+      if len(records) == 0 { return &Table{ cmap: charmap.Windows1252, err: errors.New("Missing 2DA header row") } }
+      header := records[0]
+      def := ""
+      if len(header) > 0 { def, header = header[0], header[1:] }
+      return importRecords(FORMAT_2DA, def, header, records[1:])
+    case FORMAT_IDS:
+      if len(records) > 0 && len(records[0]) > 0 {
+        if _, err := parseIdsValue(strings.TrimSpace(records[0][0])); err != nil { records = records[1:] }
+      }
+      return importRecords(FORMAT_IDS, "", nil, records)
+    default:
+      return importRecords(FORMAT_TABLE, "", nil, records)
+  }
+}
+
+// ImportTSV uses the given Reader to load tab-separated values and returns a new table in the specified format. See
+// ImportCSV for details.
+// Use function Error to check if the function returned successfully.
+func ImportTSV(r io.Reader, format int) *Table {
+  return ImportCSV(r, '\t', format)
+}
+
+// ExportJSON writes the table content as JSON object to the specified Writer.
+//
+// The object provides the table format as "type" ("2DA", "IDS" or "TABLE") and the table rows as "rows". 2DA tables
+// additionally provide the "default" value and the column headers as "columns". Rows of 2DA tables consist of the row
+// label followed by one value per column, rows of IDS tables consist of value and symbol.
+//
+// Operation is skipped if error state is set.
+func (t *Table) ExportJSON(w io.Writer) {
+  if t.err != nil { return }
+
+  jt := jsonTable{ Type: jsonTypes[t.Format()] }
+  records := t.exportRecords()
+  if t.Format() == FORMAT_2DA {
+    jt.Default = records[0][0]
+    jt.Columns = records[0][1:]
+    records = records[1:]
+  }
+  jt.Rows = records
+
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", "  ")
+  if err := enc.Encode(&jt); err != nil { t.err = err }
+}
+
+// ImportJSON uses the given Reader to load a JSON object as written by ExportJSON and returns a new table.
+//
+// The returned table is encoded in ANSI Windows-1252 when saved.
+// Use function Error to check if the function returned successfully.
+func ImportJSON(r io.Reader) *Table {
+  var jt jsonTable
+  if err := json.NewDecoder(r).Decode(&jt); err != nil { return &Table{ cmap: charmap.Windows1252, err: err } }
+  for format, name := range jsonTypes {
+    if strings.EqualFold(jt.Type, name) { return importRecords(format, jt.Default, jt.Columns, jt.Rows) }
+  }
+  return &Table{ cmap: charmap.Windows1252, err: fmt.Errorf("Unsupported table type: %q", jt.Type) }
+}
+
+
+// Used internally. Returns the table content as list of records. 2DA tables start with a record containing the
+// default value and the column headers.
+func (t *Table) exportRecords() [][]string {
+  records := make([][]string, 0, len(t.table))
+  switch t.Format() {
+    case FORMAT_2DA:
+      v := t.As2DA()
+      headers := v.headers()
+      records = append(records, append([]string{ v.def() }, headers...))
+      for row := range v.data() {
+        items := make([]string, len(headers) + 1)
+        items[0] = t.table[row2DAData + row][0]
+        for col := range headers { items[col + 1] = v.cell(row, col) }
+        records = append(records, items)
+      }
+    case FORMAT_IDS:
+      // retain original notation of values
+      for _, row := range t.table {
+        if e, ok := idsEntryOf(row); ok { records = append(records, []string{ row[0], e.Symbol }) }
+      }
+    default:
+      for _, row := range t.table { records = append(records, append([]string(nil), row...)) }
+  }
+  return records
+}
+
+// Used internally. Creates a new table from the given records.
+func importRecords(format int, def string, headers []string, records [][]string) *Table {
+  t := Table{ table: make([][]string, 0, len(records) + 3), cmap: charmap.Windows1252 }
+  switch format {
+    case FORMAT_2DA:
+      def = strings.TrimSpace(def)
+      if len(def) == 0 { def = "*" }
+      // trailing empty columns are dropped, as produced by spreadsheet applications
+      headers = trimRecord(headers)
+      for col, header := range headers {
+        if len(header) == 0 {
+          t.err = fmt.Errorf("Empty column header at column %d", col + 1)
+          return &t
+        }
+      }
+      t.table = append(t.table, []string{ "2DA", "V1.0" }, []string{ def }, headers)
+      for _, rec := range records {
+        items := trimRecord(rec)
+        if len(items) == 0 || len(items[0]) == 0 { continue }
+        for i := range items {
+          if len(items[i]) == 0 { items[i] = def }
+        }
+        for len(items) < len(headers) + 1 { items = append(items, def) }
+        t.table = append(t.table, items)
+      }
+    case FORMAT_IDS:
+      t.table = append(t.table, []string{ "IDS", "V1.0" })
+      for _, rec := range records {
+        if len(rec) < 2 { continue }
+        value := strings.TrimSpace(rec[0])
+        symbol := strings.Fields(strings.Join(rec[1:], " "))
+        if len(value) == 0 || len(symbol) == 0 { continue }
+        if _, err := parseIdsValue(value); err != nil {
+          t.err = fmt.Errorf("Invalid IDS value: %q", value)
+          return &t
+        }
+        t.table = append(t.table, append([]string{ value }, symbol...))
+      }
+    default:
+      for _, rec := range records {
+        if items := trimItems(rec); len(items) > 0 { t.table = append(t.table, items) }
+      }
+  }
+  t.dirty = true
+  return &t
+}
+
+// Used internally. Returns the trimmed items without trailing empty items. Remaining empty items are retained.
+// Whitespace within items is replaced by underscores, since table items cannot contain whitespace.
+func trimRecord(items []string) []string {
+  list := make([]string, len(items))
+  for i, item := range items { list[i] = strings.Join(strings.Fields(item), "_") }
+  for len(list) > 0 && len(list[len(list) - 1]) == 0 { list = list[:len(list) - 1] }
+  return list
+}
+
+// Used internally. Returns the trimmed items without empty items. Whitespace within items is replaced by underscores,
+// since table items cannot contain whitespace.
+func trimItems(items []string) []string {
+  list := make([]string, 0, len(items))
+  for _, item := range items {
+    if item = strings.Join(strings.Fields(item), "_"); len(item) > 0 { list = append(list, item) }
+  }
+  return list
+}
@@ -0,0 +1,249 @@
+package tables
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+const (
+  // Kinds of row changes
+  DIFF_ADDED    = 0   // Row exists only in the new table
+  DIFF_REMOVED  = 1   // Row exists only in the old table
+  DIFF_CHANGED  = 2   // Row exists in both tables with different content
+)
+
+// TableDiff describes the differences between two tables. Rows are aligned by row label for 2DA tables, or by the
+// item of a key column for other tables.
+type TableDiff struct {
+  OldDefault      string        // default value of the old 2DA table
+  NewDefault      string        // default value of the new 2DA table
+  AddedColumns    []string      // headers of columns that exist only in the new 2DA table
+  RemovedColumns  []string      // headers of columns that exist only in the old 2DA table
+  Rows            []RowChange   // added, removed or changed rows in table order
+  err             error
+}
+
+// RowChange describes a single added, removed or changed row.
+type RowChange struct {
+  Kind    int           // kind of change (see DIFF_xxx constants)
+  Key     string        // row label or key item
+  Old     []string      // items of the old row, nil for added rows
+  New     []string      // items of the new row, nil for removed rows
+  Cells   []CellChange  // changed cells of rows that exist in both tables
+}
+
+// CellChange describes a single changed cell.
+type CellChange struct {
+  Header  string  // column header for 2DA tables, column index otherwise
+  Old     string  // old value, empty if not available
+  New     string  // new value, empty if not available
+}
+
+// Used internally. Provides key-based access to the rows of a table participating in a diff.
+type diffSource struct {
+  keys    []string
+  rows    map[string][]string   // uppercased key -> items
+  index   map[string]int        // uppercased key -> position in keys
+}
+
+
+// Diff compares the 2DA tables oldTable and newTable. Rows are aligned by label and columns by header (both
+// case-insensitive). Only the first row of duplicate labels is considered.
+//
+// Cells of columns existing in both tables are compared. Missing cells are considered to contain the default value
+// of the respective table.
+//
+// Sets the error state of the returned diff if one of the tables has an error state or does not conform to the 2DA
+// format. The error state of the tables is not affected.
+func Diff(oldTable, newTable *Table) *TableDiff {
+  d := TableDiff{ AddedColumns: make([]string, 0), RemovedColumns: make([]string, 0), Rows: make([]RowChange, 0) }
+  for _, t := range []*Table{ oldTable, newTable } {
+    if t.err != nil { d.err = t.err; return &d }
+    if !t.Is2DA() { d.err = ErrNot2DA; return &d }
+  }
+  vo, vn := oldTable.As2DA(), newTable.As2DA()
+  d.OldDefault, d.NewDefault = vo.Default(), vn.Default()
+
+  oldHeaders, newHeaders := vo.Headers(), vn.Headers()
+  for _, h := range newHeaders {
+    if indexOfKey(oldHeaders, h) < 0 { d.AddedColumns = append(d.AddedColumns, h) }
+  }
+  for _, h := range oldHeaders {
+    if indexOfKey(newHeaders, h) < 0 { d.RemovedColumns = append(d.RemovedColumns, h) }
+  }
+
+  src := func(v *TwoDA) *diffSource {
+    ds := newDiffSource()
+    columns := len(v.headers())
+    for row := range v.data() {
+      items := make([]string, columns + 1)
+      items[0] = v.t.table[row2DAData + row][0]
+      for col := 0; col < columns; col++ { items[col + 1] = v.cell(row, col) }
+      ds.add(items[0], items)
+    }
+    return ds
+  }
+  // maps old column index to new column index
+  columns := make([]int, len(oldHeaders))
+  for i, h := range oldHeaders { columns[i] = indexOfKey(newHeaders, h) }
+  d.compare(src(vo), src(vn), func(o, n []string) []CellChange {
+    cells := make([]CellChange, 0)
+    for i, h := range oldHeaders {
+      if j := columns[i]; j >= 0 && o[i + 1] != n[j + 1] {
+        cells = append(cells, CellChange{ h, o[i + 1], n[j + 1] })
+      }
+    }
+    return cells
+  })
+  return &d
+}
+
+// DiffByColumn compares the tables oldTable and newTable, which do not need to conform to the 2DA format. Rows are
+// aligned by the item at column keyCol (case-insensitive). Rows without an item at keyCol are skipped. Only the first
+// row of duplicate keys is considered. Cells are identified by column index.
+//
+// Sets the error state of the returned diff if one of the tables has an error state or keyCol is negative. The error
+// state of the tables is not affected.
+func DiffByColumn(oldTable, newTable *Table, keyCol int) *TableDiff {
+  d := TableDiff{ AddedColumns: make([]string, 0), RemovedColumns: make([]string, 0), Rows: make([]RowChange, 0) }
+  for _, t := range []*Table{ oldTable, newTable } {
+    if t.err != nil { d.err = t.err; return &d }
+  }
+  if keyCol < 0 { d.err = fmt.Errorf("Invalid key column: %d", keyCol); return &d }
+
+  src := func(t *Table) *diffSource {
+    ds := newDiffSource()
+    for _, items := range t.table {
+      if keyCol < len(items) { ds.add(items[keyCol], append([]string(nil), items...)) }
+    }
+    return ds
+  }
+  d.compare(src(oldTable), src(newTable), func(o, n []string) []CellChange {
+    cells := make([]CellChange, 0)
+    for col := 0; col < len(o) || col < len(n); col++ {
+      var vo, vn string
+      if col < len(o) { vo = o[col] }
+      if col < len(n) { vn = n[col] }
+      if vo != vn { cells = append(cells, CellChange{ strconv.Itoa(col), vo, vn }) }
+    }
+    return cells
+  })
+  return &d
+}
+
+// Error returns the error state of the diff.
+func (d *TableDiff) Error() error {
+  return d.err
+}
+
+// IsEmpty returns whether both tables are equal in regard to the compared content.
+func (d *TableDiff) IsEmpty() bool {
+  return d.OldDefault == d.NewDefault && len(d.AddedColumns) == 0 && len(d.RemovedColumns) == 0 && len(d.Rows) == 0
+}
+
+// AddedRows returns the keys of all rows that exist only in the new table.
+func (d *TableDiff) AddedRows() []string {
+  return d.rowKeys(DIFF_ADDED)
+}
+
+// RemovedRows returns the keys of all rows that exist only in the old table.
+func (d *TableDiff) RemovedRows() []string {
+  return d.rowKeys(DIFF_REMOVED)
+}
+
+// ChangedRows returns the keys of all rows that exist in both tables with different content.
+func (d *TableDiff) ChangedRows() []string {
+  return d.rowKeys(DIFF_CHANGED)
+}
+
+// Unified returns the differences as text in the style of a unified diff. oldName and newName are used in the file
+// header lines. Each changed row is introduced by a hunk line containing the row key and the changed cells.
+// Returns an empty string if there are no differences or the error state is set.
+func (d *TableDiff) Unified(oldName, newName string) string {
+  if d.err != nil || d.IsEmpty() { return "" }
+  var sb strings.Builder
+  fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+  if d.OldDefault != d.NewDefault {
+    fmt.Fprintf(&sb, "@@ default @@\n-%s\n+%s\n", d.OldDefault, d.NewDefault)
+  }
+  if len(d.AddedColumns) > 0 || len(d.RemovedColumns) > 0 {
+    sb.WriteString("@@ columns @@\n")
+    for _, h := range d.RemovedColumns { fmt.Fprintf(&sb, "-%s\n", h) }
+    for _, h := range d.AddedColumns { fmt.Fprintf(&sb, "+%s\n", h) }
+  }
+  for _, rc := range d.Rows {
+    switch rc.Kind {
+      case DIFF_ADDED:
+        fmt.Fprintf(&sb, "@@ %s (added) @@\n+%s\n", rc.Key, strings.Join(rc.New, " "))
+      case DIFF_REMOVED:
+        fmt.Fprintf(&sb, "@@ %s (removed) @@\n-%s\n", rc.Key, strings.Join(rc.Old, " "))
+      default:
+        cells := make([]string, 0, len(rc.Cells))
+        for _, c := range rc.Cells { cells = append(cells, fmt.Sprintf("%s: %s -> %s", c.Header, c.Old, c.New)) }
+        fmt.Fprintf(&sb, "@@ %s (%s) @@\n-%s\n+%s\n", rc.Key, strings.Join(cells, ", "),
+                    strings.Join(rc.Old, " "), strings.Join(rc.New, " "))
+    }
+  }
+  return sb.String()
+}
+
+// String returns the differences as text in the style of a unified diff. See Unified for details.
+func (d *TableDiff) String() string {
+  return d.Unified("a", "b")
+}
+
+
+// Used internally. Returns an empty diff source.
+func newDiffSource() *diffSource {
+  return &diffSource{ keys: make([]string, 0), rows: make(map[string][]string), index: make(map[string]int) }
+}
+
+// Used internally. Adds a row to the source. Rows with duplicate keys are ignored.
+func (ds *diffSource) add(key string, items []string) {
+  upper := strings.ToUpper(key)
+  if _, ok := ds.rows[upper]; ok { return }
+  ds.rows[upper] = items
+  ds.index[upper] = len(ds.keys)
+  ds.keys = append(ds.keys, key)
+}
+
+// Used internally. Aligns the rows of both sources and stores the row changes. Removed rows are listed at their
+// original position, preceding added rows at the same position. cells returns the changed cells of two rows.
+func (d *TableDiff) compare(o, n *diffSource, cells func(o, n []string) []CellChange) {
+  pos := 0  // next unprocessed row of the old table
+  flush := func(end int) {
+    for ; pos < end; pos++ {
+      key := o.keys[pos]
+      if _, ok := n.rows[strings.ToUpper(key)]; !ok {
+        d.Rows = append(d.Rows, RowChange{ Kind: DIFF_REMOVED, Key: key, Old: o.rows[strings.ToUpper(key)] })
+      }
+    }
+  }
+
+  for _, key := range n.keys {
+    upper := strings.ToUpper(key)
+    newItems := n.rows[upper]
+    oldItems, ok := o.rows[upper]
+    if !ok {
+      // removed rows precede added rows at the same position
+      for pos < len(o.keys) && n.rows[strings.ToUpper(o.keys[pos])] == nil { flush(pos + 1) }
+      d.Rows = append(d.Rows, RowChange{ Kind: DIFF_ADDED, Key: key, New: newItems })
+      continue
+    }
+    if idx := o.index[upper]; idx >= pos { flush(idx + 1) }
+    if c := cells(oldItems, newItems); len(c) > 0 {
+      d.Rows = append(d.Rows, RowChange{ Kind: DIFF_CHANGED, Key: key, Old: oldItems, New: newItems, Cells: c })
+    }
+  }
+  flush(len(o.keys))
+}
+
+// Used internally. Returns the keys of all rows of the specified kind of change.
+func (d *TableDiff) rowKeys(kind int) []string {
+  list := make([]string, 0)
+  for _, rc := range d.Rows {
+    if rc.Kind == kind { list = append(list, rc.Key) }
+  }
+  return list
+}
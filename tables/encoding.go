@@ -0,0 +1,118 @@
+package tables
+
+import (
+  "bufio"
+  "bytes"
+  "io"
+  "io/ioutil"
+  "path/filepath"
+  "strings"
+  "unicode/utf8"
+
+  "github.com/InfinityTools/go-ietools"
+  "golang.org/x/text/encoding"
+  "golang.org/x/text/encoding/charmap"
+)
+
+var (
+  bomUtf8 = []byte{ 0xef, 0xbb, 0xbf }
+)
+
+
+// LoadAuto uses the given Reader to load table data from the underlying buffer and detects the text encoding
+// automatically. options is an optional combination of OPTION_xxx flags.
+//
+// Data starting with a UTF-8 byte order mark or containing valid UTF-8 sequences is decoded as UTF-8. Pure ASCII data
+// is treated as UTF-8 only if a language hint is available. Otherwise the encoding associated with the language hint
+// is used (see ietools.EncodingByLanguage), which defaults to Windows-1252.
+//
+// hint is an optional language code (e.g. "ru_RU") or file path containing a language folder of Enhanced Edition games
+// (e.g. "lang/ru_RU/override/table.2da"). Specify an empty string if no hint is available.
+//
+// The detected encoding and byte order mark are retained when the table is saved by the Save function.
+// Use function Error to check if the function returned successfully.
+func LoadAuto(r io.Reader, hint string, options ...int) *Table {
+  data, err := ioutil.ReadAll(r)
+  if err != nil { return &Table{ cmap: charmap.Windows1252, err: err } }
+
+  cm, bom := DetectEncoding(data, hint)
+  if bom { data = data[len(bomUtf8):] }
+  t := LoadEx(bytes.NewReader(data), cm, options...)
+  t.bom = bom
+  return t
+}
+
+// DetectEncoding determines the text encoding of the given table data. hint is an optional language code or file path
+// containing a language folder (see LoadAuto). Returns the character map or encoding to use for decoding, or nil for
+// UTF-8. bom indicates whether data starts with a UTF-8 byte order mark.
+func DetectEncoding(data []byte, hint string) (enc encoding.Encoding, bom bool) {
+  if bytes.HasPrefix(data, bomUtf8) { return nil, true }
+
+  lang := LanguageHint(hint)
+  ascii := true
+  for _, c := range data {
+    if c >= 0x80 { ascii = false; break }
+  }
+  if ascii && len(lang) > 0 { return nil, false }
+  if !ascii && utf8.Valid(data) { return nil, false }
+
+  if enc = ietools.EncodingByLanguage(lang); enc == nil { enc = charmap.Windows1252 }
+  return enc, false
+}
+
+// LanguageHint returns the lowercased language code of the given hint, including the region code if available
+// (e.g. "zh_tw"). hint can be a language code (e.g. "zh_TW" or "de") or a file path containing a language folder of
+// Enhanced Edition games (e.g. "lang/de_DE/dialog.tlk"). Returns an empty string if no language code could be
+// determined.
+func LanguageHint(hint string) string {
+  parts := strings.FieldsFunc(filepath.ToSlash(hint), func(r rune) bool { return r == '/' })
+  for i := 0; i + 1 < len(parts); i++ {
+    if strings.EqualFold(parts[i], "lang") { return languageCode(parts[i + 1]) }
+  }
+  if len(parts) == 1 { return languageCode(parts[0]) }
+  return ""
+}
+
+// IsUtf8 returns whether table data is saved in UTF-8 encoding by the Save function.
+func (t *Table) IsUtf8() bool {
+  return t.cmap == nil
+}
+
+// HasBOM returns whether table data is saved with a UTF-8 byte order mark by the Save function.
+func (t *Table) HasBOM() bool {
+  return t.cmap == nil && t.bom
+}
+
+// SetUtf8 defines that table data is saved in UTF-8 encoding by the Save function. Set bom to prepend a UTF-8 byte
+// order mark.
+func (t *Table) SetUtf8(bom bool) {
+  t.cmap, t.bom = nil, bom
+}
+
+// SetEncoding defines the character map or encoding used to encode table data by the Save function. A nil encoding is
+// equivalent to SetUtf8(false).
+func (t *Table) SetEncoding(enc encoding.Encoding) {
+  t.cmap, t.bom = ietools.NormalizeEncoding(enc), false
+}
+
+
+// Used internally. Returns the given reader without leading UTF-8 byte order mark and whether it had been present.
+func stripBOM(r io.Reader) (io.Reader, bool) {
+  br := bufio.NewReader(r)
+  if data, err := br.Peek(len(bomUtf8)); err == nil && bytes.Equal(data, bomUtf8) {
+    br.Discard(len(bomUtf8))
+    return br, true
+  }
+  return br, false
+}
+
+// Used internally. Returns the lowercased language code of s if it looks like a language code of the form "xx" or
+// "xx_YY". Returns an empty string otherwise.
+func languageCode(s string) string {
+  if len(s) != 2 && !(len(s) == 5 && s[2] == '_') { return "" }
+  for i := 0; i < 2; i++ {
+    c := s[i] | 0x20
+    if c < 'a' || c > 'z' { return "" }
+  }
+  return strings.ToLower(s)
+}
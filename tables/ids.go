@@ -0,0 +1,196 @@
+package tables
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+
+  "github.com/InfinityTools/go-ietools"
+)
+
+// IDS provides access to IDS table content by numeric value or symbol.
+//
+// Rows that do not start with a numeric value (decimal or hexadecimal with 0x prefix), such as the optional "IDS V1.0"
+// header or the row-count line, are ignored. Symbols containing whitespace are joined by a single space. Errors are
+// reported by the error state of the underlying Table.
+//
+// Values are signed 32-bit integers. Hexadecimal values of 0x80000000 and above are negative, e.g. 0xFFFFFFFF and -1
+// refer to the same value. Values passed to the IDS functions are truncated to 32 bits accordingly.
+type IDS struct {
+  t *Table
+}
+
+// IdsEntry defines a single value/symbol pair of an IDS table.
+type IdsEntry struct {
+  Value   int
+  Symbol  string
+}
+
+
+// AsIDS returns an IDS view of the table content.
+func (t *Table) AsIDS() *IDS {
+  return &IDS{ t }
+}
+
+// Table returns the underlying Table object.
+func (v *IDS) Table() *Table {
+  return v.t
+}
+
+// HasHeader returns whether the table starts with the "IDS V1.0" header.
+// Operation is skipped if error state is set.
+func (v *IDS) HasHeader() bool {
+  if v.t.err != nil || len(v.t.table) == 0 { return false }
+  row := v.t.table[0]
+  return len(row) == 2 && strings.EqualFold(row[0], "IDS") && strings.EqualFold(row[1], "V1.0")
+}
+
+// Entries returns all value/symbol pairs in table order.
+// Operation is skipped if error state is set.
+func (v *IDS) Entries() []IdsEntry {
+  if v.t.err != nil { return nil }
+  list := make([]IdsEntry, 0, len(v.t.table))
+  for _, row := range v.t.table {
+    if e, ok := idsEntryOf(row); ok { list = append(list, e) }
+  }
+  return list
+}
+
+// Count returns the number of value/symbol pairs.
+// Operation is skipped if error state is set.
+func (v *IDS) Count() int {
+  return len(v.Entries())
+}
+
+// Symbol returns the first symbol associated with value. Returns false if value is not defined.
+// Operation is skipped if error state is set.
+func (v *IDS) Symbol(value int) (string, bool) {
+  value = int(int32(value))
+  for _, e := range v.Entries() {
+    if e.Value == value { return e.Symbol, true }
+  }
+  return "", false
+}
+
+// Symbols returns all symbols associated with value.
+// Operation is skipped if error state is set.
+func (v *IDS) Symbols(value int) []string {
+  value = int(int32(value))
+  list := make([]string, 0)
+  for _, e := range v.Entries() {
+    if e.Value == value { list = append(list, e.Symbol) }
+  }
+  return list
+}
+
+// Value returns the value of the first entry with the specified symbol (case-insensitive), similar to WeiDU's
+// IDS_OF_SYMBOL. Returns false if symbol is not defined.
+// Operation is skipped if error state is set.
+func (v *IDS) Value(symbol string) (int, bool) {
+  symbol = strings.TrimSpace(symbol)
+  for _, e := range v.Entries() {
+    if strings.EqualFold(e.Symbol, symbol) { return e.Value, true }
+  }
+  return 0, false
+}
+
+// DuplicateValues returns all values that are associated with more than one symbol, mapped to their symbols.
+// Operation is skipped if error state is set.
+func (v *IDS) DuplicateValues() map[int][]string {
+  all := make(map[int][]string)
+  for _, e := range v.Entries() { all[e.Value] = append(all[e.Value], e.Symbol) }
+  for value, list := range all {
+    if len(list) < 2 { delete(all, value) }
+  }
+  return all
+}
+
+// DuplicateSymbols returns all symbols (uppercased) that are defined more than once, mapped to their values.
+// Operation is skipped if error state is set.
+func (v *IDS) DuplicateSymbols() map[string][]int {
+  all := make(map[string][]int)
+  for _, e := range v.Entries() {
+    key := strings.ToUpper(e.Symbol)
+    all[key] = append(all[key], e.Value)
+  }
+  for symbol, list := range all {
+    if len(list) < 2 { delete(all, symbol) }
+  }
+  return all
+}
+
+// Add appends a new entry with the specified value and symbol, regardless of existing entries. Set hex to write the
+// value in hexadecimal notation. The row-count line is updated if available.
+//
+// Sets error state if symbol is empty. Operation is skipped if error state is set.
+func (v *IDS) Add(value int, symbol string, hex bool) {
+  if v.t.err != nil { return }
+  symbol = strings.TrimSpace(symbol)
+  if len(symbol) == 0 { v.t.err = ietools.ErrIllegalArguments; return }
+
+  value = int(int32(value))
+  item := strconv.Itoa(value)
+  if hex { item = fmt.Sprintf("0x%X", uint32(value)) }
+  items := append([]string{ item }, strings.Fields(symbol)...)
+  v.t.insertTableRow(len(v.t.table), items)
+  v.updateCount()
+}
+
+// AddEntry adds symbol to the table and returns its value, similar to WeiDU's ADD_IDS_ENTRY.
+//
+// The value of the first matching entry is returned if symbol already exists. Otherwise preferred is used if it is
+// not yet defined, or the lowest unused value in range [minValue, maxValue]. Specify a negative preferred value to
+// skip the preference. Set hex to write the value in hexadecimal notation.
+//
+// Sets error state and returns -1 if symbol is empty or no free value is available.
+// Operation is skipped if error state is set.
+func (v *IDS) AddEntry(symbol string, minValue, maxValue, preferred int, hex bool) int {
+  if v.t.err != nil { return -1 }
+  symbol = strings.TrimSpace(symbol)
+  if len(symbol) == 0 || minValue > maxValue { v.t.err = ietools.ErrIllegalArguments; return -1 }
+  if value, ok := v.Value(symbol); ok { return value }
+
+  used := make(map[int]bool)
+  for _, e := range v.Entries() { used[e.Value] = true }
+
+  value, found := int(int32(preferred)), false
+  if preferred >= 0 && !used[value] {
+    found = true
+  } else {
+    for i := minValue; i <= maxValue; i++ {
+      if value = int(int32(i)); !used[value] { found = true; break }
+    }
+  }
+  if !found { v.t.err = fmt.Errorf("No free IDS value available for %s", symbol); return -1 }
+
+  v.Add(value, symbol, hex)
+  return value
+}
+
+
+// Used internally. Updates the row-count line to the current number of entries if available.
+func (v *IDS) updateCount() {
+  for row, items := range v.t.table {
+    if row > 1 { break }
+    if len(items) != 1 { continue }
+    if _, err := strconv.Atoi(items[0]); err == nil {
+      count := strconv.Itoa(v.Count())
+      if items[0] != count { v.t.table[row][0] = count }
+    }
+    break
+  }
+}
+
+// Used internally. Returns the IDS entry defined by the given row.
+func idsEntryOf(row []string) (IdsEntry, bool) {
+  if len(row) < 2 { return IdsEntry{}, false }
+  value, err := parseIdsValue(row[0])
+  if err != nil { return IdsEntry{}, false }
+  return IdsEntry{ value, strings.Join(row[1:], " ") }, true
+}
+
+// Used internally. Parses a decimal or hexadecimal (0x prefix) IDS value as signed 32-bit integer.
+func parseIdsValue(s string) (int, error) {
+  v, err := ietools.ParseNumber(s, 32)
+  return int(v), err
+}
@@ -0,0 +1,191 @@
+package tables
+
+import (
+  "bytes"
+  "strings"
+
+  "github.com/InfinityTools/go-ietools"
+  "golang.org/x/text/encoding"
+)
+
+// Original line content of a table row, used in lossless mode.
+type rowTrivia struct {
+  leading string    // preceding lines without table data, including line breaks
+  raw     string    // original line content without line break, empty for rows without original content
+  brk     string    // original line break, may be empty for the last line
+  orig    []string  // original row items, used to detect modifications
+}
+
+// A row of table data split into its components.
+type rowLayout struct {
+  prefix  string    // leading whitespace
+  items   []string
+  seps    []string  // whitespace following each item except the last one
+  suffix  string    // trailing whitespace
+}
+
+
+// Used internally. Parses a raw stream of bytes line by line, as required by lossless and quoted mode. Lines without
+// table data and the original formatting of rows are stored as trivia. Items are split in the same way as by the
+// default parser, regardless of OPTION_LOSSLESS.
+func (t *Table) importLines(data []byte, cm encoding.Encoding) {
+  t.table = make([][]string, 0)
+  t.trivia = make([]*rowTrivia, 0)
+  t.newline = ""
+
+  var leading strings.Builder
+  for pos := 0; pos < len(data); {
+    // determine line and line break
+    end := pos
+    for end < len(data) && !isLineBreak(data[end]) { end++ }
+    brkEnd := end
+    if brkEnd < len(data) {
+      if data[brkEnd] == '\r' && brkEnd + 1 < len(data) && data[brkEnd+1] == '\n' { brkEnd++ }
+      brkEnd++
+    }
+    brk := string(data[end:brkEnd])
+    if len(t.newline) == 0 && len(brk) > 0 && brk != "\f" && brk != "\v" { t.newline = brk }
+
+    line := decodeLine(data[pos:end], cm)
+    pos = brkEnd
+
+    layout := splitRow(line, t.options)
+    if len(layout.items) == 0 {
+      leading.WriteString(line)
+      leading.WriteString(brk)
+      continue
+    }
+    items := layout.values(t.options)
+    t.table = append(t.table, items)
+    t.trivia = append(t.trivia, &rowTrivia{ leading: leading.String(), raw: line, brk: brk,
+                                            orig: append([]string(nil), items...) })
+    leading.Reset()
+  }
+  t.tail = leading.String()
+  if len(t.newline) == 0 { t.newline = "\r\n" }
+}
+
+// Used internally. Converts the current table content into a raw stream of bytes in lossless mode.
+// cm is used to convert UTF-8 into ANSI. Specify nil to skip conversion.
+func (t *Table) exportLossless(cm encoding.Encoding) []byte {
+  var buf bytes.Buffer
+  var template *rowLayout
+  for row, items := range t.table {
+    tr := t.trivia[row]
+    if tr == nil { tr = &rowTrivia{} }
+    buf.Write(encodeLine(tr.leading, cm))
+
+    var line string
+    if len(tr.raw) > 0 {
+      layout := splitRow(tr.raw, t.options)
+      if equalItems(items, tr.orig) {
+        line = tr.raw
+      } else {
+        line = layout.format(items, t.options)
+      }
+      template = &layout
+    } else if template != nil && len(template.items) == len(items) {
+      line = template.format(items, t.options)
+    } else {
+      line = (&rowLayout{}).format(items, t.options)
+    }
+    buf.Write(encodeLine(line, cm))
+
+    brk := tr.brk
+    if len(brk) == 0 && (row + 1 < len(t.table) || len(t.tail) > 0) { brk = t.newline }
+    buf.WriteString(brk)
+  }
+  buf.Write(encodeLine(t.tail, cm))
+  return buf.Bytes()
+}
+
+// Used internally. Removes the trivia of the specified row. Preceding lines without table data are moved to the next
+// row.
+func (t *Table) removeTrivia(rowIndex int) {
+  tr := t.trivia[rowIndex]
+  t.trivia = append(t.trivia[:rowIndex], t.trivia[rowIndex+1:]...)
+  if tr == nil || len(tr.leading) == 0 { return }
+  if rowIndex < len(t.trivia) {
+    if t.trivia[rowIndex] == nil { t.trivia[rowIndex] = &rowTrivia{} }
+    t.trivia[rowIndex].leading = tr.leading + t.trivia[rowIndex].leading
+  } else {
+    t.tail = tr.leading + t.tail
+  }
+}
+
+// Used internally. Formats items based on the layout of the original row. Whitespace between items is adjusted to
+// preserve column alignment where possible. Items are quoted as needed if OPTION_QUOTED is set.
+func (l *rowLayout) format(items []string, options int) string {
+  var sb strings.Builder
+  sb.WriteString(l.prefix)
+  shift := 0  // accumulated difference between original and new item widths
+  for i, item := range items {
+    if (options & OPTION_QUOTED) != 0 { item = quoteItem(item) }
+    sb.WriteString(item)
+    if i + 1 == len(items) { break }
+
+    sep := " "
+    if len(l.seps) > 0 { sep = l.seps[len(l.seps) - 1] }
+    if i < len(l.seps) { sep = l.seps[i] }
+    if i < len(l.items) { shift += len(item) - len(l.items[i]) }
+    if strings.Trim(sep, " ") == "" && shift != 0 {
+      width := len(sep) - shift
+      if width < 1 { width = 1 }
+      shift -= len(sep) - width
+      sep = strings.Repeat(" ", width)
+    }
+    sb.WriteString(sep)
+  }
+  sb.WriteString(l.suffix)
+  return sb.String()
+}
+
+// Used internally. Splits a single line of text into whitespace and items. Quoted items are considered if
+// OPTION_QUOTED is set.
+func splitRow(line string, options int) rowLayout {
+  quoted := (options & OPTION_QUOTED) != 0
+  l := rowLayout{ items: make([]string, 0), seps: make([]string, 0) }
+  pos := 0
+  for pos < len(line) && isSpace(line[pos]) { pos++ }
+  l.prefix = line[:pos]
+  for pos < len(line) {
+    start := pos
+    pos = scanItem(line, pos, quoted)
+    l.items = append(l.items, line[start:pos])
+    start = pos
+    for pos < len(line) && isSpace(line[pos]) { pos++ }
+    if pos < len(line) {
+      l.seps = append(l.seps, line[start:pos])
+    } else {
+      l.suffix = line[start:pos]
+    }
+  }
+  if len(l.items) == 0 { l.prefix, l.suffix = "", line }
+  return l
+}
+
+// Used internally. Returns the item values of the row. Quoted items are unquoted if OPTION_QUOTED is set.
+func (l *rowLayout) values(options int) []string {
+  items := append([]string(nil), l.items...)
+  if (options & OPTION_QUOTED) != 0 {
+    for i := range items { items[i] = unquoteItem(items[i]) }
+  }
+  return items
+}
+
+// Used internally. Returns whether both item lists are equal.
+func equalItems(a, b []string) bool {
+  if len(a) != len(b) { return false }
+  for i := range a {
+    if a[i] != b[i] { return false }
+  }
+  return true
+}
+
+// Used internally. Converts a line of UTF-8 text into ANSI. Specify a nil encoding to skip conversion.
+func encodeLine(s string, cm encoding.Encoding) []byte {
+  if cm = ietools.NormalizeEncoding(cm); cm != nil {
+    if data, err := ietools.Utf8ToAnsi(s, cm); err == nil { return data }
+  }
+  return []byte(s)
+}
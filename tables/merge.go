@@ -0,0 +1,235 @@
+package tables
+
+import (
+  "fmt"
+  "strings"
+)
+
+// MergeConflict describes a change of a 2DA table that was made differently by both sides of a three-way merge.
+//
+// Cell conflicts provide both row label and column header. Conflicts between the removal of a row on one side and
+// modifications of the same row on the other side provide an empty Header. Likewise, conflicts involving the removal of
+// a column provide an empty Label. Conflicting default values provide neither. Values are empty if not available on
+// the respective side.
+type MergeConflict struct {
+  Label   string  // row label
+  Header  string  // column header
+  Base    string  // value of the common ancestor
+  Ours    string  // value of our side, which is used in the merged table
+  Theirs  string  // value of their side
+}
+
+const (
+  // Sides of a three-way merge
+  mergeOurs   = 0
+  mergeTheirs = 1
+)
+
+// Used internally. Provides indexed access to a 2DA table participating in a merge.
+type mergeSource struct {
+  v       *TwoDA
+  def     string
+  headers []string
+  labels  []string
+  cols    map[string]int  // uppercased header -> column index
+  rows    map[string]int  // uppercased label -> row index
+}
+
+// Used internally. An optional item value.
+type mergeValue struct {
+  s   string
+  ok  bool
+}
+
+
+// String returns a textual description of the conflict.
+func (c MergeConflict) String() string {
+  var loc string
+  switch {
+    case len(c.Label) > 0 && len(c.Header) > 0: loc = c.Label + "/" + c.Header
+    case len(c.Label) > 0:                      loc = "row " + c.Label
+    case len(c.Header) > 0:                     loc = "column " + c.Header
+    default:                                    loc = "default value"
+  }
+  return fmt.Sprintf("%s: base=%q, ours=%q, theirs=%q", loc, c.Base, c.Ours, c.Theirs)
+}
+
+// Merge performs a three-way merge of the 2DA tables ours and theirs, which were both derived from the common ancestor
+// base. Rows are matched by label and columns by header (both case-insensitive). Only the first row of duplicate
+// labels is considered.
+//
+// Changes made by only one side are applied to the merged table. This includes modified cells and default values as
+// well as added and removed rows and columns. Rows and columns added by their side are inserted after their preceding
+// row or column. Changes made differently by both sides are resolved in favor of ours and reported as conflicts.
+//
+// Returns the merged table and a list of conflicts. The merged table uses the character map of ours. Sets the error
+// state of the merged table if one of the tables has an error state or does not conform to the 2DA format. The error
+// state of the source tables is not affected.
+func Merge(base, ours, theirs *Table) (*Table, []MergeConflict) {
+  conflicts := make([]MergeConflict, 0)
+  result := Table{ table: make([][]string, 0), cmap: ours.cmap, dirty: true }
+  for _, t := range []*Table{ base, ours, theirs } {
+    if t.err != nil { result.err = t.err; return &result, conflicts }
+    if !t.Is2DA() { result.err = ErrNot2DA; return &result, conflicts }
+  }
+  b, o, th := newMergeSource(base), newMergeSource(ours), newMergeSource(theirs)
+
+  // default value
+  def, conflict := merge3(mergeValue{ b.def, true }, mergeValue{ o.def, true }, mergeValue{ th.def, true })
+  if conflict { conflicts = append(conflicts, MergeConflict{ "", "", b.def, o.def, th.def }) }
+
+  // columns and rows
+  sides := []*mergeSource{ o, th }
+  headers := mergeKeys(b.cols, th.cols, o.headers, th.headers, func(side int, key string) bool {
+    return sides[side].columnChanged(b, key)
+  }, func(key string) {
+    conflicts = append(conflicts, MergeConflict{ "", key, key, presence(o.cols, key), presence(th.cols, key) })
+  })
+  labels := mergeKeys(b.rows, th.rows, o.labels, th.labels, func(side int, key string) bool {
+    return sides[side].rowChanged(b, key)
+  }, func(key string) {
+    conflicts = append(conflicts, MergeConflict{ key, "", key, presence(o.rows, key), presence(th.rows, key) })
+  })
+
+  // cells
+  result.table = append(result.table, []string{ "2DA", "V1.0" }, []string{ def.s }, headers)
+  for _, label := range labels {
+    items := make([]string, 0, len(headers) + 1)
+    items = append(items, label)
+    for _, header := range headers {
+      vb := b.get(label, header)
+      vo, vt := o.getOrBase(b, label, header), th.getOrBase(b, label, header)
+      v, conflict := merge3(vb, vo, vt)
+      if conflict { conflicts = append(conflicts, MergeConflict{ label, header, vb.s, vo.s, vt.s }) }
+      if !v.ok || len(v.s) == 0 { v.s = def.s }
+      items = append(items, v.s)
+    }
+    result.table = append(result.table, items)
+  }
+  return &result, conflicts
+}
+
+
+// Used internally. Creates an indexed representation of the given 2DA table.
+func newMergeSource(t *Table) *mergeSource {
+  v := t.As2DA()
+  src := mergeSource{ v: v, def: v.Default(), headers: make([]string, 0), labels: make([]string, 0),
+                      cols: make(map[string]int), rows: make(map[string]int) }
+  for i, h := range v.Headers() {
+    key := strings.ToUpper(h)
+    if _, ok := src.cols[key]; ok { continue }
+    src.cols[key] = i
+    src.headers = append(src.headers, h)
+  }
+  for i, l := range v.Labels() {
+    key := strings.ToUpper(l)
+    if _, ok := src.rows[key]; ok { continue }
+    src.rows[key] = i
+    src.labels = append(src.labels, l)
+  }
+  return &src
+}
+
+// Used internally. Returns the value at the specified row label and column header if available.
+func (src *mergeSource) get(label, header string) mergeValue {
+  row, ok1 := src.rows[strings.ToUpper(label)]
+  col, ok2 := src.cols[strings.ToUpper(header)]
+  if !ok1 || !ok2 { return mergeValue{} }
+  return mergeValue{ src.v.cell(row, col), true }
+}
+
+// Used internally. Returns the value at the specified row label and column header. Falls back to the value of base if
+// the row or column has been removed from src.
+func (src *mergeSource) getOrBase(base *mergeSource, label, header string) mergeValue {
+  _, inBase := base.rows[strings.ToUpper(label)]
+  _, inSrc := src.rows[strings.ToUpper(label)]
+  if inBase && !inSrc { return base.get(label, header) }
+  _, inBase = base.cols[strings.ToUpper(header)]
+  _, inSrc = src.cols[strings.ToUpper(header)]
+  if inBase && !inSrc { return base.get(label, header) }
+  return src.get(label, header)
+}
+
+// Used internally. Returns whether src modified any value of the specified row compared to base.
+func (src *mergeSource) rowChanged(base *mergeSource, label string) bool {
+  for _, header := range base.headers {
+    if _, ok := src.cols[strings.ToUpper(header)]; !ok { continue }
+    if src.get(label, header) != base.get(label, header) { return true }
+  }
+  return false
+}
+
+// Used internally. Returns whether src modified any value of the specified column compared to base.
+func (src *mergeSource) columnChanged(base *mergeSource, header string) bool {
+  for _, label := range base.labels {
+    if _, ok := src.rows[strings.ToUpper(label)]; !ok { continue }
+    if src.get(label, header) != base.get(label, header) { return true }
+  }
+  return false
+}
+
+// Used internally. Performs a three-way merge of a single value. Returns the merged value and whether both sides
+// changed the value differently, in which case the value of ours is returned.
+func merge3(base, ours, theirs mergeValue) (mergeValue, bool) {
+  if ours == theirs { return ours, false }
+  if ours == base { return theirs, false }
+  if theirs == base { return ours, false }
+  return ours, true
+}
+
+// Used internally. Merges the row labels or column headers of all sides. Keys removed by one side while the other
+// side modified associated values are reported by onConflict and resolved in favor of ours. changed reports whether
+// a side modified values associated with a key.
+func mergeKeys(b, th map[string]int, ours, theirs []string, changed func(side int, key string) bool,
+               onConflict func(key string)) []string {
+  keys := make([]string, 0, len(ours) + len(theirs))
+  index := make(map[string]bool)
+
+  for _, key := range ours {
+    upper := strings.ToUpper(key)
+    _, inBase := b[upper]
+    _, inTheirs := th[upper]
+    if inBase && !inTheirs {
+      // removed by theirs
+      if !changed(mergeOurs, key) { continue }
+      onConflict(key)
+    }
+    keys = append(keys, key)
+    index[upper] = true
+  }
+
+  for i, key := range theirs {
+    upper := strings.ToUpper(key)
+    if index[upper] { continue }
+    _, inBase := b[upper]
+    if inBase {
+      // removed by ours
+      if changed(mergeTheirs, key) { onConflict(key) }
+      continue
+    }
+    pos := 0
+    for j := i - 1; j >= 0; j-- {
+      if index[strings.ToUpper(theirs[j])] {
+        pos = indexOfKey(keys, theirs[j]) + 1
+        break
+      }
+    }
+    keys = insertString(keys, pos, key)
+    index[upper] = true
+  }
+  return keys
+}
+
+// Used internally. Returns the index of key in keys (case-insensitive), or -1 if not found.
+func indexOfKey(keys []string, key string) int {
+  for i, k := range keys {
+    if strings.EqualFold(k, key) { return i }
+  }
+  return -1
+}
+
+// Used internally. Returns the key if it exists in the given map, an empty string otherwise.
+func presence(m map[string]int, key string) string {
+  if _, ok := m[strings.ToUpper(key)]; ok { return key }
+  return ""
+}
@@ -0,0 +1,273 @@
+package tables
+
+import (
+  "fmt"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+)
+
+// Query provides filter, projection, sort and join operations on 2DA table content. Operations can be chained and
+// do not modify the source tables. Use function Table to create a new 2DA table from the result.
+//
+// Columns are identified by their headers (case-insensitive). Specify an empty header to refer to the row labels.
+// Missing cells of the source tables are filled with their default values.
+type Query struct {
+  def     string      // default value of the resulting table
+  headers []string    // column headers
+  rows    [][]string  // row label followed by one item per column header
+  err     error
+}
+
+// Row provides access to a single row of a query, e.g. for filter predicates.
+type Row struct {
+  q     *Query
+  items []string
+}
+
+
+// From returns a new query over the content of the given 2DA table.
+//
+// Sets the error state of the query to ErrNot2DA if the table does not conform to the 2DA format. The error state of
+// the table is not affected.
+func From(t *Table) *Query {
+  q := Query{ headers: make([]string, 0), rows: make([][]string, 0) }
+  if t.err != nil { q.err = t.err; return &q }
+  if !t.Is2DA() { q.err = ErrNot2DA; return &q }
+
+  v := t.As2DA()
+  q.def = v.Default()
+  q.headers = v.Headers()
+  for row := range v.data() {
+    items := make([]string, len(q.headers) + 1)
+    items[0] = t.table[row2DAData + row][0]
+    for col := range q.headers { items[col + 1] = v.cell(row, col) }
+    q.rows = append(q.rows, items)
+  }
+  return &q
+}
+
+// Error returns the error state of the query.
+func (q *Query) Error() error {
+  return q.err
+}
+
+// Headers returns the column headers of the query result.
+func (q *Query) Headers() []string {
+  return append([]string(nil), q.headers...)
+}
+
+// Rows returns the number of rows of the query result.
+func (q *Query) Rows() int {
+  return len(q.rows)
+}
+
+// Where returns a new query containing only rows that match the given predicate.
+// Operation is skipped if error state is set.
+func (q *Query) Where(pred func(r Row) bool) *Query {
+  if q.err != nil { return q }
+  result := q.derive(q.headers)
+  for _, items := range q.rows {
+    if pred(Row{ q, items }) { result.rows = append(result.rows, items) }
+  }
+  return result
+}
+
+// WhereMatch returns a new query containing only rows where the item of the specified column matches the regular
+// expression expr.
+//
+// Sets error state if the column does not exist or expr is not a valid regular expression.
+// Operation is skipped if error state is set.
+func (q *Query) WhereMatch(header, expr string) *Query {
+  if q.err != nil { return q }
+  col := q.column(header)
+  if col < 0 { return q.fail(fmt.Errorf("Unknown column: %s", header)) }
+  re, err := regexp.Compile(expr)
+  if err != nil { return q.fail(err) }
+  return q.Where(func(r Row) bool { return re.MatchString(r.items[col]) })
+}
+
+// Select returns a new query containing only the specified columns in the given order. Row labels are always
+// retained.
+//
+// Sets error state if a column does not exist. Operation is skipped if error state is set.
+func (q *Query) Select(headers ...string) *Query {
+  if q.err != nil { return q }
+  cols := make([]int, len(headers))
+  for i, h := range headers {
+    cols[i] = q.column(h)
+    if cols[i] <= 0 { return q.fail(fmt.Errorf("Unknown column: %s", h)) }
+  }
+
+  result := q.derive(make([]string, len(cols)))
+  for i, col := range cols { result.headers[i] = q.headers[col - 1] }
+  for _, items := range q.rows {
+    row := make([]string, len(cols) + 1)
+    row[0] = items[0]
+    for i, col := range cols { row[i + 1] = items[col] }
+    result.rows = append(result.rows, row)
+  }
+  return result
+}
+
+// SortBy returns a new query with rows sorted by the specified column. Items are compared numerically if both are
+// numbers and case-insensitively otherwise. The sort is stable.
+//
+// Sets error state if the column does not exist. Operation is skipped if error state is set.
+func (q *Query) SortBy(header string, descending bool) *Query {
+  if q.err != nil { return q }
+  col := q.column(header)
+  if col < 0 { return q.fail(fmt.Errorf("Unknown column: %s", header)) }
+
+  result := q.derive(q.headers)
+  result.rows = append(result.rows, q.rows...)
+  sort.SliceStable(result.rows, func(i, j int) bool {
+    c := compareItems(result.rows[i][col], result.rows[j][col])
+    if descending { return c > 0 }
+    return c < 0
+  })
+  return result
+}
+
+// Join returns a new query that combines each row with all rows of other where the item of column leftKey equals the
+// item of column rightKey of other (case-insensitive). Rows without a match are discarded.
+//
+// The resulting columns consist of the columns of q followed by the columns of other, except for rightKey. Duplicate
+// column headers of other are made unique by appending "_2", "_3" and so on.
+//
+// Sets error state if a column does not exist. Operation is skipped if error state is set.
+func (q *Query) Join(other *Query, leftKey, rightKey string) *Query {
+  return q.join(other, leftKey, rightKey, false)
+}
+
+// LeftJoin works like Join, but retains rows without a match. Missing items are filled with the default value of
+// other.
+//
+// Sets error state if a column does not exist. Operation is skipped if error state is set.
+func (q *Query) LeftJoin(other *Query, leftKey, rightKey string) *Query {
+  return q.join(other, leftKey, rightKey, true)
+}
+
+// Table returns a new 2DA table containing the query result.
+//
+// Sets the error state of the returned table if the query is in an invalid state.
+func (q *Query) Table() *Table {
+  t := Table{ table: make([][]string, 0, len(q.rows) + 3) }
+  if q.err != nil { t.err = q.err; return &t }
+
+  def := q.def
+  if len(def) == 0 { def = "*" }
+  t.table = append(t.table, []string{ "2DA", "V1.0" }, []string{ def }, append([]string(nil), q.headers...))
+  for _, items := range q.rows {
+    row := make([]string, len(items))
+    for i, item := range items {
+      if len(item) == 0 { item = def }
+      row[i] = item
+    }
+    t.table = append(t.table, row)
+  }
+  t.dirty = true
+  return &t
+}
+
+
+// Label returns the row label.
+func (r Row) Label() string {
+  return r.items[0]
+}
+
+// Get returns the item of the specified column. Returns an empty string if the column does not exist.
+func (r Row) Get(header string) string {
+  if col := r.q.column(header); col >= 0 { return r.items[col] }
+  return ""
+}
+
+
+// Used internally. Returns a new empty query with the same default value and the given headers.
+func (q *Query) derive(headers []string) *Query {
+  return &Query{ def: q.def, headers: append([]string(nil), headers...), rows: make([][]string, 0, len(q.rows)) }
+}
+
+// Used internally. Returns a copy of the query with the specified error state.
+func (q *Query) fail(err error) *Query {
+  result := q.derive(q.headers)
+  result.err = err
+  return result
+}
+
+// Used internally. Returns the item index of the specified column. Index 0 refers to the row label. Returns -1 if the
+// column does not exist.
+func (q *Query) column(header string) int {
+  if len(header) == 0 { return 0 }
+  for i, h := range q.headers {
+    if strings.EqualFold(h, header) { return i + 1 }
+  }
+  return -1
+}
+
+// Used internally. Performs an inner or left join.
+func (q *Query) join(other *Query, leftKey, rightKey string, keepAll bool) *Query {
+  if q.err != nil { return q }
+  if other.err != nil { return q.fail(other.err) }
+  lcol, rcol := q.column(leftKey), other.column(rightKey)
+  if lcol < 0 { return q.fail(fmt.Errorf("Unknown column: %s", leftKey)) }
+  if rcol < 0 { return q.fail(fmt.Errorf("Unknown column: %s", rightKey)) }
+
+  // columns of other, excluding the key column
+  cols := make([]int, 0, len(other.headers))
+  headers := append([]string(nil), q.headers...)
+  for i, h := range other.headers {
+    if i + 1 == rcol { continue }
+    name := h
+    for n := 2; q.hasHeader(headers, name); n++ { name = h + "_" + strconv.Itoa(n) }
+    headers = append(headers, name)
+    cols = append(cols, i + 1)
+  }
+
+  index := make(map[string][]int)
+  for i, items := range other.rows {
+    key := strings.ToUpper(items[rcol])
+    index[key] = append(index[key], i)
+  }
+
+  result := q.derive(headers)
+  for _, items := range q.rows {
+    matches := index[strings.ToUpper(items[lcol])]
+    if len(matches) == 0 && keepAll { matches = []int{ -1 } }
+    for _, m := range matches {
+      row := append(make([]string, 0, len(headers) + 1), items...)
+      for _, col := range cols {
+        if m < 0 {
+          row = append(row, other.def)
+        } else {
+          row = append(row, other.rows[m][col])
+        }
+      }
+      result.rows = append(result.rows, row)
+    }
+  }
+  return result
+}
+
+// Used internally. Returns whether headers contains name (case-insensitive).
+func (q *Query) hasHeader(headers []string, name string) bool {
+  for _, h := range headers {
+    if strings.EqualFold(h, name) { return true }
+  }
+  return false
+}
+
+// Used internally. Compares two items numerically if possible and case-insensitively otherwise.
+func compareItems(a, b string) int {
+  fa, errA := parseFloat(a)
+  fb, errB := parseFloat(b)
+  if errA == nil && errB == nil {
+    switch {
+      case fa < fb: return -1
+      case fa > fb: return 1
+      default:      return 0
+    }
+  }
+  return strings.Compare(strings.ToUpper(a), strings.ToUpper(b))
+}
@@ -0,0 +1,83 @@
+package tables
+
+import (
+  "strings"
+)
+
+// Used internally. Returns the end position of the item starting at pos. Items starting with a double quote end at
+// the matching unescaped double quote if quoted is set.
+func scanItem(line string, pos int, quoted bool) int {
+  if quoted && pos < len(line) && line[pos] == '"' {
+    for pos++; pos < len(line); pos++ {
+      switch line[pos] {
+        case '\\':
+          pos++
+        case '"':
+          return pos + 1
+      }
+    }
+    return len(line)
+  }
+  for pos < len(line) && !isSpace(line[pos]) { pos++ }
+  return pos
+}
+
+// Used internally. Returns item in double quotes with escaped special characters if it is empty or contains
+// whitespace, double quotes or backslashes. Returns the unmodified item otherwise. Line break characters are always
+// escaped, since lines are split before quoted items are parsed.
+func quoteItem(item string) string {
+  if len(item) > 0 && !strings.ContainsAny(item, " \t\a\b\f\n\r\v\"\\") { return item }
+
+  var sb strings.Builder
+  sb.WriteByte('"')
+  for i := 0; i < len(item); i++ {
+    switch c := item[i]; c {
+      case '"', '\\':
+        sb.WriteByte('\\')
+        sb.WriteByte(c)
+      case '\n':
+        sb.WriteString("\\n")
+      case '\r':
+        sb.WriteString("\\r")
+      case '\t':
+        sb.WriteString("\\t")
+      case '\f':
+        sb.WriteString("\\f")
+      case '\v':
+        sb.WriteString("\\v")
+      default:
+        sb.WriteByte(c)
+    }
+  }
+  sb.WriteByte('"')
+  return sb.String()
+}
+
+// Used internally. Removes double quotes and resolves escape sequences of a quoted item. Returns the unmodified item
+// if it is not quoted.
+func unquoteItem(item string) string {
+  if len(item) == 0 || item[0] != '"' { return item }
+
+  var sb strings.Builder
+  for i := 1; i < len(item); i++ {
+    c := item[i]
+    if c == '"' { break }
+    if c != '\\' || i + 1 >= len(item) { sb.WriteByte(c); continue }
+    i++
+    switch item[i] {
+      case 'n':
+        sb.WriteByte('\n')
+      case 'r':
+        sb.WriteByte('\r')
+      case 't':
+        sb.WriteByte('\t')
+      case 'f':
+        sb.WriteByte('\f')
+      case 'v':
+        sb.WriteByte('\v')
+      default:
+        sb.WriteByte(item[i])
+    }
+  }
+  return sb.String()
+}
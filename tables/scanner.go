@@ -0,0 +1,147 @@
+package tables
+
+import (
+  "io"
+
+  "github.com/InfinityTools/go-ietools"
+  "golang.org/x/text/encoding"
+)
+
+const (
+  scanBufferSize = 65536
+)
+
+// Scanner reads table data row by row from an io.Reader in a single pass, without loading the whole input into memory.
+// Lines without items are skipped.
+//
+// Successive calls to the Scan function step through the rows of the input. Scanning stops at the end of the input or
+// at the first read error.
+type Scanner struct {
+  r       io.Reader
+  cmap    encoding.Encoding // the character map or encoding to be used for ANSI decoding, may be nil
+  buf     []byte            // read buffer
+  pos     int               // current position in buf
+  end     int               // end of valid data in buf
+  eof     bool              // true if the underlying reader has no more data
+  skipLF  bool              // true if the preceding line ended with a carriage return
+  line    int               // number of lines read so far
+  token   []byte            // item data of the current item
+  row     []string          // items of the current row
+  cols    []int             // columns of the items of the current row
+  rowLine int               // line number of the current row
+  err     error
+}
+
+
+// NewScanner returns a new Scanner to read table data from r, using the specified character map or encoding for ANSI
+// decoding. Specify a nil encoding to skip the decoding operation.
+func NewScanner(r io.Reader, cmap encoding.Encoding) *Scanner {
+  s := Scanner{ r: r, cmap: ietools.NormalizeEncoding(cmap), buf: make([]byte, scanBufferSize), token: make([]byte, 0, 64) }
+  return &s
+}
+
+// Scan advances the Scanner to the next row containing items. Returns false if no more rows are available or a read
+// error occurred.
+func (s *Scanner) Scan() bool {
+  s.row, s.cols = nil, nil
+  for s.err == nil {
+    row := make([]string, 0, len(s.row))
+    cols := make([]int, 0, len(s.cols))
+    col, inToken, lineEnded, hasData := 1, false, false, false
+
+    for {
+      if s.pos >= s.end && !s.fill() { break }
+      c := s.buf[s.pos]
+      s.pos++
+      hasData = true
+      if s.skipLF {
+        s.skipLF = false
+        if c == '\n' { continue }
+      }
+      if isLineBreak(c) {
+        s.skipLF = (c == '\r')
+        lineEnded = true
+        break
+      }
+      if isSpace(c) {
+        if inToken {
+          row = append(row, decodeLine(s.token, s.cmap))
+          inToken = false
+        }
+      } else {
+        if !inToken {
+          s.token = s.token[:0]
+          cols = append(cols, col)
+          inToken = true
+        }
+        s.token = append(s.token, c)
+      }
+      col++
+    }
+    if inToken { row = append(row, decodeLine(s.token, s.cmap)) }
+
+    if !hasData { return false }
+    s.line++
+    if len(row) > 0 {
+      s.row, s.cols, s.rowLine = row, cols, s.line
+      return true
+    }
+    if !lineEnded { return false }
+  }
+  return false
+}
+
+// Row returns the items of the current row. The returned slice is not modified by subsequent calls of Scan.
+func (s *Scanner) Row() []string {
+  return s.row
+}
+
+// Line returns the line number of the current row, starting at 1.
+func (s *Scanner) Line() int {
+  return s.rowLine
+}
+
+// Columns returns the column positions of the items of the current row in bytes, starting at 1.
+func (s *Scanner) Columns() []int {
+  return s.cols
+}
+
+// Err returns the first read error that occurred while scanning. Returns nil if the end of input has been reached
+// without error.
+func (s *Scanner) Err() error {
+  return s.err
+}
+
+
+// Used internally. Fills the read buffer with new data. Returns false if no more data is available.
+func (s *Scanner) fill() bool {
+  for !s.eof {
+    n, err := s.r.Read(s.buf)
+    s.pos, s.end = 0, n
+    if err == io.EOF {
+      s.eof = true
+    } else if err != nil {
+      s.err, s.eof = err, true
+    }
+    if n > 0 { return true }
+  }
+  return false
+}
+
+// Used internally. Returns whether c separates table rows.
+func isLineBreak(c byte) bool {
+  return c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// Used internally. Returns whether c separates table columns.
+func isSpace(c byte) bool {
+  return c == ' ' || c == '\t' || c == '\a' || c == '\b'
+}
+
+// Used internally. Converts ANSI text into UTF-8. Specify a nil encoding to skip conversion.
+func decodeLine(data []byte, cm encoding.Encoding) string {
+  if cm = ietools.NormalizeEncoding(cm); cm != nil {
+    if s, err := ietools.AnsiToUtf8(data, cm); err == nil { return s }
+  }
+  return string(data)
+}
@@ -0,0 +1,152 @@
+package tables
+
+import (
+  "bytes"
+  "fmt"
+  "reflect"
+  "regexp"
+  "strings"
+  "testing"
+)
+
+// Generates a 2DA table with the given number of rows in text form.
+func generate2DA(rows int) []byte {
+  var buf bytes.Buffer
+  buf.WriteString("2DA V1.0\r\n****\r\n        NAME      VALUE     FLAGS     RESREF\r\n")
+  for i := 0; i < rows; i++ {
+    fmt.Fprintf(&buf, "ROW_%-6d NAME_%-5d %-9d 0x%04x    RES%05d\r\n", i, i % 97, i * 3, i & 0xffff, i)
+  }
+  return buf.Bytes()
+}
+
+// The regexp-based table importer replaced by the Scanner type. Used as benchmark and reference.
+func regexpImportTable(data []byte) [][]string {
+  table := make([][]string, 0)
+  for pos := 0; pos < len(data); {
+    var line []string
+    line, pos = regexpImportRow(data, pos)
+    if len(line) > 0 {
+      table = append(table, line)
+    }
+  }
+  return table
+}
+
+func regexpImportRow(data []byte, startPos int) (line []string, newPos int) {
+  line = make([]string, 0)
+  newPos = startPos
+
+  const MODE_EMPTY = 0
+  const MODE_SPACE = 1
+  const MODE_TOKEN = 2
+
+  regNewline := regexp.MustCompile("[\f\n\r\v]")
+  regSpace := regexp.MustCompile("[\a\b\t ]")
+  regToken := regexp.MustCompile("[^\f\n\r\v\a\b\t ]")
+
+  mode := MODE_SPACE
+  posToken := -1
+  for pos := startPos; pos < len(data) && mode != MODE_EMPTY; pos++ {
+    switch mode {
+    case MODE_SPACE:
+      if regToken.Match(data[pos:pos+1]) {
+        posToken = pos
+        mode = MODE_TOKEN
+      } else if regNewline.Match(data[pos:pos+1]) {
+        newPos = pos
+        mode = MODE_EMPTY
+      }
+    case MODE_TOKEN:
+      if regSpace.Match(data[pos:pos+1]) {
+        line = append(line, string(data[posToken:pos]))
+        posToken = -1
+        mode = MODE_SPACE
+      } else if regNewline.Match(data[pos:pos+1]) {
+        newPos = pos
+        mode = MODE_EMPTY
+      }
+    }
+  }
+  if mode != MODE_EMPTY { newPos = len(data) }
+  if posToken >= 0 { line = append(line, string(data[posToken:newPos])) }
+  if mode == MODE_EMPTY { newPos++ }
+  return
+}
+
+// Scans the given text and returns rows, line numbers and column positions.
+func scanAll(t *testing.T, text string) ([][]string, []int, [][]int) {
+  var rows [][]string
+  var lines []int
+  var cols [][]int
+  s := NewScanner(strings.NewReader(text), nil)
+  for s.Scan() {
+    rows = append(rows, s.Row())
+    lines = append(lines, s.Line())
+    cols = append(cols, s.Columns())
+  }
+  if s.Err() != nil { t.Fatal(s.Err()) }
+  return rows, lines, cols
+}
+
+
+func TestScannerLineBreaks(t *testing.T) {
+  expected := [][]string{ { "2DA", "V1.0" }, { "****" }, { "A", "B" }, { "X", "1", "2" } }
+  for name, br := range map[string]string{ "LF": "\n", "CRLF": "\r\n", "CR": "\r" } {
+    text := strings.Join([]string{ "2DA V1.0", "****", "  A  B", "X 1 2" }, br) + br
+    rows, lines, _ := scanAll(t, text)
+    if !reflect.DeepEqual(rows, expected) { t.Errorf("%s: rows = %q", name, rows) }
+    if !reflect.DeepEqual(lines, []int{ 1, 2, 3, 4 }) { t.Errorf("%s: lines = %v", name, lines) }
+  }
+}
+
+func TestScannerLineNumbers(t *testing.T) {
+  text := "first\r\n\r\n   \n\rsecond\n\n\fthird\vfourth"
+  rows, lines, _ := scanAll(t, text)
+  if !reflect.DeepEqual(rows, [][]string{ { "first" }, { "second" }, { "third" }, { "fourth" } }) {
+    t.Fatalf("rows = %q", rows)
+  }
+  if !reflect.DeepEqual(lines, []int{ 1, 5, 8, 9 }) { t.Fatalf("lines = %v", lines) }
+}
+
+func TestScannerColumns(t *testing.T) {
+  rows, _, cols := scanAll(t, "A  BB\tC\n   x   yy\n")
+  if !reflect.DeepEqual(rows, [][]string{ { "A", "BB", "C" }, { "x", "yy" } }) { t.Fatalf("rows = %q", rows) }
+  if !reflect.DeepEqual(cols, [][]int{ { 1, 4, 7 }, { 4, 8 } }) { t.Fatalf("columns = %v", cols) }
+}
+
+func TestScannerLongLines(t *testing.T) {
+  long := strings.Repeat("x", scanBufferSize + 1000)
+  items := make([]string, 0)
+  for i := 0; i < 3 * scanBufferSize / 10; i++ { items = append(items, fmt.Sprintf("%09d", i)) }
+  text := "head\n" + long + " tail\n" + strings.Join(items, " ") + "\nlast"
+  rows, lines, cols := scanAll(t, text)
+  if len(rows) != 4 { t.Fatalf("rows = %d", len(rows)) }
+  if !reflect.DeepEqual(rows[1], []string{ long, "tail" }) { t.Fatal("long item mismatch") }
+  if !reflect.DeepEqual(cols[1], []int{ 1, len(long) + 2 }) { t.Fatalf("columns = %v", cols[1]) }
+  if !reflect.DeepEqual(rows[2], items) { t.Fatal("long row mismatch") }
+  if !reflect.DeepEqual(rows[3], []string{ "last" }) || !reflect.DeepEqual(lines, []int{ 1, 2, 3, 4 }) {
+    t.Fatalf("last row = %q, lines = %v", rows[3], lines)
+  }
+}
+
+func TestScannerMatchesRegexpImporter(t *testing.T) {
+  data := generate2DA(500)
+  data = append(data, "\n\a\bA\t\f B\v\r\r\n C  "...)
+  table, err := importTable(bytes.NewReader(data), nil)
+  if err != nil { t.Fatal(err) }
+  if expected := regexpImportTable(data); !reflect.DeepEqual(table, expected) { t.Fatal("table mismatch") }
+}
+
+func BenchmarkLoad(b *testing.B) {
+  data := generate2DA(5000)
+  b.Run("Regexp", func(b *testing.B) {
+    b.SetBytes(int64(len(data)))
+    for i := 0; i < b.N; i++ { regexpImportTable(data) }
+  })
+  b.Run("Scanner", func(b *testing.B) {
+    b.SetBytes(int64(len(data)))
+    for i := 0; i < b.N; i++ {
+      if t := Load(bytes.NewReader(data)); t.Error() != nil { b.Fatal(t.Error()) }
+    }
+  })
+}
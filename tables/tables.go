@@ -6,20 +6,34 @@ package tables
 import (
   "bytes"
   "io"
-  "strconv"
+  "io/ioutil"
   "strings"
-  "regexp"
 
   "github.com/InfinityTools/go-ietools"
+  "golang.org/x/text/encoding"
   "golang.org/x/text/encoding/charmap"
 )
 
+const (
+  // Available table options
+  OPTION_LOSSLESS   = ietools.BIT0  // Preserve blank lines, spacing and line breaks of unmodified rows
+  OPTION_QUOTED     = ietools.BIT1  // Parse and emit "quoted values" with backslash escapes
+  OPTION_NORMALIZE  = ietools.BIT2  // Fix structural 2DA defects when saving (see Normalize2DA)
+)
+
 // Table contains the necessary information to query or alter table data.
 type Table struct {
-  table [][]string        // a two-dimensional array[row][col] to store table data
-  cmap  *charmap.Charmap  // the character map to be used for ANSI decoding or encoding
-  dirty bool              // true if content has been modified
-  err   error
+  table   [][]string              // a two-dimensional array[row][col] to store table data
+  cmap    encoding.Encoding       // the character map or encoding to be used for ANSI decoding or encoding
+  later   map[string][]laterEntry // pending SET_2DA_ENTRY_LATER operations
+  options int                     // parser and output options (see OPTION_xxx constants)
+  trivia  []*rowTrivia            // original line content of each row, only used in lossless mode
+  tail    string                  // trailing lines without table data, only used in lossless mode
+  newline string                  // line break style for new rows, only used in lossless mode
+  pretty  bool                    // true if table should always be saved in prettified form
+  bom     bool                    // true if UTF-8 data should be saved with byte order mark
+  dirty   bool                    // true if content has been modified
+  err     error
 }
 
 // Load uses the given Reader to load table data from the underlying buffer. The function returns a pointer to the Table object.
@@ -30,28 +44,27 @@ func Load(r io.Reader) *Table {
   return LoadEx(r, charmap.Windows1252)
 }
 
-// LoadEx uses the given Reader to load table data from the underlying buffer, using the specified character map for ANSI decoding.
+// LoadEx uses the given Reader to load table data from the underlying buffer, using the specified character map or
+// encoding for ANSI decoding. Use ietools.EncodingByLanguage for the multi-byte encodings of Asian releases.
 //
-// Specify a nil charmap to skip the decoding operation. The function returns a pointer to the Table object.
+// Specify a nil encoding to skip the decoding operation. A leading UTF-8 byte order mark is skipped in this case and
+// retained when the table is saved. options is an optional combination of OPTION_xxx flags which are also applied when
+// the table is saved. The function returns a pointer to the Table object.
 // Use function Error to check if the Load function returned successfully.
-func LoadEx(r io.Reader, cmap *charmap.Charmap) *Table {
-  table := Table { nil, nil, false, nil }
-
-  buf := make([]byte, 1024)
-  totalRead, bytesRead := 0, 0
-  var err error
-  for {
-    bytesRead, err = r.Read(buf[totalRead:])
-    totalRead += bytesRead
-    if err != nil { break }
-    if totalRead >= len(buf) {
-      buf = append(buf, make([]byte, len(buf))...)
-    }
+func LoadEx(r io.Reader, cmap encoding.Encoding, options ...int) *Table {
+  cmap = ietools.NormalizeEncoding(cmap)
+  table := Table{ cmap: cmap }
+  for _, o := range options { table.options |= o }
+  if cmap == nil { r, table.bom = stripBOM(r) }
+
+  if (table.options & (OPTION_LOSSLESS | OPTION_QUOTED)) != 0 {
+    buf, err := ioutil.ReadAll(r)
+    if err != nil { table.err = err; return &table }
+    table.importLines(buf, cmap)
+    if (table.options & OPTION_LOSSLESS) == 0 { table.trivia, table.tail = nil, "" }
+  } else {
+    table.table, table.err = importTable(r, cmap)
   }
-  if err != nil && err != io.EOF { table.err = err; return &table }
-  if totalRead < len(buf) { buf = buf[:totalRead] }
-  table.table = importTable(buf, cmap)
-  table.cmap = cmap
   return &table
 }
 
@@ -63,14 +76,33 @@ func (t *Table) Save(w io.Writer, prettify bool) {
   t.SaveEx(w, t.cmap, prettify)
 }
 
-// SaveEx writes the current table content to the specified Writer, using the specified character map for ANSI encoding.
+// SaveEx writes the current table content to the specified Writer, using the specified character map or encoding for
+// ANSI encoding.
 //
-// Specify a nil charmap to skip the encoding operation. Does nothing if the Table is in an invalid state (see Error function).
-// Set prettify to ensure that table data is properly aligned.
-func (t *Table) SaveEx(w io.Writer, cmap *charmap.Charmap, prettify bool) {
+// Specify a nil encoding to skip the encoding operation. A UTF-8 byte order mark is written in this case if the table
+// has been loaded with one or SetUtf8 has been called accordingly.
+// Does nothing if the Table is in an invalid state (see Error function).
+// Set prettify to ensure that table data is properly aligned. In lossless mode (see OPTION_LOSSLESS) only new or
+// modified rows are formatted, unless prettify is set, which discards the original formatting. Structural 2DA defects
+// are fixed in the saved data if OPTION_NORMALIZE is set. The table content itself is not normalized.
+func (t *Table) SaveEx(w io.Writer, cmap encoding.Encoding, prettify bool) {
   if t.err != nil { return }
+  cmap = ietools.NormalizeEncoding(cmap)
+  src := t
+  if (t.options & OPTION_NORMALIZE) != 0 {
+    // table content is not affected
+    src = t.clone()
+    src.Normalize2DA()
+  }
 
-  _, err := w.Write(t.exportTable(true, prettify, cmap))
+  var data []byte
+  if cmap == nil && src.bom { data = append(data, bomUtf8...) }
+  if (src.options & OPTION_LOSSLESS) != 0 && src.trivia != nil && !prettify && !src.pretty {
+    data = append(data, src.exportLossless(cmap)...)
+  } else {
+    data = append(data, src.exportTable(true, prettify || src.pretty, cmap)...)
+  }
+  _, err := w.Write(data)
   if err != nil { t.err = err; return }
   t.dirty = false
 }
@@ -86,6 +118,17 @@ func (t *Table) ClearError() {
   t.err = nil
 }
 
+// GetOptions returns the table options as a combination of OPTION_xxx flags.
+func (t *Table) GetOptions() int {
+  return t.options
+}
+
+// SetOptions defines the table options as a combination of OPTION_xxx flags. Options affecting the parser are only
+// considered by subsequent operations.
+func (t *Table) SetOptions(options int) {
+  t.options = options
+}
+
 // IsModified returns whether the current table content has been modified by a previous operation.
 // The return value is only provided for informal purposes. None of the Table functions rely on it.
 func (t *Table) IsModified() bool {
@@ -144,8 +187,7 @@ func (t *Table) Rows(cols int) int {
 // Operation is skipped if error state is set.
 func (t *Table) Is2DA() bool {
   if t.err != nil { return false }
-  if t.Columns() < 2 { return false }
-  if t.Rows(0) < 2 { return false }
+  if len(t.table) < 2 || len(t.table[0]) < 2 { return false }
 
   if strings.ToUpper(t.table[0][0]) != "2DA" ||
      strings.ToUpper(t.table[0][1]) != "V1.0" ||
@@ -165,14 +207,7 @@ func (t *Table) IsIDS() bool {
     // first column may only contain numbers
     for row := 1; row < len(t.table); row++ {
       if len(t.table[row]) < 2 { return false }
-      isHex := len(t.table[row][0]) > 2 && strings.ToLower(t.table[row][0][:2]) == "0x"
-      var err error = nil
-      if isHex {
-        _, err = strconv.ParseInt(t.table[row][0][2:], 16, 32)
-      } else {
-        _, err = strconv.ParseInt(t.table[row][0], 10, 32)
-      }
-      if err != nil { return false }
+      if _, err := parseIdsValue(t.table[row][0]); err != nil { return false }
     }
   }
   return true
@@ -261,21 +296,16 @@ func (t *Table) InsertRow(rowIndex int, items []string) {
   if t.err != nil { return }
   if items == nil || len(items) == 0 { return }
 
-  t.table = append(t.table, make([]string, 0))
-  for row := len(t.table) - 1; row > rowIndex; row-- {
-    t.table[row] = t.table[row - 1]
-  }
-
   // add only non-empty items
-  t.table[rowIndex] = make([]string, 0)
+  row := make([]string, 0)
   for _, v := range items {
     v = strings.TrimSpace(v)
     if len(v) > 0 {
-      t.table[rowIndex] = append(t.table[rowIndex], v)
+      row = append(row, v)
     }
   }
 
-  t.dirty = true
+  t.insertTableRow(rowIndex, row)
 }
 
 // InsertRowString inserts a new table row and fills it with the items extracted from the given string.
@@ -287,7 +317,13 @@ func (t *Table) InsertRowString(rowIndex int, line string) {
   if t.err != nil { return }
   if len(line) == 0 { return }
 
-  items, _ := importRow([]byte(line), 0, nil)
+  var items []string
+  if (t.options & OPTION_QUOTED) != 0 {
+    layout := splitRow(strings.TrimRight(line, "\f\n\r\v"), t.options)
+    items = layout.values(t.options)
+  } else {
+    items, _ = importRow([]byte(line), 0, nil)
+  }
   t.InsertRow(rowIndex, items)
 }
 
@@ -310,13 +346,45 @@ func (t *Table) DeleteRow(rowIndex int) {
   if t.err != nil { return }
   if rowIndex < 0 || rowIndex >= len(t.table) { t.err = ietools.ErrIllegalArguments; return }
 
-  for row := rowIndex + 1; row < len(t.table); row++ {
-    t.table[row - 1] = t.table[row]
+  t.deleteTableRow(rowIndex)
+}
+
+
+// Used internally. Inserts the given row at the specified absolute row index.
+func (t *Table) insertTableRow(rowIndex int, row []string) {
+  t.table = append(t.table, nil)
+  copy(t.table[rowIndex+1:], t.table[rowIndex:])
+  t.table[rowIndex] = row
+  if t.trivia != nil {
+    t.trivia = append(t.trivia, nil)
+    copy(t.trivia[rowIndex+1:], t.trivia[rowIndex:])
+    t.trivia[rowIndex] = nil
   }
-  t.table = t.table[:len(t.table) - 1]
   t.dirty = true
 }
 
+// Used internally. Returns a copy of the table. Table data and lossless information are copied as well.
+func (t *Table) clone() *Table {
+  c := *t
+  c.table = make([][]string, len(t.table))
+  for row, items := range t.table { c.table[row] = append([]string(nil), items...) }
+  if t.trivia != nil {
+    c.trivia = make([]*rowTrivia, len(t.trivia))
+    for row, tr := range t.trivia {
+      if tr != nil { trc := *tr; c.trivia[row] = &trc }
+    }
+  }
+  return &c
+}
+
+// Used internally. Removes the row at the specified absolute row index.
+func (t *Table) deleteTableRow(rowIndex int) {
+  t.table = append(t.table[:rowIndex], t.table[rowIndex+1:]...)
+  if t.trivia != nil {
+    t.removeTrivia(rowIndex)
+  }
+  t.dirty = true
+}
 
 // Used internally. Returns the absolute table row based on row and minCols. Returns -1 if desired row does not exist.
 func (t *Table) absoluteRow(row, minCols int) int {
@@ -334,107 +402,47 @@ func (t *Table) absoluteRow(row, minCols int) int {
   return -1
 }
 
-// Used internally. Parses a raw stream of bytes into a two-dimensional string array of rows and columns.
-// data contains the raw stream of text. cm is used to convert ANSI into UTF-8. Specify nil to skip conversion.
+// Used internally. Returns the textual representation of the item at the absolute table location [row, col].
+func (t *Table) itemText(row, col int) string {
+  if (t.options & OPTION_QUOTED) != 0 { return quoteItem(t.table[row][col]) }
+  return t.table[row][col]
+}
+
+// Used internally. Parses a stream of text into a two-dimensional string array of rows and columns.
+// cm is used to convert ANSI into UTF-8. Specify nil to skip conversion.
 // Note: This parser will turn anything into a table representation.
-func importTable(data []byte, cm *charmap.Charmap) [][]string {
+func importTable(r io.Reader, cm encoding.Encoding) ([][]string, error) {
   table := make([][]string, 0)
-  if data == nil { return table }
-
-  for pos := 0; pos < len(data); {
-    var line []string
-    line, pos = importRow(data, pos, cm)
-    if len(line) > 0 {
-      table = append(table, line)
-    }
+  s := NewScanner(r, cm)
+  for s.Scan() {
+    table = append(table, s.Row())
   }
-
-  return table
+  return table, s.Err()
 }
 
 // Used internally. Parses a single row of table data and returns it as a string array.
 // data contains the raw stream of text. cm is used to convert ANSI into UTF-8. Specify nil to skip conversion.
-func importRow(data []byte, startPos int, cm *charmap.Charmap) (line []string, newPos int) {
+func importRow(data []byte, startPos int, cm encoding.Encoding) (line []string, newPos int) {
   line = make([]string, 0)
-  newPos = startPos
-  if data == nil { return }
-
-  // available parser modes
-  const MODE_EMPTY = 0  // indicates end of line
-  const MODE_SPACE = 1  // parsing whitespace between/after row items
-  const MODE_TOKEN = 2  // parsing non-whitespace data
-
-  // initializing matchers
-  regNewline := regexp.MustCompile("[\f\n\r\v]")        // separator for rows
-  regSpace := regexp.MustCompile("[\a\b\t ]")           // separator for columns
-  regToken := regexp.MustCompile("[^\f\n\r\v\a\b\t ]")  // textual content includes everything not covered by regNewline and regSpace
-
-  mode := MODE_SPACE
-  curCol, posToken := 0, -1
-  for pos := startPos; pos < len(data) && mode != MODE_EMPTY; pos++ {
-    switch mode {
-    case MODE_SPACE:
-      if regToken.Match(data[pos:pos+1]) {
-        posToken = pos
-        mode = MODE_TOKEN
-      } else if regNewline.Match(data[pos:pos+1]) {
-        newPos = pos
-        mode = MODE_EMPTY
-      }
-    case MODE_TOKEN:
-      if regSpace.Match(data[pos:pos+1]) {
-        var s string
-        var err error = nil
-        if cm != nil {
-          s, err = ietools.AnsiToUtf8(data[posToken:pos], cm)
-        }
-        if cm == nil || err != nil {
-          s = string(data[posToken:pos])
-        }
-        if curCol >= len(line) {
-          line = append(line, s)
-        }
-        posToken = -1
-        curCol++
-        mode = MODE_SPACE
-      } else if regNewline.Match(data[pos:pos+1]) {
-        newPos = pos
-        mode = MODE_EMPTY
-      }
-    }
-  }
-
-  if mode != MODE_EMPTY {
-    newPos = len(data)
-  }
-
-  // dealing with pending data
-  if posToken >= 0 {
-    var s string
-    var err error = nil
-    if cm != nil {
-      s, err = ietools.AnsiToUtf8(data[posToken:newPos], cm)
-    }
-    if cm == nil || err != nil {
-      s = string(data[posToken:newPos])
-    }
-    if curCol >= len(line) {
-      line = append(line, s)
-    }
-  }
-
-  // prevent deadlocks in parent function
-  if mode == MODE_EMPTY {
-    newPos++
+  pos := startPos
+  for pos < len(data) && !isLineBreak(data[pos]) {
+    if isSpace(data[pos]) { pos++; continue }
+    start := pos
+    for pos < len(data) && !isSpace(data[pos]) && !isLineBreak(data[pos]) { pos++ }
+    line = append(line, decodeLine(data[start:pos], cm))
   }
 
+  // skip line break
+  newPos = pos
+  if newPos < len(data) { newPos++ }
   return
 }
 
 // Used internally. Converts the current table content into a raw stream of bytes.
 // UseWinBreak indicates whether to use Windows-style line breaks (\r\n) or Unix-style line breaks (\n).
 // cm is used to convert UTF-8 into ANSI. Specify nil to skip conversion.
-func (t *Table) exportTable(useWinBreak, prettify bool, cm *charmap.Charmap) []byte {
+func (t *Table) exportTable(useWinBreak, prettify bool, cm encoding.Encoding) []byte {
+  cm = ietools.NormalizeEncoding(cm)
  var nl []byte
   if useWinBreak { nl = []byte{0x0d, 0x0a} } else { nl = []byte{0x0a} }
 
@@ -452,8 +460,8 @@ func (t *Table) exportTable(useWinBreak, prettify bool, cm *charmap.Charmap) []b
             if col == 0 { continue }
             shift = 1
           }
-          if len(t.table[row][col-shift]) > minW {
-            minW = len(t.table[row][col-shift])
+          if len(t.itemText(row, col-shift)) > minW {
+            minW = len(t.itemText(row, col-shift))
           }
         }
       }
@@ -479,10 +487,10 @@ func (t *Table) exportTable(useWinBreak, prettify bool, cm *charmap.Charmap) []b
         var item []byte
         var err error = nil
         if cm != nil {
-          item, err = ietools.Utf8ToAnsi(t.table[row][col], cm)
+          item, err = ietools.Utf8ToAnsi(t.itemText(row, col), cm)
         }
         if cm == nil || err != nil {
-          item = []byte(t.table[row][col])
+          item = []byte(t.itemText(row, col))
         }
         if len(item) > 0 {
           buf.Write(item)
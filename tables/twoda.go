@@ -0,0 +1,286 @@
+package tables
+
+import (
+  "errors"
+  "strings"
+
+  "github.com/InfinityTools/go-ietools"
+)
+
+var (
+  ErrNot2DA = errors.New("Table is not in 2DA format")
+)
+
+const (
+  // Absolute table rows of 2DA content
+  row2DASignature = 0   // "2DA V1.0"
+  row2DADefault   = 1   // default value
+  row2DAHeader    = 2   // column headers
+  row2DAData      = 3   // first data row
+)
+
+// TwoDA provides access to 2DA table content by row labels and column headers.
+//
+// Column indices refer to the header items, i.e. column 0 is the first column after the row label. Row indices refer
+// to the data rows below the header row. Errors are reported by the error state of the underlying Table.
+type TwoDA struct {
+  t *Table
+}
+
+
+// As2DA returns a 2DA view of the table content.
+//
+// Operations of the view set the error state of the table to ErrNot2DA if the table does not conform to the 2DA format.
+func (t *Table) As2DA() *TwoDA {
+  return &TwoDA{ t }
+}
+
+// Table returns the underlying Table object.
+func (v *TwoDA) Table() *Table {
+  return v.t
+}
+
+// Default returns the default value of the 2DA table, as defined in the second line.
+// Operation is skipped if error state is set.
+func (v *TwoDA) Default() string {
+  if !v.check() { return "" }
+  return v.def()
+}
+
+// SetDefault assigns a new default value to the 2DA table.
+//
+// Sets error state if value is empty. Operation is skipped if error state is set.
+func (v *TwoDA) SetDefault(value string) {
+  if !v.check() { return }
+  value = strings.TrimSpace(value)
+  if len(value) == 0 { v.t.err = ietools.ErrIllegalArguments; return }
+  if v.t.table[row2DADefault][0] != value { v.t.dirty = true }
+  v.t.table[row2DADefault][0] = value
+}
+
+// Headers returns a list of all column headers.
+// Operation is skipped if error state is set.
+func (v *TwoDA) Headers() []string {
+  if !v.check() { return nil }
+  return append([]string(nil), v.headers()...)
+}
+
+// Labels returns a list of all row labels.
+// Operation is skipped if error state is set.
+func (v *TwoDA) Labels() []string {
+  if !v.check() { return nil }
+  labels := make([]string, 0, len(v.data()))
+  for _, row := range v.data() { labels = append(labels, row[0]) }
+  return labels
+}
+
+// Columns returns the number of column headers.
+// Operation is skipped if error state is set.
+func (v *TwoDA) Columns() int {
+  if !v.check() { return 0 }
+  return len(v.headers())
+}
+
+// Rows returns the number of data rows.
+// Operation is skipped if error state is set.
+func (v *TwoDA) Rows() int {
+  if !v.check() { return 0 }
+  return len(v.data())
+}
+
+// ColumnIndex returns the index of the first column with the specified header (case-insensitive).
+// Returns -1 if the header does not exist. Operation is skipped if error state is set.
+func (v *TwoDA) ColumnIndex(header string) int {
+  if !v.check() { return -1 }
+  return v.columnIndex(header)
+}
+
+// RowIndex returns the index of the first data row with the specified label (case-insensitive).
+// Returns -1 if the label does not exist. Operation is skipped if error state is set.
+func (v *TwoDA) RowIndex(label string) int {
+  if !v.check() { return -1 }
+  return v.rowIndex(label)
+}
+
+// Get returns the value at the specified row label and column header (both case-insensitive). The default value is
+// returned if the row does not provide enough items.
+//
+// Sets error state if label or header do not exist. Operation is skipped if error state is set.
+func (v *TwoDA) Get(label, header string) string {
+  if !v.check() { return "" }
+  row, col := v.rowIndex(label), v.columnIndex(header)
+  if row < 0 || col < 0 { v.t.err = ietools.ErrIllegalArguments; return "" }
+  return v.cell(row, col)
+}
+
+// GetAt returns the value at the specified data row and column index. The default value is returned if the row does
+// not provide enough items.
+//
+// Sets error state if row or col are out of range. Operation is skipped if error state is set.
+func (v *TwoDA) GetAt(row, col int) string {
+  if !v.check() { return "" }
+  if row < 0 || row >= len(v.data()) || col < 0 || col >= len(v.headers()) {
+    v.t.err = ietools.ErrIllegalArguments
+    return ""
+  }
+  return v.cell(row, col)
+}
+
+// Put assigns value to the cell at the specified row label and column header (both case-insensitive). Rows that are
+// too short are padded with the default value.
+//
+// Sets error state if label or header do not exist or value is empty. Operation is skipped if error state is set.
+func (v *TwoDA) Put(label, header, value string) {
+  if !v.check() { return }
+  row, col := v.rowIndex(label), v.columnIndex(header)
+  value = strings.TrimSpace(value)
+  if row < 0 || col < 0 || len(value) == 0 { v.t.err = ietools.ErrIllegalArguments; return }
+  v.setCell(row, col, value)
+}
+
+// PutAt assigns value to the cell at the specified data row and column index. Rows that are too short are padded with
+// the default value.
+//
+// Sets error state if row or col are out of range or value is empty. Operation is skipped if error state is set.
+func (v *TwoDA) PutAt(row, col int, value string) {
+  if !v.check() { return }
+  value = strings.TrimSpace(value)
+  if row < 0 || row >= len(v.data()) || col < 0 || col >= len(v.headers()) || len(value) == 0 {
+    v.t.err = ietools.ErrIllegalArguments
+    return
+  }
+  v.setCell(row, col, value)
+}
+
+// AddColumn appends a new column with the specified header and assigns value to all data rows. The default value is
+// used if value is empty. Rows that are too short are padded with the default value.
+//
+// Sets error state if header is empty. Operation is skipped if error state is set.
+func (v *TwoDA) AddColumn(header, value string) {
+  if !v.check() { return }
+  v.insertColumn(len(v.headers()), header, value)
+}
+
+// InsertColumn inserts a new column with the specified header at column index col and assigns value to all data rows.
+// The default value is used if value is empty. Rows that are too short are padded with the default value.
+//
+// Sets error state if col is out of range or header is empty. Operation is skipped if error state is set.
+func (v *TwoDA) InsertColumn(col int, header, value string) {
+  if !v.check() { return }
+  v.insertColumn(col, header, value)
+}
+
+// RemoveColumn removes the first column with the specified header (case-insensitive) from the header row and all data
+// rows.
+//
+// Sets error state if header does not exist. Operation is skipped if error state is set.
+func (v *TwoDA) RemoveColumn(header string) {
+  if !v.check() { return }
+  col := v.columnIndex(header)
+  if col < 0 { v.t.err = ietools.ErrIllegalArguments; return }
+
+  v.t.table[row2DAHeader] = removeString(v.t.table[row2DAHeader], col)
+  for row := row2DAData; row < len(v.t.table); row++ {
+    v.t.table[row] = removeString(v.t.table[row], col + 1)
+  }
+  v.t.dirty = true
+}
+
+
+// Used internally. Returns whether view operations can be performed. Sets error state if the table is not in 2DA
+// format.
+func (v *TwoDA) check() bool {
+  if v.t.err != nil { return false }
+  if !v.t.Is2DA() { v.t.err = ErrNot2DA; return false }
+  return true
+}
+
+// Used internally. Returns the default value without checking the table format.
+func (v *TwoDA) def() string {
+  return v.t.table[row2DADefault][0]
+}
+
+// Used internally. Returns the value at the specified data row and column index without checking the table format or
+// the row index. The default value is returned if the row does not provide enough items.
+func (v *TwoDA) cell(row, col int) string {
+  items := v.t.table[row2DAData + row]
+  if col + 1 < len(items) { return items[col + 1] }
+  return v.def()
+}
+
+// Used internally. Assigns value to the cell at the specified data row and column index without checking the table
+// format or the indices. The row is padded with the default value if needed.
+func (v *TwoDA) setCell(row, col int, value string) {
+  v.pad(row2DAData + row, col + 2)
+  if v.t.table[row2DAData + row][col + 1] != value { v.t.dirty = true }
+  v.t.table[row2DAData + row][col + 1] = value
+}
+
+// Used internally. Inserts a new column without checking the table format. See InsertColumn for details.
+func (v *TwoDA) insertColumn(col int, header, value string) {
+  header, value = strings.TrimSpace(header), strings.TrimSpace(value)
+  if col < 0 || col > len(v.headers()) || len(header) == 0 { v.t.err = ietools.ErrIllegalArguments; return }
+  if len(value) == 0 { value = v.def() }
+
+  if len(v.t.table) <= row2DAHeader { v.t.insertTableRow(row2DAHeader, make([]string, 0)) }
+  v.t.table[row2DAHeader] = insertString(v.t.table[row2DAHeader], col, header)
+  for row := row2DAData; row < len(v.t.table); row++ {
+    v.pad(row, col + 1)
+    v.t.table[row] = insertString(v.t.table[row], col + 1, value)
+  }
+  v.t.dirty = true
+}
+
+// Used internally. Returns the index of the first column with the specified header without checking the table format.
+// Returns -1 if the header does not exist.
+func (v *TwoDA) columnIndex(header string) int {
+  for i, h := range v.headers() {
+    if strings.EqualFold(h, header) { return i }
+  }
+  return -1
+}
+
+// Used internally. Returns the index of the first data row with the specified label without checking the table
+// format. Returns -1 if the label does not exist.
+func (v *TwoDA) rowIndex(label string) int {
+  for i, row := range v.data() {
+    if strings.EqualFold(row[0], label) { return i }
+  }
+  return -1
+}
+
+// Used internally. Returns the header row, which is empty for 2DA tables without headers.
+func (v *TwoDA) headers() []string {
+  if len(v.t.table) <= row2DAHeader { return nil }
+  return v.t.table[row2DAHeader]
+}
+
+// Used internally. Returns the data rows, which is empty for 2DA tables without headers.
+func (v *TwoDA) data() [][]string {
+  if len(v.t.table) <= row2DAData { return nil }
+  return v.t.table[row2DAData:]
+}
+
+// Used internally. Pads the specified absolute row with default values to contain at least size items.
+func (v *TwoDA) pad(row, size int) {
+  def := v.t.table[row2DADefault][0]
+  for len(v.t.table[row]) < size {
+    v.t.table[row] = append(v.t.table[row], def)
+    v.t.dirty = true
+  }
+}
+
+// Used internally. Inserts item into list at the specified position. Returns the resulting list.
+func insertString(list []string, pos int, item string) []string {
+  if pos >= len(list) { return append(list, item) }
+  list = append(list, "")
+  copy(list[pos+1:], list[pos:])
+  list[pos] = item
+  return list
+}
+
+// Used internally. Removes the item at the specified position from list if available. Returns the resulting list.
+func removeString(list []string, pos int) []string {
+  if pos < 0 || pos >= len(list) { return list }
+  return append(list[:pos], list[pos+1:]...)
+}
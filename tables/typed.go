@@ -0,0 +1,246 @@
+package tables
+
+import (
+  "errors"
+  "fmt"
+  "math"
+  "regexp"
+  "strconv"
+  "strings"
+
+  "github.com/InfinityTools/go-ietools"
+)
+
+var (
+  ErrNoItem = errors.New("Table item does not exist")
+)
+
+
+// GetInt returns the item at [row, col] for rows containing minCols or more items as integer value. Decimal and
+// hexadecimal notation (0x prefix) is supported.
+//
+// Returns an error if the location does not exist or the item is not a valid number. The error state of the table
+// is not affected.
+func (t *Table) GetInt(row, col, minCols int) (int, error) {
+  item, err := t.typedItem(row, col, minCols)
+  if err != nil { return 0, err }
+  v, err := parseInt(item)
+  if err != nil { return 0, fmt.Errorf("Invalid numeric value: %q", item) }
+  return v, nil
+}
+
+// GetIntDef returns the item at [row, col] for rows containing minCols or more items as integer value. Returns def if
+// the location does not exist or the item is not a valid number.
+func (t *Table) GetIntDef(row, col, minCols, def int) int {
+  if v, err := t.GetInt(row, col, minCols); err == nil { return v }
+  return def
+}
+
+// GetHex returns the item at [row, col] for rows containing minCols or more items as hexadecimal value. The 0x prefix
+// is optional.
+//
+// Returns an error if the location does not exist or the item is not a valid hexadecimal number. The error state of
+// the table is not affected.
+func (t *Table) GetHex(row, col, minCols int) (int, error) {
+  item, err := t.typedItem(row, col, minCols)
+  if err != nil { return 0, err }
+  s := item
+  if len(s) > 2 && strings.ToLower(s[:2]) == "0x" { s = s[2:] }
+  v, err := strconv.ParseUint(s, 16, 32)
+  if err != nil { return 0, fmt.Errorf("Invalid hexadecimal value: %q", item) }
+  return int(v), nil
+}
+
+// GetHexDef returns the item at [row, col] for rows containing minCols or more items as hexadecimal value. Returns def
+// if the location does not exist or the item is not a valid hexadecimal number.
+func (t *Table) GetHexDef(row, col, minCols, def int) int {
+  if v, err := t.GetHex(row, col, minCols); err == nil { return v }
+  return def
+}
+
+// GetFloat returns the item at [row, col] for rows containing minCols or more items as floating point value.
+//
+// Returns an error if the location does not exist or the item is not a valid number. The error state of the table
+// is not affected.
+func (t *Table) GetFloat(row, col, minCols int) (float64, error) {
+  item, err := t.typedItem(row, col, minCols)
+  if err != nil { return 0.0, err }
+  v, err := parseFloat(item)
+  if err != nil { return 0.0, fmt.Errorf("Invalid numeric value: %q", item) }
+  return v, nil
+}
+
+// GetFloatDef returns the item at [row, col] for rows containing minCols or more items as floating point value.
+// Returns def if the location does not exist or the item is not a valid number.
+func (t *Table) GetFloatDef(row, col, minCols int, def float64) float64 {
+  if v, err := t.GetFloat(row, col, minCols); err == nil { return v }
+  return def
+}
+
+// GetResRef returns the item at [row, col] for rows containing minCols or more items as uppercased resource
+// reference. Placeholders consisting only of asterisks (e.g. "*" or "****") are returned as empty string.
+//
+// Returns an error if the location does not exist or the item exceeds 8 characters. The error state of the table
+// is not affected.
+func (t *Table) GetResRef(row, col, minCols int) (string, error) {
+  item, err := t.typedItem(row, col, minCols)
+  if err != nil { return "", err }
+  if strings.Trim(item, "*") == "" { return "", nil }
+  if len(item) > 8 { return "", fmt.Errorf("Invalid resource reference: %q", item) }
+  return strings.ToUpper(item), nil
+}
+
+// GetResRefDef returns the item at [row, col] for rows containing minCols or more items as uppercased resource
+// reference. Returns def if the location does not exist, the item is a placeholder or exceeds 8 characters.
+func (t *Table) GetResRefDef(row, col, minCols int, def string) string {
+  if v, err := t.GetResRef(row, col, minCols); err == nil && len(v) > 0 { return v }
+  return def
+}
+
+// GetStrref returns the item at [row, col] for rows containing minCols or more items as string reference.
+// Placeholders consisting only of asterisks are returned as -1.
+//
+// Returns an error if the location does not exist or the item is not a valid string reference. The error state of
+// the table is not affected.
+func (t *Table) GetStrref(row, col, minCols int) (int32, error) {
+  item, err := t.typedItem(row, col, minCols)
+  if err != nil { return -1, err }
+  if strings.Trim(item, "*") == "" { return -1, nil }
+  v, err := strconv.ParseInt(item, 10, 32)
+  if err != nil || v < -1 { return -1, fmt.Errorf("Invalid string reference: %q", item) }
+  return int32(v), nil
+}
+
+// GetStrrefDef returns the item at [row, col] for rows containing minCols or more items as string reference. Returns
+// def if the location does not exist or the item is not a valid string reference.
+func (t *Table) GetStrrefDef(row, col, minCols int, def int32) int32 {
+  if v, err := t.GetStrref(row, col, minCols); err == nil { return v }
+  return def
+}
+
+
+// ColumnValues returns the items of column col for all rows containing minCols or more items. Rows without an item at
+// col are skipped.
+// Operation is skipped if error state is set.
+func (t *Table) ColumnValues(col, minCols int) []string {
+  if t.err != nil { return nil }
+  list := make([]string, 0)
+  t.eachItem(col, minCols, func(row int, item string) { list = append(list, item) })
+  return list
+}
+
+// ColumnDistinct returns the distinct items of column col for all rows containing minCols or more items, in order of
+// their first appearance.
+// Operation is skipped if error state is set.
+func (t *Table) ColumnDistinct(col, minCols int) []string {
+  if t.err != nil { return nil }
+  seen := make(map[string]bool)
+  list := make([]string, 0)
+  t.eachItem(col, minCols, func(row int, item string) {
+    if !seen[item] {
+      seen[item] = true
+      list = append(list, item)
+    }
+  })
+  return list
+}
+
+// ColumnMin returns the smallest numeric value of column col for all rows containing minCols or more items.
+// Non-numeric items, such as the 2DA default value, are skipped. Returns false if no numeric value is available.
+// Operation is skipped if error state is set.
+func (t *Table) ColumnMin(col, minCols int) (float64, bool) {
+  min, _, _, count := t.columnStats(col, minCols)
+  return min, count > 0
+}
+
+// ColumnMax returns the largest numeric value of column col for all rows containing minCols or more items.
+// Non-numeric items, such as the 2DA default value, are skipped. Returns false if no numeric value is available.
+// Operation is skipped if error state is set.
+func (t *Table) ColumnMax(col, minCols int) (float64, bool) {
+  _, max, _, count := t.columnStats(col, minCols)
+  return max, count > 0
+}
+
+// ColumnSum returns the sum of all numeric values of column col for all rows containing minCols or more items.
+// Non-numeric items, such as the 2DA default value, are skipped.
+// Operation is skipped if error state is set.
+func (t *Table) ColumnSum(col, minCols int) float64 {
+  _, _, sum, _ := t.columnStats(col, minCols)
+  return sum
+}
+
+// FindRows returns the indices of all rows containing minCols or more items where the item at column col matches the
+// given predicate. Indices are compatible with the row parameter of GetItem and related functions.
+// Operation is skipped if error state is set.
+func (t *Table) FindRows(col, minCols int, pred func(item string) bool) []int {
+  if t.err != nil { return nil }
+  list := make([]int, 0)
+  t.eachItem(col, minCols, func(row int, item string) {
+    if pred(item) { list = append(list, row) }
+  })
+  return list
+}
+
+// FindRowsRegexp returns the indices of all rows containing minCols or more items where the item at column col
+// matches the given regular expression. Indices are compatible with the row parameter of GetItem and related functions.
+//
+// Sets error state if expr is not a valid regular expression. Operation is skipped if error state is set.
+func (t *Table) FindRowsRegexp(col, minCols int, expr string) []int {
+  if t.err != nil { return nil }
+  re, err := regexp.Compile(expr)
+  if err != nil { t.err = err; return nil }
+  return t.FindRows(col, minCols, re.MatchString)
+}
+
+
+// Used internally. Returns the item at [row, col] for rows containing minCols or more items without affecting the
+// error state.
+func (t *Table) typedItem(row, col, minCols int) (string, error) {
+  if t.err != nil { return "", t.err }
+  if row < 0 || col < 0 { return "", ErrNoItem }
+  row = t.absoluteRow(row, minCols)
+  if row < 0 || col >= len(t.table[row]) { return "", ErrNoItem }
+  return t.table[row][col], nil
+}
+
+// Used internally. Calls fn for each available item of column col in rows containing minCols or more items. row is
+// the relative row index.
+func (t *Table) eachItem(col, minCols int, fn func(row int, item string)) {
+  if col < 0 { return }
+  if minCols < 0 { minCols = 0 }
+  rel := 0
+  for _, items := range t.table {
+    if len(items) < minCols { continue }
+    if col < len(items) { fn(rel, items[col]) }
+    rel++
+  }
+}
+
+// Used internally. Returns minimum, maximum, sum and number of numeric values of column col.
+func (t *Table) columnStats(col, minCols int) (min, max, sum float64, count int) {
+  if t.err != nil { return }
+  min, max = math.Inf(1), math.Inf(-1)
+  t.eachItem(col, minCols, func(row int, item string) {
+    v, err := parseFloat(item)
+    if err != nil { return }
+    min, max = math.Min(min, v), math.Max(max, v)
+    sum += v
+    count++
+  })
+  if count == 0 { min, max = 0.0, 0.0 }
+  return
+}
+
+// Used internally. Parses a decimal or hexadecimal (0x prefix) integer.
+func parseInt(s string) (int, error) {
+  v, err := ietools.ParseNumber(s, strconv.IntSize)
+  return int(v), err
+}
+
+// Used internally. Parses a finite floating point value. Hexadecimal integers (0x prefix) are supported as well.
+func parseFloat(s string) (float64, error) {
+  if v, err := parseInt(s); err == nil { return float64(v), nil }
+  v, err := strconv.ParseFloat(s, 64)
+  if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) { err = strconv.ErrSyntax }
+  return v, err
+}
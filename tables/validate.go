@@ -0,0 +1,202 @@
+package tables
+
+import (
+  "bytes"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "strings"
+
+  "github.com/InfinityTools/go-ietools"
+  "golang.org/x/text/encoding"
+)
+
+const (
+  // Kinds of 2DA defects
+  DEFECT_BOM              = 0   // Byte order mark at the start of the file
+  DEFECT_SIGNATURE        = 1   // Missing or malformed "2DA V1.0" signature
+  DEFECT_DEFAULT          = 2   // Missing default value or default value on the signature line
+  DEFECT_SHORT_ROW        = 3   // Data row with less items than column headers
+  DEFECT_LONG_ROW         = 4   // Data row with more items than column headers
+  DEFECT_DUPLICATE_LABEL  = 5   // Data row with a label that is already used by a preceding row
+)
+
+// Defect describes a structural problem of a 2DA table.
+type Defect struct {
+  Kind    int     // kind of defect (see DEFECT_xxx constants)
+  Line    int     // line number of the affected row, starting at 1. 0 if not associated with a specific line.
+  Message string  // description of the defect
+}
+
+
+// String returns a textual description of the defect, including line number.
+func (d Defect) String() string {
+  if d.Line > 0 { return fmt.Sprintf("line %d: %s", d.Line, d.Message) }
+  return d.Message
+}
+
+// Load2DA uses the given Reader to load 2DA table data, using the specified character map or encoding for ANSI
+// decoding.
+// options is an optional combination of OPTION_xxx flags. Specify OPTION_NORMALIZE to fix defects when the table is
+// saved.
+//
+// In contrast to LoadEx, the table content is checked for common defects, such as a missing or malformed signature,
+// a missing default value, rows with too few or too many items, duplicate row labels or a leading UTF-8 byte order
+// mark. A byte order mark is skipped by the parser and retained for UTF-8 output until the table is normalized. Other
+// defects are only fixed by Normalize2DA.
+//
+// Returns the Table object and the list of defects in order of appearance.
+// Use function Error to check if the function returned successfully.
+func Load2DA(r io.Reader, cmap encoding.Encoding, options ...int) (*Table, []Defect) {
+  defects := make([]Defect, 0)
+  data, err := ioutil.ReadAll(r)
+  if err != nil { return &Table{ cmap: ietools.NormalizeEncoding(cmap), err: err }, defects }
+
+  bom := bytes.HasPrefix(data, bomUtf8)
+  if bom {
+    defects = append(defects, Defect{ DEFECT_BOM, 1, "UTF-8 byte order mark" })
+    data = data[len(bomUtf8):]
+  }
+
+  t := LoadEx(bytes.NewReader(data), cmap, options...)
+  if t.err != nil { return t, defects }
+  t.bom = bom
+  defects = append(defects, t.validate2DA(rowLines(data, t.options))...)
+  return t, defects
+}
+
+// Normalize2DA fixes structural defects of the table content to conform to the 2DA format. A missing or malformed
+// signature is replaced, a default value on the signature line (e.g. "2DA V1.0 ****") is moved to its own row, a
+// missing default value is set to "*", rows with too few items are padded with the default value, excess items are
+// removed, and rows with duplicate labels are removed, since they cannot be addressed by label. A UTF-8 byte order
+// mark is no longer written.
+//
+// Tables with OPTION_NORMALIZE set are saved in normalized form without calling this function on the table itself.
+// Operation is skipped if error state is set.
+func (t *Table) Normalize2DA() {
+  if t.err != nil { return }
+
+  if t.bom {
+    t.bom = false
+    t.dirty = true
+  }
+  sig, def, header := t.layout2DA()
+  defValue := "*"
+  if sig < 0 {
+    t.insertTableRow(0, []string{ "2DA", "V1.0" })
+    t.dirty = true
+  } else if !isSignature2DA(t.table[0]) {
+    if isInlineDefault2DA(t.table[0]) { defValue = t.table[0][2] }
+    t.table[0] = []string{ "2DA", "V1.0" }
+    t.dirty = true
+  }
+  if def < 0 {
+    t.insertTableRow(row2DADefault, []string{ defValue })
+    t.dirty = true
+  }
+  if header < 0 { return }
+
+  v := t.As2DA()
+  size := v.Columns() + 1
+  labels := make(map[string]bool)
+  for row := row2DAData; row < len(t.table); {
+    label := strings.ToUpper(t.table[row][0])
+    if labels[label] {
+      t.deleteTableRow(row)
+      t.dirty = true
+      continue
+    }
+    labels[label] = true
+    v.pad(row, size)
+    if len(t.table[row]) > size {
+      t.table[row] = t.table[row][:size]
+      t.dirty = true
+    }
+    row++
+  }
+}
+
+
+// Used internally. Checks the current table content for structural 2DA defects. lines contains the line number of
+// each table row.
+func (t *Table) validate2DA(lines []int) []Defect {
+  defects := make([]Defect, 0)
+  line := func(row int) int {
+    if row >= 0 && row < len(lines) { return lines[row] }
+    return 0
+  }
+
+  sig, def, header := t.layout2DA()
+  if sig < 0 {
+    defects = append(defects, Defect{ DEFECT_SIGNATURE, line(0), "Missing 2DA signature" })
+  } else if isInlineDefault2DA(t.table[0]) {
+    defects = append(defects, Defect{ DEFECT_DEFAULT, line(0), "Default value on 2DA signature line" })
+  } else if !isSignature2DA(t.table[0]) {
+    defects = append(defects, Defect{ DEFECT_SIGNATURE, line(0),
+                                      fmt.Sprintf("Malformed 2DA signature: %q", strings.Join(t.table[0], " ")) })
+  }
+  if def < 0 && (sig < 0 || !isInlineDefault2DA(t.table[0])) {
+    defects = append(defects, Defect{ DEFECT_DEFAULT, line(sig + 1), "Missing default value" })
+  }
+  if header < 0 { return defects }
+
+  size := len(t.table[header]) + 1
+  labels := make(map[string]int)
+  for row := header + 1; row < len(t.table); row++ {
+    items := t.table[row]
+    if len(items) < size {
+      defects = append(defects, Defect{ DEFECT_SHORT_ROW, line(row),
+                                        fmt.Sprintf("Row %q has %d of %d items", items[0], len(items), size) })
+    } else if len(items) > size {
+      defects = append(defects, Defect{ DEFECT_LONG_ROW, line(row),
+                                        fmt.Sprintf("Row %q has %d excess items", items[0], len(items) - size) })
+    }
+    label := strings.ToUpper(items[0])
+    if first, ok := labels[label]; ok {
+      defects = append(defects, Defect{ DEFECT_DUPLICATE_LABEL, line(row),
+                                        fmt.Sprintf("Duplicate row label %q, first defined in line %d", items[0], first) })
+    } else {
+      labels[label] = line(row)
+    }
+  }
+  return defects
+}
+
+// Used internally. Determines the absolute rows of signature, default value and column headers, considering missing
+// or malformed 2DA elements. Returns -1 for each missing element.
+func (t *Table) layout2DA() (sig, def, header int) {
+  sig, def, header = -1, -1, -1
+  if len(t.table) > 0 && strings.HasPrefix(strings.ToUpper(t.table[0][0]), "2DA") { sig = 0 }
+  // default value on the signature line: the next row contains the column headers
+  inline := sig == 0 && isInlineDefault2DA(t.table[0])
+  if row := sig + 1; !inline && row < len(t.table) && len(t.table[row]) == 1 { def = row }
+  row := sig + 1
+  if def >= 0 { row = def + 1 }
+  if row < len(t.table) { header = row }
+  return
+}
+
+// Used internally. Returns whether items contain a valid 2DA signature.
+func isSignature2DA(items []string) bool {
+  return len(items) == 2 && strings.EqualFold(items[0], "2DA") && strings.EqualFold(items[1], "V1.0")
+}
+
+// Used internally. Returns whether items contain a valid 2DA signature followed by the default value.
+func isInlineDefault2DA(items []string) bool {
+  return len(items) == 3 && isSignature2DA(items[:2])
+}
+
+// Used internally. Returns the line numbers of all rows of table data in the same way as they are parsed by LoadEx.
+func rowLines(data []byte, options int) []int {
+  lines := make([]int, 0)
+  line := 0
+  for pos := 0; pos < len(data); {
+    end := pos
+    for end < len(data) && !isLineBreak(data[end]) { end++ }
+    line++
+    if len(splitRow(string(data[pos:end]), options).items) > 0 { lines = append(lines, line) }
+    if end < len(data) && data[end] == '\r' && end + 1 < len(data) && data[end+1] == '\n' { end++ }
+    pos = end + 1
+  }
+  return lines
+}
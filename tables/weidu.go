@@ -0,0 +1,125 @@
+package tables
+
+import (
+  "regexp"
+  "strings"
+
+  "github.com/InfinityTools/go-ietools"
+)
+
+// A pending SET_2DA_ENTRY_LATER operation.
+type laterEntry struct {
+  row, col  int
+  value     string
+}
+
+
+// Set2DAEntry assigns value to the table location [row, col], counting only rows containing minCols or more items.
+// This is equivalent to WeiDU's SET_2DA_ENTRY.
+//
+// Sets error state if the specified location does not exist or value is empty.
+// Operation is skipped if error state is set.
+func (t *Table) Set2DAEntry(row, col, minCols int, value string) {
+  t.PutItem(row, col, minCols, value)
+}
+
+// Read2DAEntry returns the item at [row, col], counting only rows containing minCols or more items.
+// This is equivalent to WeiDU's READ_2DA_ENTRY.
+//
+// Sets error state if the specified location does not exist. Operation is skipped if error state is set.
+func (t *Table) Read2DAEntry(row, col, minCols int) string {
+  return t.GetItem(row, col, minCols)
+}
+
+// Set2DAEntryLater stores an assignment of value to [row, col] in the named set without modifying the table.
+// The assignment is performed by Set2DAEntriesNow. This is equivalent to WeiDU's SET_2DA_ENTRY_LATER.
+//
+// Sets error state if row or col are negative or value is empty. Operation is skipped if error state is set.
+func (t *Table) Set2DAEntryLater(name string, row, col int, value string) {
+  if t.err != nil { return }
+  value = strings.TrimSpace(value)
+  if row < 0 || col < 0 || len(value) == 0 { t.err = ietools.ErrIllegalArguments; return }
+  if t.later == nil { t.later = make(map[string][]laterEntry) }
+  t.later[name] = append(t.later[name], laterEntry{ row, col, value })
+}
+
+// Set2DAEntriesNow performs all assignments stored in the named set, counting only rows containing minCols or more
+// items, and clears the set afterwards. This is equivalent to WeiDU's SET_2DA_ENTRIES_NOW.
+//
+// All locations are checked before the table is modified. Sets error state and leaves both table and set unchanged
+// if a location does not exist. Operation is skipped if error state is set.
+func (t *Table) Set2DAEntriesNow(name string, minCols int) {
+  if t.err != nil { return }
+  list := t.later[name]
+  rows := make([]int, len(list))
+  for i, e := range list {
+    rows[i] = t.absoluteRow(e.row, minCols)
+    if rows[i] < 0 || e.col >= len(t.table[rows[i]]) { t.err = ietools.ErrIllegalArguments; return }
+  }
+  // rows are resolved already
+  for i, e := range list { t.PutItem(rows[i], e.col, 0, e.value) }
+  delete(t.later, name)
+}
+
+// Count2DARows returns the number of rows containing minCols or more items. This is equivalent to WeiDU's
+// COUNT_2DA_ROWS.
+// Operation is skipped if error state is set.
+func (t *Table) Count2DARows(minCols int) int {
+  return t.Rows(minCols)
+}
+
+// Count2DACols returns the maximum number of items of any row. This is equivalent to WeiDU's COUNT_2DA_COLS.
+// Operation is skipped if error state is set.
+func (t *Table) Count2DACols() int {
+  return t.Columns()
+}
+
+// Read2DAEntriesNow returns a copy of all rows containing minCols or more items. This is equivalent to WeiDU's
+// READ_2DA_ENTRIES_NOW, where the number of returned rows corresponds to the rows variable.
+// Operation is skipped if error state is set.
+func (t *Table) Read2DAEntriesNow(minCols int) [][]string {
+  if t.err != nil { return nil }
+  rows := make([][]string, 0, len(t.table))
+  for _, row := range t.table {
+    if len(row) >= minCols { rows = append(rows, append([]string(nil), row...)) }
+  }
+  return rows
+}
+
+// Append adds line as a new row to the end of the table, similar to WeiDU's APPEND.
+//
+// unless and ifMatch are optional regular expressions that are matched against the textual table content. The line
+// is not appended if unless is not empty and matches, or if ifMatch is not empty and does not match. Returns whether
+// the line has been appended.
+//
+// Sets error state if a regular expression is invalid. Operation is skipped if error state is set.
+func (t *Table) Append(line, unless, ifMatch string) bool {
+  if t.err != nil { return false }
+
+  if len(unless) > 0 || len(ifMatch) > 0 {
+    content := t.exportTable(false, false, nil)
+    if len(unless) > 0 {
+      re, err := regexp.Compile(unless)
+      if err != nil { t.err = err; return false }
+      if re.Match(content) { return false }
+    }
+    if len(ifMatch) > 0 {
+      re, err := regexp.Compile(ifMatch)
+      if err != nil { t.err = err; return false }
+      if !re.Match(content) { return false }
+    }
+  }
+
+  numRows := len(t.table)
+  t.InsertRowString(numRows, line)
+  return t.err == nil && len(t.table) > numRows
+}
+
+// PrettyPrint2DA marks the table to be saved with properly aligned columns, regardless of the prettify argument of
+// Save and SaveEx. This is equivalent to WeiDU's PRETTY_PRINT_2DA.
+// Operation is skipped if error state is set.
+func (t *Table) PrettyPrint2DA() {
+  if t.err != nil { return }
+  if !t.pretty { t.dirty = true }
+  t.pretty = true
+}
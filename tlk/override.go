@@ -0,0 +1,316 @@
+package tlk
+
+import (
+  "errors"
+  "io"
+  "sort"
+
+  "github.com/InfinityTools/go-ietools"
+  "github.com/InfinityTools/go-ietools/buffers"
+  "golang.org/x/text/encoding"
+)
+
+const (
+  // Highest string reference supported by string override tables
+  MAX_OVERRIDE_STRREF = 0xffffff
+
+  // Max. number of entries Apply adds to a string table, including gaps
+  maxApplyEntries = 0x10000
+
+  tohHeaderSize = 0x14
+  tohEntrySize  = 0x1c
+  totEntrySize  = 0x20c
+  totChunkSize  = 0x200
+)
+
+var (
+  ErrInvalidToh = errors.New("Invalid TOH data")
+)
+
+// OverrideEntry defines a single string entry of a TOH/TOT string override table.
+type OverrideEntry struct {
+  Strref  int32     // the overridden string reference
+  Unknown uint32    // unknown purpose, preserved when saved
+  Sound   string    // sound resref
+  Volume  int32     // volume variance
+  Pitch   int32     // pitch variance
+  Text    string    // the string
+}
+
+// Override contains the necessary information to query or alter TOH/TOT string override tables, as found in the
+// language folders of the Enhanced Edition games (default.toh and default.tot). The TOH file defines the overridden
+// string references, the TOT file contains the associated strings in linked chunks of 512 bytes.
+type Override struct {
+  header  *buffers.Buffer     // TOH header; entry count is rewritten on save
+  entries []OverrideEntry     // list of override entries in file order
+  cmap    encoding.Encoding   // the character map or encoding to be used for ANSI decoding or encoding
+  dirty   bool                // true if content has been modified
+  err     error
+}
+
+
+// CreateOverride returns an empty string override table.
+//
+// Text is encoded in UTF-8 when saved.
+func CreateOverride() *Override {
+  header := buffers.Create()
+  header.InsertBytes(0, tohHeaderSize)
+  header.PutString(0x00, 4, "TLK ")
+  o := Override{ header: header, entries: make([]OverrideEntry, 0) }
+  return &o
+}
+
+// LoadOverride uses the given Readers to load TOH and TOT data from the underlying buffers. The function returns a
+// pointer to the Override object.
+//
+// This function assumes that text is encoded in UTF-8, as used by the Enhanced Edition games.
+// Use function Error to check if the function returned successfully.
+func LoadOverride(toh, tot io.Reader) *Override {
+  return LoadOverrideEx(toh, tot, nil)
+}
+
+// LoadOverrideEx uses the given Readers to load TOH and TOT data from the underlying buffers, using the specified
+// character map or encoding for ANSI decoding.
+//
+// Specify a nil encoding to skip the decoding operation. Use function Error to check if the function returned
+// successfully.
+func LoadOverrideEx(toh, tot io.Reader, cmap encoding.Encoding) *Override {
+  o := CreateOverride()
+  o.cmap = ietools.NormalizeEncoding(cmap)
+  bufToh := buffers.Load(toh)
+  if bufToh.Error() != nil { o.err = bufToh.Error(); return o }
+  bufTot := buffers.Load(tot)
+  if bufTot.Error() != nil { o.err = bufTot.Error(); return o }
+  o.importOverride(bufToh, bufTot)
+  return o
+}
+
+// Save writes the current override table to the specified Writers, encoding text as specified by the Load function.
+//
+// Does nothing if the Override is in an invalid state (see Error function).
+func (o *Override) Save(toh, tot io.Writer) {
+  o.SaveEx(toh, tot, o.cmap)
+}
+
+// SaveEx writes the current override table to the specified Writers, using the specified character map or encoding
+// for ANSI encoding.
+//
+// Specify a nil encoding to skip the encoding operation. Does nothing if the Override is in an invalid state (see
+// Error function).
+func (o *Override) SaveEx(toh, tot io.Writer, cmap encoding.Encoding) {
+  if o.err != nil { return }
+
+  bufToh, bufTot := o.exportOverride(ietools.NormalizeEncoding(cmap))
+  if bufToh.Error() != nil { o.err = bufToh.Error(); return }
+  if bufTot.Error() != nil { o.err = bufTot.Error(); return }
+  bufToh.Save(toh)
+  if bufToh.Error() != nil { o.err = bufToh.Error(); return }
+  bufTot.Save(tot)
+  if bufTot.Error() != nil { o.err = bufTot.Error(); return }
+  o.dirty = false
+}
+
+
+// Error returns the error state of the most recent operation on Override. Use ClearError function to clear the
+// current error state.
+func (o *Override) Error() error {
+  return o.err
+}
+
+// ClearError clears the error state from the last Override operation. Must be called for subsequent operations to
+// work correctly.
+func (o *Override) ClearError() {
+  o.err = nil
+}
+
+// IsModified returns whether the current override table has been modified by a previous operation.
+// The return value is only provided for informal purposes. None of the Override functions rely on it.
+func (o *Override) IsModified() bool {
+  return o.dirty
+}
+
+// ClearModified explicitly marks the Override object as unmodified.
+func (o *Override) ClearModified() {
+  o.dirty = false
+}
+
+
+// Count returns the number of override entries.
+// Operation is skipped if error state is set.
+func (o *Override) Count() int {
+  if o.err != nil { return 0 }
+  return len(o.entries)
+}
+
+// Strrefs returns the overridden string references in ascending order.
+// Operation is skipped if error state is set.
+func (o *Override) Strrefs() []int32 {
+  if o.err != nil { return nil }
+  list := make([]int32, len(o.entries))
+  for i, e := range o.entries { list[i] = e.Strref }
+  sort.Slice(list, func(a, b int) bool { return list[a] < list[b] })
+  return list
+}
+
+// Get returns the override entry of the specified string reference. Returns false if the string reference is not
+// overridden.
+// Operation is skipped if error state is set.
+func (o *Override) Get(strref int32) (OverrideEntry, bool) {
+  if o.err != nil { return OverrideEntry{}, false }
+  if idx := o.indexOf(strref); idx >= 0 { return o.entries[idx], true }
+  return OverrideEntry{}, false
+}
+
+// Set assigns the given entry to the string reference defined by the entry. The entry is added if the string reference
+// is not overridden yet.
+//
+// Sets error state if the string reference is negative or greater than MAX_OVERRIDE_STRREF. Operation is skipped if
+// error state is set.
+func (o *Override) Set(e OverrideEntry) {
+  if o.err != nil { return }
+  if e.Strref < 0 || e.Strref > MAX_OVERRIDE_STRREF { o.err = ietools.ErrIllegalArguments; return }
+  if idx := o.indexOf(e.Strref); idx >= 0 {
+    if o.entries[idx] != e { o.dirty = true }
+    o.entries[idx] = e
+    return
+  }
+  o.entries = append(o.entries, e)
+  o.dirty = true
+}
+
+// Remove removes the override of the specified string reference. Returns false if the string reference is not
+// overridden.
+// Operation is skipped if error state is set.
+func (o *Override) Remove(strref int32) bool {
+  if o.err != nil { return false }
+  idx := o.indexOf(strref)
+  if idx < 0 { return false }
+  o.entries = append(o.entries[:idx], o.entries[idx+1:]...)
+  o.dirty = true
+  return true
+}
+
+// Apply assigns all override entries to the given string table. String references beyond the last entry of the
+// string table are added, filling gaps with empty entries. At most 65536 entries are added.
+//
+// Sets error state without modifying the string table if more entries would have to be added.
+// Operation is skipped if error state of either object is set.
+func (o *Override) Apply(t *Tlk) {
+  if o.err != nil || t.err != nil { return }
+  count := len(t.entries)
+  for _, e := range o.entries {
+    if int(e.Strref) >= count { count = int(e.Strref) + 1 }
+  }
+  if count - len(t.entries) > maxApplyEntries { o.err = ietools.ErrIllegalArguments; return }
+  if count > len(t.entries) { t.entries = append(t.entries, make([]Entry, count - len(t.entries))...) }
+
+  for _, e := range o.entries {
+    entry := Entry{ Sound: e.Sound, Volume: e.Volume, Pitch: e.Pitch, Text: e.Text }
+    if len(entry.Text) > 0 { entry.Flags |= FLAG_TEXT }
+    if len(entry.Sound) > 0 { entry.Flags |= FLAG_SOUND }
+    t.entries[e.Strref] = entry
+    t.lookup = nil
+    t.dirty = true
+  }
+}
+
+
+// Used internally. Returns the index of the entry with the specified string reference, or -1 if not found.
+func (o *Override) indexOf(strref int32) int {
+  for i, e := range o.entries {
+    if e.Strref == strref { return i }
+  }
+  return -1
+}
+
+// Used internally. Parses TOH and TOT data.
+func (o *Override) importOverride(toh, tot *buffers.Buffer) {
+  if toh.BufferLength() < tohHeaderSize || toh.GetString(0x00, 4, false) != "TLK " { o.err = ErrInvalidToh; return }
+  o.header = buffers.Create()
+  o.header.InsertBytes(0, tohHeaderSize)
+  o.header.PutBuffer(0, toh.GetBuffer(0, tohHeaderSize))
+
+  count := int(toh.GetUint32(0x0c))
+  if tohHeaderSize + count * tohEntrySize > toh.BufferLength() { o.err = ErrInvalidToh; return }
+  o.entries = make([]OverrideEntry, count)
+  for i := 0; i < count && toh.Error() == nil; i++ {
+    base := tohHeaderSize + i * tohEntrySize
+    e := &o.entries[i]
+    e.Strref = toh.GetInt32(base)
+    if e.Strref < 0 || e.Strref > MAX_OVERRIDE_STRREF { o.err = ErrInvalidToh; return }
+    e.Unknown = toh.GetUint32(base + 0x04)
+    e.Sound = toh.GetString(base + 0x08, 8, true)
+    e.Volume = toh.GetInt32(base + 0x10)
+    e.Pitch = toh.GetInt32(base + 0x14)
+    e.Text = o.readText(tot, toh.GetInt32(base + 0x18))
+    if o.err != nil { return }
+  }
+  if toh.Error() != nil { o.err = toh.Error() }
+}
+
+// Used internally. Reads the string starting at the specified TOT offset, following linked chunks.
+func (o *Override) readText(tot *buffers.Buffer, ofs int32) string {
+  data := make([]byte, 0)
+  visited := make(map[int32]bool)
+  for ofs >= 0 && !visited[ofs] {
+    if int(ofs) + totEntrySize > tot.BufferLength() { o.err = ErrInvalidToh; return "" }
+    visited[ofs] = true
+    chunk := tot.GetBuffer(int(ofs) + 0x08, totChunkSize)
+    end := 0
+    for end < len(chunk) && chunk[end] != 0 { end++ }
+    data = append(data, chunk[:end]...)
+    if end < len(chunk) { break }
+    ofs = tot.GetInt32(int(ofs) + 0x208)
+  }
+  if o.cmap == nil { return string(data) }
+  s, err := ietools.AnsiToUtf8(data, o.cmap)
+  if err != nil { o.err = err }
+  return s
+}
+
+// Used internally. Assembles the current override table into new TOH and TOT buffers.
+func (o *Override) exportOverride(cmap encoding.Encoding) (toh, tot *buffers.Buffer) {
+  toh = buffers.Create()
+  toh.InsertBytes(0, tohHeaderSize + len(o.entries) * tohEntrySize)
+  toh.PutBuffer(0, o.header.GetBuffer(0, tohHeaderSize))
+  toh.PutUint32(0x0c, uint32(len(o.entries)))
+  tot = buffers.Create()
+
+  for i, e := range o.entries {
+    var data []byte
+    var err error = nil
+    if cmap != nil {
+      data, err = ietools.Utf8ToAnsi(e.Text, cmap)
+    }
+    if cmap == nil || err != nil {
+      data = []byte(e.Text)
+    }
+
+    base := tohHeaderSize + i * tohEntrySize
+    toh.PutInt32(base, e.Strref)
+    toh.PutUint32(base + 0x04, e.Unknown)
+    toh.PutString(base + 0x08, 8, e.Sound)
+    toh.PutInt32(base + 0x10, e.Volume)
+    toh.PutInt32(base + 0x14, e.Pitch)
+    toh.PutInt32(base + 0x18, int32(tot.BufferLength()))
+
+    // strings are stored in linked chunks; the last chunk is null-terminated unless the string fills it completely
+    chunks := (len(data) + totChunkSize) / totChunkSize
+    if len(data) > 0 && len(data) % totChunkSize == 0 { chunks-- }
+    prev := int32(-1)
+    for c := 0; c < chunks; c++ {
+      ofs := tot.BufferLength()
+      tot.InsertBytes(ofs, totEntrySize)
+      tot.PutInt32(ofs, -1)
+      tot.PutInt32(ofs + 0x04, prev)
+      end := (c + 1) * totChunkSize
+      if end > len(data) { end = len(data) }
+      tot.PutBuffer(ofs + 0x08, data[c * totChunkSize:end])
+      next := int32(-1)
+      if c + 1 < chunks { next = int32(ofs + totEntrySize) }
+      tot.PutInt32(ofs + 0x208, next)
+      prev = int32(ofs)
+    }
+  }
+  return
+}
@@ -0,0 +1,296 @@
+/*
+Package tlk provides functions for reading and modifying string tables of the TLK V1 format, such as dialog.tlk, as well
+as TOH/TOT string override files of the Enhanced Edition games.
+*/
+package tlk
+
+import (
+  "errors"
+  "fmt"
+  "io"
+
+  "github.com/InfinityTools/go-ietools"
+  "github.com/InfinityTools/go-ietools/buffers"
+  "golang.org/x/text/encoding"
+  "golang.org/x/text/encoding/charmap"
+)
+
+const (
+  // Available string entry flags
+  FLAG_TEXT     = ietools.BIT0  // Entry provides text
+  FLAG_SOUND    = ietools.BIT1  // Entry provides a sound resource
+  FLAG_TOKEN    = ietools.BIT2  // Text contains tokens
+
+  headerSize    = 0x12
+  entrySize     = 0x1a
+)
+
+// Entry defines a single string entry of the string table.
+type Entry struct {
+  Flags   int       // entry flags (see FLAG_xxx constants)
+  Sound   string    // sound resref
+  Volume  int32     // volume variance
+  Pitch   int32     // pitch variance
+  Text    string    // the string
+}
+
+// Tlk contains the necessary information to query or alter string table data.
+type Tlk struct {
+  language  int                 // language identifier
+  entries   []Entry             // list of string entries
+  cmap      encoding.Encoding   // the character map or encoding to be used for ANSI decoding or encoding
+  lookup    map[string]int      // maps text and sound to the first matching strref, created on demand
+  dirty     bool                // true if content has been modified
+  err       error
+}
+
+
+// Create returns an empty string table for the specified language identifier.
+//
+// Text is encoded in ANSI Windows-1252 when saved.
+func Create(language int) *Tlk {
+  t := Tlk{ language: language, entries: make([]Entry, 0), cmap: charmap.Windows1252 }
+  return &t
+}
+
+// Load uses the given Reader to load string table data from the underlying buffer. The function returns a pointer to
+// the Tlk object.
+//
+// This function assumes that text is encoded in ANSI Windows-1252.
+// Use function Error to check if the Load function returned successfully.
+func Load(r io.Reader) *Tlk {
+  return LoadEx(r, charmap.Windows1252)
+}
+
+// LoadEx uses the given Reader to load string table data from the underlying buffer, using the specified character
+// map or encoding for ANSI decoding. Use ietools.EncodingByLanguage for the multi-byte encodings of Asian releases.
+//
+// Specify a nil encoding to skip the decoding operation, e.g. for UTF-8 encoded string tables of the Enhanced Edition
+// games. Use function Error to check if the Load function returned successfully.
+func LoadEx(r io.Reader, cmap encoding.Encoding) *Tlk {
+  t := Create(0)
+  t.cmap = ietools.NormalizeEncoding(cmap)
+  buf := buffers.Load(r)
+  if buf.Error() != nil { t.err = buf.Error(); return t }
+  t.importTlk(buf)
+  return t
+}
+
+// Save writes the current string table to the specified Writer, encoding text as specified by the Load function.
+//
+// Does nothing if the Tlk is in an invalid state (see Error function).
+func (t *Tlk) Save(w io.Writer) {
+  t.SaveEx(w, t.cmap)
+}
+
+// SaveEx writes the current string table to the specified Writer, using the specified character map or encoding for
+// ANSI encoding.
+//
+// Specify a nil encoding to skip the encoding operation. Does nothing if the Tlk is in an invalid state (see Error
+// function).
+func (t *Tlk) SaveEx(w io.Writer, cmap encoding.Encoding) {
+  if t.err != nil { return }
+
+  buf := t.exportTlk(cmap)
+  if buf.Error() != nil { t.err = buf.Error(); return }
+  buf.Save(w)
+  if buf.Error() != nil { t.err = buf.Error(); return }
+  t.dirty = false
+}
+
+
+// Error returns the error state of the most recent operation on Tlk. Use ClearError function to clear the current
+// error state.
+func (t *Tlk) Error() error {
+  return t.err
+}
+
+// ClearError clears the error state from the last Tlk operation. Must be called for subsequent operations to work
+// correctly.
+func (t *Tlk) ClearError() {
+  t.err = nil
+}
+
+// IsModified returns whether the current string table has been modified by a previous operation.
+// The return value is only provided for informal purposes. None of the Tlk functions rely on it.
+func (t *Tlk) IsModified() bool {
+  return t.dirty
+}
+
+// ClearModified explicitly marks the Tlk object as unmodified.
+func (t *Tlk) ClearModified() {
+  t.dirty = false
+}
+
+
+// GetLanguage returns the language identifier of the string table.
+func (t *Tlk) GetLanguage() int {
+  return t.language
+}
+
+// SetLanguage sets the language identifier of the string table.
+// Operation is skipped if error state is set.
+func (t *Tlk) SetLanguage(language int) {
+  if t.err != nil { return }
+  if t.language != language { t.dirty = true }
+  t.language = language
+}
+
+// Count returns the number of string entries.
+// Operation is skipped if error state is set.
+func (t *Tlk) Count() int {
+  if t.err != nil { return 0 }
+  return len(t.entries)
+}
+
+// GetEntry returns the string entry of the specified strref.
+//
+// Sets error state if strref is out of range. Operation is skipped if error state is set.
+func (t *Tlk) GetEntry(strref int) Entry {
+  if t.err != nil { return Entry{} }
+  if strref < 0 || strref >= len(t.entries) { t.err = ietools.ErrIllegalArguments; return Entry{} }
+  return t.entries[strref]
+}
+
+// PutEntry replaces the string entry of the specified strref.
+//
+// Sets error state if strref is out of range. Operation is skipped if error state is set.
+func (t *Tlk) PutEntry(strref int, entry Entry) {
+  if t.err != nil { return }
+  if strref < 0 || strref >= len(t.entries) { t.err = ietools.ErrIllegalArguments; return }
+  if t.entries[strref] != entry {
+    t.entries[strref] = entry
+    t.lookup = nil
+    t.dirty = true
+  }
+}
+
+// GetString returns the text of the specified strref. Returns an empty string if strref is out of range.
+// Operation is skipped if error state is set.
+func (t *Tlk) GetString(strref int) string {
+  if t.err != nil { return "" }
+  if strref < 0 || strref >= len(t.entries) { return "" }
+  return t.entries[strref].Text
+}
+
+// GetSound returns the sound resref of the specified strref. Returns an empty string if strref is out of range.
+// Operation is skipped if error state is set.
+func (t *Tlk) GetSound(strref int) string {
+  if t.err != nil { return "" }
+  if strref < 0 || strref >= len(t.entries) { return "" }
+  return t.entries[strref].Sound
+}
+
+// FindString returns the first strref with the specified text and sound resref. Returns -1 if no match is found.
+// Operation is skipped if error state is set.
+func (t *Tlk) FindString(text, sound string) int {
+  if t.err != nil { return -1 }
+  if t.lookup == nil {
+    t.lookup = make(map[string]int)
+    for i := len(t.entries) - 1; i >= 0; i-- {
+      t.lookup[lookupKey(t.entries[i].Text, t.entries[i].Sound)] = i
+    }
+  }
+  if idx, ok := t.lookup[lookupKey(text, sound)]; ok { return idx }
+  return -1
+}
+
+// AddString appends a new entry with the specified text and sound resref and returns its strref.
+// Operation is skipped if error state is set.
+func (t *Tlk) AddString(text, sound string) int {
+  if t.err != nil { return -1 }
+
+  e := Entry{ Text: text, Sound: sound }
+  if len(text) > 0 { e.Flags |= FLAG_TEXT }
+  if len(sound) > 0 { e.Flags |= FLAG_SOUND }
+  t.entries = append(t.entries, e)
+  idx := len(t.entries) - 1
+  if t.lookup != nil {
+    if _, ok := t.lookup[lookupKey(text, sound)]; !ok { t.lookup[lookupKey(text, sound)] = idx }
+  }
+  t.dirty = true
+  return idx
+}
+
+// ResolveString returns the first strref with the specified text and sound resref. A new entry is added if no match
+// is found, similar to WeiDU's handling of inline strings.
+// Operation is skipped if error state is set.
+func (t *Tlk) ResolveString(text, sound string) int {
+  if t.err != nil { return -1 }
+  idx := t.FindString(text, sound)
+  if idx < 0 { idx = t.AddString(text, sound) }
+  return idx
+}
+
+
+// Used internally. Returns the map key for the given text and sound.
+func lookupKey(text, sound string) string {
+  return sound + "\x00" + text
+}
+
+// Used internally. Parses string table data from the specified buffer.
+func (t *Tlk) importTlk(buf *buffers.Buffer) {
+  if buf.BufferLength() < headerSize { t.err = errors.New("TLK input buffer too small"); return }
+  sig, ver := buf.GetString(0x00, 4, false), buf.GetString(0x04, 4, false)
+  if sig != "TLK " { t.err = fmt.Errorf("Invalid TLK signature: %q", sig); return }
+  if ver != "V1  " { t.err = fmt.Errorf("Unsupported TLK version: %q", ver); return }
+
+  t.language = int(buf.GetUint16(0x08))
+  count := int(buf.GetUint32(0x0a))
+  ofsStrings := int(buf.GetUint32(0x0e))
+  if count < 0 || headerSize + count * entrySize > buf.BufferLength() { t.err = errors.New("TLK entry count out of range"); return }
+
+  t.entries = make([]Entry, count)
+  for i := 0; i < count && buf.Error() == nil; i++ {
+    base := headerSize + i * entrySize
+    e := &t.entries[i]
+    e.Flags = int(buf.GetUint16(base))
+    e.Sound = buf.GetString(base + 0x02, 8, true)
+    e.Volume = buf.GetInt32(base + 0x0a)
+    e.Pitch = buf.GetInt32(base + 0x0e)
+    ofs, size := int(buf.GetUint32(base + 0x12)), int(buf.GetUint32(base + 0x16))
+    if size > 0 {
+      e.Text = buf.GetStringEx(ofsStrings + ofs, size, false, t.cmap)
+    }
+  }
+  if buf.Error() != nil { t.err = buf.Error() }
+}
+
+// Used internally. Assembles the current string table into a new buffer.
+func (t *Tlk) exportTlk(cmap encoding.Encoding) *buffers.Buffer {
+  cmap = ietools.NormalizeEncoding(cmap)
+  ofsStrings := headerSize + len(t.entries) * entrySize
+  buf := buffers.Create()
+  buf.InsertBytes(0, ofsStrings)
+  buf.PutString(0x00, 4, "TLK ")
+  buf.PutString(0x04, 4, "V1  ")
+  buf.PutUint16(0x08, uint16(t.language))
+  buf.PutUint32(0x0a, uint32(len(t.entries)))
+  buf.PutUint32(0x0e, uint32(ofsStrings))
+
+  pos := 0
+  for i, e := range t.entries {
+    var data []byte
+    var err error = nil
+    if cmap != nil {
+      data, err = ietools.Utf8ToAnsi(e.Text, cmap)
+    }
+    if cmap == nil || err != nil {
+      data = []byte(e.Text)
+    }
+
+    base := headerSize + i * entrySize
+    buf.PutUint16(base, uint16(e.Flags))
+    buf.PutString(base + 0x02, 8, e.Sound)
+    buf.PutInt32(base + 0x0a, e.Volume)
+    buf.PutInt32(base + 0x0e, e.Pitch)
+    buf.PutUint32(base + 0x12, uint32(pos))
+    buf.PutUint32(base + 0x16, uint32(len(data)))
+    if len(data) > 0 {
+      buf.InsertBytes(ofsStrings + pos, len(data))
+      buf.PutBuffer(ofsStrings + pos, data)
+      pos += len(data)
+    }
+  }
+  return buf
+}